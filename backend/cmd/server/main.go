@@ -6,16 +6,20 @@ import (
 	"agent-workspace-manager/internal/config"
 	"agent-workspace-manager/internal/database"
 	"agent-workspace-manager/internal/logger"
+	"agent-workspace-manager/internal/models"
 	"agent-workspace-manager/internal/services/executor"
 	"agent-workspace-manager/internal/services/realtime"
 	"agent-workspace-manager/internal/services/scheduler"
+	"agent-workspace-manager/internal/services/secrets"
 	"agent-workspace-manager/internal/services/telegram"
+	"agent-workspace-manager/internal/services/workspace"
 	"context"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -53,6 +57,11 @@ func main() {
 	// 初始化 Realtime Broker
 	realtime.InitBroker()
 
+	// 初始化機密資料加密金鑰 (必須在存取資料庫之前)
+	if err := secrets.Init(cfg.SecretKey, cfg.SecretKeyFile); err != nil {
+		log.Fatalf("Failed to init secret key: %v", err)
+	}
+
 	// 初始化資料庫連線
 	database.Connect(cfg.DatabaseURL)
 
@@ -62,9 +71,34 @@ func main() {
 	// 初始化 Executor Logger
 	executor.SetLogger(logger.Executor)
 
+	// 初始化非 Git 專案的備份庫 (失敗時仍可執行，但無法還原非 Git 專案的變更)
+	if err := workspace.InitBackupStore(cfg.BackupDir); err != nil {
+		logger.Executor.Error("Failed to init backup store", "dir", cfg.BackupDir, "error", err)
+	}
+	if err := workspace.SetWorktreeRoot(cfg.WorktreeDir); err != nil {
+		logger.Executor.Error("Failed to init worktree directory", "dir", cfg.WorktreeDir, "error", err)
+	}
+	if cfg.AgentEnvWhitelist != "" {
+		executor.SetHostEnvWhitelist(strings.Split(cfg.AgentEnvWhitelist, ","))
+	}
+	if timeout, err := time.ParseDuration(cfg.SessionIdleTimeout); err == nil {
+		executor.SetSessionIdleTimeout(timeout)
+	} else {
+		logger.Executor.Error("Invalid session idle timeout, using default", "value", cfg.SessionIdleTimeout, "error", err)
+	}
+
+	// 初始化持久化執行佇列 (必須在排程器之前，重啟前中斷與排隊中的指令完成後更新排程狀態並以 Telegram 通知)
+	executor.InitQueue(func(execution *models.Execution) {
+		scheduler.HandleExecutionResult(execution)
+		telegram.NotifyExecutionResult(execution)
+	})
+
 	// 初始化排程器
 	scheduler.InitScheduler()
 
+	// 繼續執行重啟前排隊中的指令
+	executor.ResumeQueue()
+
 	// 設定 Gin 的預設 Writer 為 Web Logger
 	gin.DefaultWriter = logger.WebWriter
 
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/utils"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateProfileName 驗證設定檔名稱是否只包含英文、數字、底線與連字號
+func validateProfileName(name string) bool {
+	match, _ := regexp.MatchString("^[a-zA-Z0-9_-]+$", name)
+	return match
+}
+
+// envVarNamePattern 是合法的環境變數名稱
+var envVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// mergeEnvVars 將請求中的環境變數合併到目前的設定
+//
+// 參數:
+//   - current: 目前的環境變數 (不會被修改)。
+//   - updates: 請求中的環境變數；值為 null 表示刪除該變數。
+//
+// 返回:
+//   - map[string]string: 合併後的環境變數。
+//   - error: 名稱不合法時返回可直接回應給使用者的錯誤。
+//
+// 說明:
+//   環境變數的值加密存放且不會經由 API 回傳，因此以合併取代整批覆寫，更新單一變數時不需重送其他機密。
+func mergeEnvVars(current map[string]string, updates map[string]*string) (map[string]string, error) {
+	merged := make(map[string]string, len(current)+len(updates))
+	for name, value := range current {
+		merged[name] = value
+	}
+	for name, value := range updates {
+		if !envVarNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("Invalid environment variable name: %q", name)
+		}
+		if value == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = *value
+		}
+	}
+	return merged, nil
+}
+
+// agentProfileInput 是建立與更新 AgentProfile 的請求內容
+// 更新時未提供的欄位 (nil) 保持不變
+type agentProfileInput struct {
+	Name            *string            `json:"name"`
+	Description     *string            `json:"description"`
+	CommandTemplate *string            `json:"command_template"`
+	EnvVars         map[string]*string `json:"env_vars"`
+	OutputParser    *string            `json:"output_parser" binding:"omitempty,oneof=json fenced_json jsonl text"`
+	TimeoutSeconds  *int               `json:"timeout_seconds" binding:"omitempty,min=0"`
+	PromptDelivery  *string            `json:"prompt_delivery" binding:"omitempty,oneof=argv stdin file"`
+	ResumeArgs      *string            `json:"resume_args"`
+}
+
+// apply 將請求內容套用到 AgentProfile 並驗證結果，錯誤時返回可直接回應給使用者的訊息
+func (input *agentProfileInput) apply(profile *models.AgentProfile) error {
+	if input.Name != nil {
+		profile.Name = *input.Name
+	}
+	if input.Description != nil {
+		profile.Description = *input.Description
+	}
+	if input.CommandTemplate != nil {
+		profile.CommandTemplate = *input.CommandTemplate
+	}
+	if input.EnvVars != nil {
+		env, err := mergeEnvVars(profile.EnvVars, input.EnvVars)
+		if err != nil {
+			return err
+		}
+		profile.EnvVars = env
+	}
+	if input.OutputParser != nil {
+		profile.OutputParser = *input.OutputParser
+	}
+	if input.TimeoutSeconds != nil {
+		profile.TimeoutSeconds = *input.TimeoutSeconds
+	}
+	if input.PromptDelivery != nil {
+		profile.PromptDelivery = *input.PromptDelivery
+	}
+	if input.ResumeArgs != nil {
+		profile.ResumeArgs = *input.ResumeArgs
+	}
+
+	if !validateProfileName(profile.Name) {
+		return fmt.Errorf("Invalid profile name. Only alphanumeric characters, underscores and hyphens are allowed.")
+	}
+	if profile.ResumeArgs != "" {
+		if _, err := utils.ParseResumeTemplate(profile.ResumeArgs); err != nil {
+			return fmt.Errorf("Invalid resume arguments: %v", err)
+		}
+	}
+	return validateCommandTemplate(profile.CommandTemplate, profile.PromptDelivery)
+}
+
+// CreateAgentProfile 建立 AgentProfile
+func CreateAgentProfile(c *gin.Context) {
+	var input agentProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Name == nil || input.CommandTemplate == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and command_template are required"})
+		return
+	}
+
+	var profile models.AgentProfile
+	if err := input.apply(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Create(&profile).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Agent profile name already exists"})
+		return
+	}
+	c.JSON(http.StatusCreated, profile)
+}
+
+// GetAgentProfiles 取得所有 AgentProfile
+func GetAgentProfiles(c *gin.Context) {
+	var profiles []models.AgentProfile
+	database.DB.Order("name asc").Find(&profiles)
+	c.JSON(http.StatusOK, profiles)
+}
+
+// GetAgentProfile 取得單一 AgentProfile
+func GetAgentProfile(c *gin.Context) {
+	var profile models.AgentProfile
+	if err := database.DB.First(&profile, c.Param("profile_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent profile not found"})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateAgentProfile 更新 AgentProfile (所有引用它的專案會在下一次執行時套用)
+func UpdateAgentProfile(c *gin.Context) {
+	var profile models.AgentProfile
+	if err := database.DB.First(&profile, c.Param("profile_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent profile not found"})
+		return
+	}
+
+	var input agentProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := input.apply(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Save(&profile).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Agent profile name already exists"})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// DeleteAgentProfile 刪除 AgentProfile (內建或仍被專案使用的設定檔不可刪除)
+func DeleteAgentProfile(c *gin.Context) {
+	var profile models.AgentProfile
+	if err := database.DB.First(&profile, c.Param("profile_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent profile not found"})
+		return
+	}
+	if profile.BuiltIn {
+		c.JSON(http.StatusConflict, gin.H{"error": "Built-in agent profiles cannot be deleted"})
+		return
+	}
+
+	var inUse int64
+	database.DB.Model(&models.Project{}).Where("agent_profile_id = ?", profile.ID).Count(&inUse)
+	if inUse > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Agent profile is used by %d project(s)", inUse)})
+		return
+	}
+
+	database.DB.Delete(&profile)
+	c.JSON(http.StatusOK, gin.H{"message": "Agent profile deleted"})
+}
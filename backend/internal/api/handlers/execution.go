@@ -5,7 +5,8 @@ import (
 	"agent-workspace-manager/internal/models"
 	"agent-workspace-manager/internal/services/executor"
 	"agent-workspace-manager/internal/services/telegram"
-	"fmt"
+	"agent-workspace-manager/internal/services/workspace"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -30,20 +31,22 @@ func RunProjectCommand(c *gin.Context) {
 		return
 	}
 
-	// 非同步執行指令
-	go executor.ExecuteCommand(uint(projectID), input.Command, func(execution *models.Execution) {
-		// 執行完成後發送 Telegram 通知
-		var project models.Project
-		if err := database.DB.First(&project, execution.ProjectID).Error; err == nil {
-			msg := fmt.Sprintf("Project: %s\nStatus: %s\nSummary: %s", project.Name, execution.Status, execution.Summary)
-			if execution.Status == models.StatusFailed || execution.Status == models.StatusParseFailed {
-				msg += fmt.Sprintf("\nError: %s", execution.ErrorMessage)
-			}
-			telegram.SendNotification(msg)
+	// 加入專案執行佇列 (由 Worker 非同步執行)
+	execution, err := executor.ExecuteCommand(uint(projectID), input.Command, telegram.NotifyExecutionResult)
+	if err != nil {
+		if err == executor.ErrProjectNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue command"})
 		}
-	})
+		return
+	}
 
-	c.JSON(http.StatusAccepted, gin.H{"message": "Command execution started"})
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":        "Command queued",
+		"execution_id":   execution.ID,
+		"queue_position": execution.QueuePosition,
+	})
 }
 
 // GetExecution 取得單一執行記錄詳情
@@ -54,5 +57,195 @@ func GetExecution(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
 		return
 	}
+	execution.QueuePosition = executor.GetQueuePosition(&execution)
+	c.JSON(http.StatusOK, execution)
+}
+
+// GetExecutionDiff 取得執行記錄的 Git Diff 與權威變更檔案列表
+func GetExecutionDiff(c *gin.Context) {
+	executionID := c.Param("execution_id")
+	var execution models.Execution
+	if err := database.DB.First(&execution, executionID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+		return
+	}
+
+	if execution.ChangeSource != models.ChangeSourceGit {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No git diff recorded for this execution"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"execution_id":    execution.ID,
+		"git_base_commit": execution.GitBaseCommit,
+		"git_head_commit": execution.GitHeadCommit,
+		"modified_files":  execution.ModifiedFiles,
+		"created_files":   execution.CreatedFiles,
+		"deleted_files":   execution.DeletedFiles,
+		"diff":            execution.Diff,
+	})
+}
+
+// PinExecution 釘選已結束的執行記錄，之後同專案的每次執行都會在提示詞中包含此記錄
+func PinExecution(c *gin.Context) {
+	setExecutionPinned(c, true)
+}
+
+// UnpinExecution 取消釘選執行記錄
+func UnpinExecution(c *gin.Context) {
+	setExecutionPinned(c, false)
+}
+
+// setExecutionPinned 更新執行記錄的釘選狀態
+func setExecutionPinned(c *gin.Context, pinned bool) {
+	var execution models.Execution
+	if err := database.DB.First(&execution, c.Param("execution_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+		return
+	}
+	// 執行中的記錄結束時會整筆寫回資料庫，釘選狀態會被覆蓋
+	if !execution.IsFinished() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Execution is still queued or running"})
+		return
+	}
+	database.DB.Model(&execution).UpdateColumn("pinned", pinned)
+	execution.Pinned = pinned
+	c.JSON(http.StatusOK, execution)
+}
+
+// GetExecutionHistory 取得執行時提示詞中作為 Context 的過去執行記錄 (由舊到新)
+func GetExecutionHistory(c *gin.Context) {
+	var execution models.Execution
+	if err := database.DB.First(&execution, c.Param("execution_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+		return
+	}
+
+	history := []models.Execution{}
+	if len(execution.HistoryIDs) > 0 {
+		var found []models.Execution
+		database.DB.Where("id IN ?", execution.HistoryIDs).Find(&found)
+		byID := make(map[uint]models.Execution, len(found))
+		for _, e := range found {
+			byID[e.ID] = e
+		}
+		// 依記錄的順序返回，已刪除的執行記錄會被略過
+		for _, id := range execution.HistoryIDs {
+			if e, ok := byID[id]; ok {
+				history = append(history, e)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"execution_id": execution.ID,
+		"history_ids":  execution.HistoryIDs,
+		"history":      history,
+	})
+}
+
+// CancelExecution 取消執行中或排隊中的執行記錄
+func CancelExecution(c *gin.Context) {
+	executionIDStr := c.Param("execution_id")
+	executionID, err := strconv.ParseUint(executionIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid execution ID"})
+		return
+	}
+
+	switch err := executor.CancelExecution(uint(executionID)); err {
+	case nil:
+		c.JSON(http.StatusAccepted, gin.H{"message": "Execution cancellation requested"})
+	case executor.ErrExecutionNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+	case executor.ErrNotCancellable:
+		c.JSON(http.StatusConflict, gin.H{"error": "Execution is not running or queued"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// RevertExecution 將執行記錄的檔案變更還原為執行前的狀態 (還原本身會作為新的執行記錄排入佇列)
+func RevertExecution(c *gin.Context) {
+	executionIDStr := c.Param("execution_id")
+	executionID, err := strconv.ParseUint(executionIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid execution ID"})
+		return
+	}
+
+	revert, err := executor.RevertExecution(uint(executionID), telegram.NotifyExecutionResult)
+	switch err {
+	case nil:
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":        "Revert queued",
+			"execution_id":   revert.ID,
+			"queue_position": revert.QueuePosition,
+		})
+	case executor.ErrExecutionNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+	case executor.ErrNotRevertable, executor.ErrNoSnapshot, executor.ErrAlreadyReverted:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue revert"})
+	}
+}
+
+// ApproveExecution 核准等待審核的執行結果 (worktree 執行會合併回主分支)
+func ApproveExecution(c *gin.Context) {
+	executionIDStr := c.Param("execution_id")
+	executionID, err := strconv.ParseUint(executionIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid execution ID"})
+		return
+	}
+
+	execution, err := executor.ApproveExecution(uint(executionID))
+	if err != nil {
+		respondReviewError(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, execution)
 }
+
+// RejectExecution 拒絕等待審核的執行結果
+// worktree 執行會刪除 worktree；未使用 worktree 的執行變更已寫入專案目錄，會從快照排入還原。
+func RejectExecution(c *gin.Context) {
+	executionIDStr := c.Param("execution_id")
+	executionID, err := strconv.ParseUint(executionIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid execution ID"})
+		return
+	}
+
+	execution, err := executor.RejectExecution(uint(executionID), telegram.NotifyExecutionResult)
+	if err != nil {
+		respondReviewError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, execution)
+}
+
+// respondReviewError 將核准/拒絕的錯誤轉換為對應的 HTTP 狀態碼
+func respondReviewError(c *gin.Context, err error) {
+	switch {
+	case err == executor.ErrExecutionNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+	case err == executor.ErrNotReviewable, err == executor.ErrNotApprovable, errors.Is(err, workspace.ErrMergeFailed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// GetProjectQueue 取得專案目前排隊中的執行記錄
+func GetProjectQueue(c *gin.Context) {
+	projectIDStr := c.Param("id")
+	projectID, err := strconv.ParseUint(projectIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	c.JSON(http.StatusOK, executor.GetQueue(uint(projectID)))
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/executor"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetProjectMemory 取得專案記憶
+func GetProjectMemory(c *gin.Context) {
+	var project models.Project
+	if err := database.DB.First(&project, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"project_id":         project.ID,
+		"memory":             project.Memory,
+		"memory_auto_update": project.MemoryAutoUpdate,
+	})
+}
+
+// UpdateProjectMemory 以請求內容取代整份專案記憶
+func UpdateProjectMemory(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Memory *string `json:"memory" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := executor.SetProjectMemory(uint(projectID), *input.Memory); err != nil {
+		respondMemoryError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"project_id": projectID, "memory": *input.Memory})
+}
+
+// AddProjectMemoryNote 在專案記憶結尾加入一筆筆記
+func AddProjectMemoryNote(c *gin.Context) {
+	projectID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var input struct {
+		Note string `json:"note" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := executor.ValidateMemoryNote(input.Note); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	memory, err := executor.AppendProjectMemory(uint(projectID), []string{input.Note})
+	if err != nil {
+		respondMemoryError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"project_id": projectID, "memory": memory})
+}
+
+// respondMemoryError 將專案記憶操作的錯誤轉換為 HTTP 回應
+func respondMemoryError(c *gin.Context, err error) {
+	switch err {
+	case executor.ErrProjectNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	case executor.ErrMemoryFull:
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project memory"})
+	}
+}
@@ -3,6 +3,9 @@ package handlers
 import (
 	"agent-workspace-manager/internal/database"
 	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/workspace"
+	"agent-workspace-manager/internal/utils"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -34,13 +37,78 @@ func validateDirectory(path string) (string, error) {
 	return absPath, nil
 }
 
+// validateCommandTemplate 驗證 AI CLI 指令模版 (引號、佔位符、與提示詞傳遞方式的相容性)，
+// 錯誤時返回可直接回應給使用者的訊息
+func validateCommandTemplate(template, promptDelivery string) error {
+	parsed, err := utils.ParseCommandTemplate(template)
+	if err == nil {
+		err = parsed.ValidatePromptDelivery(promptDelivery)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid AI CLI command template: %v", err)
+	}
+	return nil
+}
+
+// validateSandboxPaths 驗證沙箱額外可寫入的路徑 (必須是已存在的絕對路徑)，返回清理後的路徑
+func validateSandboxPaths(paths []string) ([]string, error) {
+	cleaned := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("Sandbox writable path must be absolute: %q", path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("Sandbox writable path does not exist: %q", path)
+		}
+		cleaned = append(cleaned, filepath.Clean(path))
+	}
+	return cleaned, nil
+}
+
+// agentProfileExists 判斷 AgentProfile 是否存在
+func agentProfileExists(id uint) bool {
+	var count int64
+	database.DB.Model(&models.AgentProfile{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
+
+// worktreeModeError 是目錄不是 Git 儲存庫卻啟用 worktree 模式時的錯誤訊息
+const worktreeModeError = "Worktree mode requires the directory to be a git repository"
+
 // CreateProject 處理建立新專案的請求
 func CreateProject(c *gin.Context) {
 	var input struct {
-		Name          string `json:"name" binding:"required"`
-		Description   string `json:"description"`
-		AICliCommand  string `json:"ai_cli_command"`
-		DirectoryPath string `json:"directory_path" binding:"required"`
+		Name             string             `json:"name" binding:"required"`
+		Description      string             `json:"description"`
+		AICliCommand     string             `json:"ai_cli_command"`
+		AgentProfileID   *uint              `json:"agent_profile_id"`
+		PromptTemplateID *uint              `json:"prompt_template_id"`
+		Model            string             `json:"model"`
+		PromptDelivery   string             `json:"prompt_delivery" binding:"omitempty,oneof=argv stdin file"`
+		OutputParser     string             `json:"output_parser" binding:"omitempty,oneof=json fenced_json jsonl text"`
+		RepairOutput     bool               `json:"repair_output"`
+		EnvVars          map[string]*string `json:"env_vars"`
+		DirectoryPath    string             `json:"directory_path" binding:"required"`
+		UseWorktree      bool               `json:"use_worktree"`
+		RequireApproval  bool               `json:"require_approval"`
+		// 沙箱設定
+		SandboxMode           string   `json:"sandbox_mode" binding:"omitempty,oneof=bwrap"`
+		SandboxWritablePaths  []string `json:"sandbox_writable_paths"`
+		SandboxDisableNetwork bool     `json:"sandbox_disable_network"`
+		// 非 Git 專案比對變更時額外略過的路徑規則
+		IgnorePatterns []string `json:"ignore_patterns"`
+		// 提示詞中作為 Context 的執行記錄 (history_count 未提供時使用預設值)
+		HistoryCount         *int `json:"history_count" binding:"omitempty,min=0"`
+		HistoryMaxChars      int  `json:"history_max_chars" binding:"min=0"`
+		HistoryIncludeFailed bool `json:"history_include_failed"`
+		HistoryIncludeFiles  bool `json:"history_include_files"`
+		// Agent 回傳的 memory_updates 是否自動加入專案記憶
+		MemoryAutoUpdate bool `json:"memory_auto_update"`
+		// 資源限制 (0 表示使用預設值或不限制)
+		TimeoutSeconds  int `json:"timeout_seconds" binding:"min=0"`
+		MaxOutputBytes  int `json:"max_output_bytes" binding:"min=0"`
+		MemoryLimitMB   int `json:"memory_limit_mb" binding:"min=0"`
+		CPULimitSeconds int `json:"cpu_limit_seconds" binding:"min=0"`
 	}
 
 	// 綁定並驗證 JSON 輸入
@@ -55,6 +123,40 @@ func CreateProject(c *gin.Context) {
 		return
 	}
 
+	// 驗證指令模版 (未設定時允許，執行時才會失敗)
+	if input.AICliCommand != "" {
+		if err := validateCommandTemplate(input.AICliCommand, input.PromptDelivery); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// 驗證 AgentProfile 是否存在
+	if input.AgentProfileID != nil && !agentProfileExists(*input.AgentProfileID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Agent profile not found"})
+		return
+	}
+
+	// 驗證提示詞模版是否存在
+	if input.PromptTemplateID != nil && !promptTemplateExists(*input.PromptTemplateID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Prompt template not found"})
+		return
+	}
+
+	// 驗證環境變數名稱
+	envVars, err := mergeEnvVars(nil, input.EnvVars)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 驗證沙箱額外可寫入的路徑
+	sandboxPaths, err := validateSandboxPaths(input.SandboxWritablePaths)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 驗證目錄路徑
 	absPath, err := validateDirectory(input.DirectoryPath)
 	if err != nil {
@@ -68,12 +170,45 @@ func CreateProject(c *gin.Context) {
 		return
 	}
 
+	// worktree 模式需要 Git 儲存庫
+	if input.UseWorktree && !workspace.IsGitRepo(absPath) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": worktreeModeError})
+		return
+	}
+
 	// 建立專案模型
 	project := models.Project{
-		Name:          input.Name,
-		Description:   input.Description,
-		AICliCommand:  input.AICliCommand,
-		DirectoryPath: absPath,
+		Name:             input.Name,
+		Description:      input.Description,
+		AICliCommand:     input.AICliCommand,
+		AgentProfileID:   input.AgentProfileID,
+		PromptTemplateID: input.PromptTemplateID,
+		ModelName:        input.Model,
+		PromptDelivery:   input.PromptDelivery,
+		OutputParser:     input.OutputParser,
+		RepairOutput:     input.RepairOutput,
+		EnvVars:          envVars,
+		DirectoryPath:    absPath,
+		UseWorktree:      input.UseWorktree,
+		RequireApproval:  input.RequireApproval,
+
+		SandboxMode:           input.SandboxMode,
+		SandboxWritablePaths:  sandboxPaths,
+		SandboxDisableNetwork: input.SandboxDisableNetwork,
+
+		IgnorePatterns: input.IgnorePatterns,
+
+		HistoryCount:         input.HistoryCount,
+		HistoryMaxChars:      input.HistoryMaxChars,
+		HistoryIncludeFailed: input.HistoryIncludeFailed,
+		HistoryIncludeFiles:  input.HistoryIncludeFiles,
+
+		MemoryAutoUpdate: input.MemoryAutoUpdate,
+
+		TimeoutSeconds:  input.TimeoutSeconds,
+		MaxOutputBytes:  input.MaxOutputBytes,
+		MemoryLimitMB:   input.MemoryLimitMB,
+		CPULimitSeconds: input.CPULimitSeconds,
 	}
 
 	// 儲存至資料庫
@@ -96,7 +231,7 @@ func GetProjects(c *gin.Context) {
 func GetProject(c *gin.Context) {
 	id := c.Param("id")
 	var project models.Project
-	if err := database.DB.First(&project, id).Error; err != nil {
+	if err := database.DB.Preload("AgentProfile").First(&project, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 		return
 	}
@@ -113,10 +248,40 @@ func UpdateProject(c *gin.Context) {
 	}
 
 	var input struct {
-		Name          string `json:"name"`
-		Description   string `json:"description"`
-		AICliCommand  string `json:"ai_cli_command"`
-		DirectoryPath string `json:"directory_path"`
+		Name           string `json:"name"`
+		Description    string `json:"description"`
+		AICliCommand   string `json:"ai_cli_command"`
+		AgentProfileID *uint  `json:"agent_profile_id"`
+		// PromptTemplateID 為 0 時改用 default 模版
+		PromptTemplateID *uint   `json:"prompt_template_id"`
+		Model            *string `json:"model"`
+		PromptDelivery   *string `json:"prompt_delivery" binding:"omitempty,oneof=argv stdin file"`
+		// OutputParser 為空字串時改用 AgentProfile 的設定
+		OutputParser *string `json:"output_parser" binding:"omitempty,oneof='' json fenced_json jsonl text"`
+		RepairOutput *bool   `json:"repair_output"`
+		// EnvVars 與現有的環境變數合併，值為 null 時刪除該變數
+		EnvVars         map[string]*string `json:"env_vars"`
+		DirectoryPath   string             `json:"directory_path"`
+		UseWorktree     *bool              `json:"use_worktree"`
+		RequireApproval *bool              `json:"require_approval"`
+		// 沙箱設定 (sandbox_mode 為空字串時停用沙箱)
+		SandboxMode           *string  `json:"sandbox_mode" binding:"omitempty,oneof='' bwrap"`
+		SandboxWritablePaths  []string `json:"sandbox_writable_paths"`
+		SandboxDisableNetwork *bool    `json:"sandbox_disable_network"`
+		// 非 Git 專案比對變更時額外略過的路徑規則 (提供時取代現有設定)
+		IgnorePatterns []string `json:"ignore_patterns"`
+		// 提示詞中作為 Context 的執行記錄 (history_count 為 -1 時恢復預設值)
+		HistoryCount         *int  `json:"history_count" binding:"omitempty,min=-1"`
+		HistoryMaxChars      *int  `json:"history_max_chars" binding:"omitempty,min=0"`
+		HistoryIncludeFailed *bool `json:"history_include_failed"`
+		HistoryIncludeFiles  *bool `json:"history_include_files"`
+		// Agent 回傳的 memory_updates 是否自動加入專案記憶 (專案記憶本身以 /memory 端點編輯)
+		MemoryAutoUpdate *bool `json:"memory_auto_update"`
+		// 資源限制 (0 表示使用預設值或不限制)
+		TimeoutSeconds  *int `json:"timeout_seconds" binding:"omitempty,min=0"`
+		MaxOutputBytes  *int `json:"max_output_bytes" binding:"omitempty,min=0"`
+		MemoryLimitMB   *int `json:"memory_limit_mb" binding:"omitempty,min=0"`
+		CPULimitSeconds *int `json:"cpu_limit_seconds" binding:"omitempty,min=0"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -155,8 +320,124 @@ func UpdateProject(c *gin.Context) {
 	if input.AICliCommand != "" {
 		project.AICliCommand = input.AICliCommand
 	}
+	// agent_profile_id 為 0 時取消引用，改用專案自身的 ai_cli_command
+	if input.AgentProfileID != nil {
+		if *input.AgentProfileID == 0 {
+			project.AgentProfileID = nil
+		} else if !agentProfileExists(*input.AgentProfileID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Agent profile not found"})
+			return
+		} else {
+			project.AgentProfileID = input.AgentProfileID
+		}
+		project.AgentProfile = nil
+	}
+	// prompt_template_id 為 0 時取消指定，改用 default 模版
+	if input.PromptTemplateID != nil {
+		if *input.PromptTemplateID == 0 {
+			project.PromptTemplateID = nil
+		} else if !promptTemplateExists(*input.PromptTemplateID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Prompt template not found"})
+			return
+		} else {
+			project.PromptTemplateID = input.PromptTemplateID
+		}
+	}
+	if input.Model != nil {
+		project.ModelName = *input.Model
+	}
+	if input.PromptDelivery != nil {
+		project.PromptDelivery = *input.PromptDelivery
+	}
+	if input.OutputParser != nil {
+		project.OutputParser = *input.OutputParser
+	}
+	if input.RepairOutput != nil {
+		project.RepairOutput = *input.RepairOutput
+	}
+	// 指令模版或提示詞傳遞方式變更時，驗證兩者的組合
+	if project.AICliCommand != "" && (input.AICliCommand != "" || input.PromptDelivery != nil) {
+		if err := validateCommandTemplate(project.AICliCommand, project.PromptDelivery); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if input.EnvVars != nil {
+		envVars, err := mergeEnvVars(project.EnvVars, input.EnvVars)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		project.EnvVars = envVars
+	}
+	if input.SandboxMode != nil {
+		project.SandboxMode = *input.SandboxMode
+	}
+	if input.SandboxWritablePaths != nil {
+		paths, err := validateSandboxPaths(input.SandboxWritablePaths)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		project.SandboxWritablePaths = paths
+	}
+	if input.SandboxDisableNetwork != nil {
+		project.SandboxDisableNetwork = *input.SandboxDisableNetwork
+	}
+	if input.IgnorePatterns != nil {
+		project.IgnorePatterns = input.IgnorePatterns
+	}
+	if input.HistoryCount != nil {
+		if *input.HistoryCount < 0 {
+			project.HistoryCount = nil
+		} else {
+			project.HistoryCount = input.HistoryCount
+		}
+	}
+	if input.HistoryMaxChars != nil {
+		project.HistoryMaxChars = *input.HistoryMaxChars
+	}
+	if input.HistoryIncludeFailed != nil {
+		project.HistoryIncludeFailed = *input.HistoryIncludeFailed
+	}
+	if input.HistoryIncludeFiles != nil {
+		project.HistoryIncludeFiles = *input.HistoryIncludeFiles
+	}
+	if input.MemoryAutoUpdate != nil {
+		project.MemoryAutoUpdate = *input.MemoryAutoUpdate
+	}
+	if input.TimeoutSeconds != nil {
+		project.TimeoutSeconds = *input.TimeoutSeconds
+	}
+	if input.MaxOutputBytes != nil {
+		project.MaxOutputBytes = *input.MaxOutputBytes
+	}
+	if input.MemoryLimitMB != nil {
+		project.MemoryLimitMB = *input.MemoryLimitMB
+	}
+	if input.CPULimitSeconds != nil {
+		project.CPULimitSeconds = *input.CPULimitSeconds
+	}
+	if input.UseWorktree != nil {
+		project.UseWorktree = *input.UseWorktree
+	}
+	if input.RequireApproval != nil {
+		project.RequireApproval = *input.RequireApproval
+	}
+	if project.UseWorktree && !workspace.IsGitRepo(project.DirectoryPath) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": worktreeModeError})
+		return
+	}
 
-	database.DB.Save(&project)
+	// 專案記憶由記憶 API、Telegram 與 Agent 各自更新，不寫回讀取時可能已過期的內容
+	if err := database.DB.Omit("memory").Save(&project).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
+		return
+	}
+	var current models.Project
+	if err := database.DB.Select("memory").First(&current, project.ID).Error; err == nil {
+		project.Memory = current.Memory
+	}
 	c.JSON(http.StatusOK, project)
 }
 
@@ -187,4 +468,4 @@ func GetProjectExecutions(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, executions)
-}
\ No newline at end of file
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/executor"
+	"agent-workspace-manager/internal/utils"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validatePromptTemplate 驗證提示詞模版 (語法與欄位名稱)，錯誤時返回可直接回應給使用者的訊息
+func validatePromptTemplate(content string) error {
+	if _, err := utils.ParsePromptTemplate(content); err != nil {
+		return fmt.Errorf("Invalid prompt template: %v", err)
+	}
+	return nil
+}
+
+// promptTemplateExists 判斷提示詞模版是否存在
+func promptTemplateExists(id uint) bool {
+	var count int64
+	database.DB.Model(&models.PromptTemplate{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
+
+// promptTemplateInput 是建立與更新提示詞模版的請求內容
+// 更新時未提供的欄位 (nil) 保持不變
+type promptTemplateInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Content     *string `json:"content"`
+}
+
+// apply 將請求內容套用到提示詞模版並驗證結果，錯誤時返回可直接回應給使用者的訊息
+func (input *promptTemplateInput) apply(tmpl *models.PromptTemplate) error {
+	if input.Name != nil {
+		if tmpl.BuiltIn && *input.Name != tmpl.Name {
+			return fmt.Errorf("Built-in prompt templates cannot be renamed")
+		}
+		tmpl.Name = *input.Name
+	}
+	if input.Description != nil {
+		tmpl.Description = *input.Description
+	}
+	if input.Content != nil {
+		tmpl.Content = *input.Content
+	}
+
+	if !validateProfileName(tmpl.Name) {
+		return fmt.Errorf("Invalid template name. Only alphanumeric characters, underscores and hyphens are allowed.")
+	}
+	return validatePromptTemplate(tmpl.Content)
+}
+
+// CreatePromptTemplate 建立提示詞模版
+func CreatePromptTemplate(c *gin.Context) {
+	var input promptTemplateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Name == nil || input.Content == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and content are required"})
+		return
+	}
+
+	var tmpl models.PromptTemplate
+	if err := input.apply(&tmpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Create(&tmpl).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Prompt template name already exists"})
+		return
+	}
+	c.JSON(http.StatusCreated, tmpl)
+}
+
+// GetPromptTemplates 取得所有提示詞模版
+func GetPromptTemplates(c *gin.Context) {
+	var templates []models.PromptTemplate
+	database.DB.Order("name asc").Find(&templates)
+	c.JSON(http.StatusOK, templates)
+}
+
+// GetPromptTemplate 取得單一提示詞模版
+func GetPromptTemplate(c *gin.Context) {
+	var tmpl models.PromptTemplate
+	if err := database.DB.First(&tmpl, c.Param("template_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prompt template not found"})
+		return
+	}
+	c.JSON(http.StatusOK, tmpl)
+}
+
+// UpdatePromptTemplate 更新提示詞模版 (所有使用它的專案會在下一次執行時套用)
+func UpdatePromptTemplate(c *gin.Context) {
+	var tmpl models.PromptTemplate
+	if err := database.DB.First(&tmpl, c.Param("template_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prompt template not found"})
+		return
+	}
+
+	var input promptTemplateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := input.apply(&tmpl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Save(&tmpl).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Prompt template name already exists"})
+		return
+	}
+	c.JSON(http.StatusOK, tmpl)
+}
+
+// DeletePromptTemplate 刪除提示詞模版 (內建或仍被專案使用的模版不可刪除)
+func DeletePromptTemplate(c *gin.Context) {
+	var tmpl models.PromptTemplate
+	if err := database.DB.First(&tmpl, c.Param("template_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prompt template not found"})
+		return
+	}
+	if tmpl.BuiltIn {
+		c.JSON(http.StatusConflict, gin.H{"error": "Built-in prompt templates cannot be deleted"})
+		return
+	}
+
+	var inUse int64
+	database.DB.Model(&models.Project{}).Where("prompt_template_id = ?", tmpl.ID).Count(&inUse)
+	if inUse > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Prompt template is used by %d project(s)", inUse)})
+		return
+	}
+
+	database.DB.Delete(&tmpl)
+	c.JSON(http.StatusOK, gin.H{"message": "Prompt template deleted"})
+}
+
+// PreviewPrompt 返回專案執行指令時實際送給 AI CLI 的提示詞，不會執行任何指令
+//
+// 說明:
+//   請求可提供 template 預覽尚未儲存的模版內容；未提供時使用專案目前的模版。
+func PreviewPrompt(c *gin.Context) {
+	var project models.Project
+	if err := database.DB.First(&project, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	var input struct {
+		Command  string `json:"command"`
+		Template string `json:"template"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Template != "" {
+		if err := validatePromptTemplate(input.Template); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	execution := models.Execution{ProjectID: project.ID, Command: input.Command}
+	prompt, err := executor.BuildExecutionPrompt(&project, &execution, input.Template)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"prompt":          prompt.Prompt,
+		"prompt_template": prompt.Template,
+		"prompt_bytes":    len(prompt.Prompt),
+		"history_ids":     prompt.HistoryIDs,
+	})
+}
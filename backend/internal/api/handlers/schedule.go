@@ -21,8 +21,14 @@ func CreateSchedule(c *gin.Context) {
 	}
 
 	var input struct {
-		Command       string    `json:"command" binding:"required"`
-		ScheduledTime time.Time `json:"scheduled_time" binding:"required"`
+		Command        string    `json:"command" binding:"required"`
+		ScheduledTime  time.Time `json:"scheduled_time"`
+		CronExpression string    `json:"cron_expression"`
+		Timezone       string    `json:"timezone"`
+		MaxRetries     int       `json:"max_retries" binding:"min=0,max=10"`
+		RetryDelay     int       `json:"retry_delay_seconds" binding:"min=0"`
+		MisfirePolicy  string    `json:"misfire_policy" binding:"omitempty,oneof=run_once skip grace"`
+		MisfireGrace   int       `json:"misfire_grace_seconds" binding:"min=0"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -30,25 +36,44 @@ func CreateSchedule(c *gin.Context) {
 		return
 	}
 
-	// 檢查排程時間是否在未來
-	if input.ScheduledTime.Before(time.Now()) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Scheduled time must be in the future"})
-		return
+	if input.CronExpression != "" {
+		// 週期排程：驗證 Cron 表達式並計算第一次執行時間
+		next, err := scheduler.NextRunTime(input.CronExpression, input.Timezone, time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cron expression: " + err.Error()})
+			return
+		}
+		input.ScheduledTime = next
+	} else {
+		if input.ScheduledTime.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Either scheduled_time or cron_expression is required"})
+			return
+		}
+		// 檢查排程時間是否在未來
+		if input.ScheduledTime.Before(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Scheduled time must be in the future"})
+			return
+		}
 	}
 
-	// 檢查是否已有等待中的排程 (每個專案只能有一個)
-	var count int64
-	database.DB.Model(&models.Schedule{}).Where("project_id = ? AND status = ?", projectID, models.SchedulePending).Count(&count)
-	if count > 0 {
-		c.JSON(http.StatusConflict, gin.H{"error": "Project already has a pending schedule"})
+	// 確認專案存在 (同一專案可有多個等待中的排程)
+	var project models.Project
+	if err := database.DB.First(&project, projectID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
 		return
 	}
 
 	schedule := models.Schedule{
-		ProjectID:     uint(projectID),
-		Command:       input.Command,
-		ScheduledTime: input.ScheduledTime,
-		Status:        models.SchedulePending,
+		ProjectID:           uint(projectID),
+		Command:             input.Command,
+		ScheduledTime:       input.ScheduledTime,
+		CronExpression:      input.CronExpression,
+		Timezone:            input.Timezone,
+		MaxRetries:          input.MaxRetries,
+		RetryDelaySeconds:   input.RetryDelay,
+		MisfirePolicy:       input.MisfirePolicy,
+		MisfireGraceSeconds: input.MisfireGrace,
+		Status:              models.SchedulePending,
 	}
 
 	if err := database.DB.Create(&schedule).Error; err != nil {
@@ -71,6 +96,189 @@ func GetSchedules(c *gin.Context) {
 	c.JSON(http.StatusOK, schedules)
 }
 
+// findProjectSchedule 根據路由參數取得屬於該專案的排程
+//
+// 返回:
+//   - *models.Schedule: 找到的排程；若參數無效或排程不存在，已寫入錯誤回應並返回 nil。
+func findProjectSchedule(c *gin.Context) *models.Schedule {
+	projectID := c.Param("id")
+	scheduleID, err := strconv.ParseUint(c.Param("schedule_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return nil
+	}
+
+	var schedule models.Schedule
+	if err := database.DB.Where("project_id = ?", projectID).First(&schedule, scheduleID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return nil
+	}
+	return &schedule
+}
+
+// UpdateSchedule 更新排程任務 (指令、時間或 Cron 設定)，並重新排程
+func UpdateSchedule(c *gin.Context) {
+	schedule := findProjectSchedule(c)
+	if schedule == nil {
+		return
+	}
+
+	// 已觸發或已結束的排程不可修改
+	if schedule.Status != models.SchedulePending && schedule.Status != models.SchedulePaused {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only pending or paused schedules can be updated"})
+		return
+	}
+
+	var input struct {
+		Command        string    `json:"command"`
+		ScheduledTime  time.Time `json:"scheduled_time"`
+		CronExpression *string   `json:"cron_expression"` // 傳入空字串可將週期排程改為一次性排程
+		Timezone       *string   `json:"timezone"`
+		MaxRetries     *int      `json:"max_retries" binding:"omitempty,min=0,max=10"`
+		RetryDelay     *int      `json:"retry_delay_seconds" binding:"omitempty,min=0"`
+		MisfirePolicy  string    `json:"misfire_policy" binding:"omitempty,oneof=run_once skip grace"`
+		MisfireGrace   *int      `json:"misfire_grace_seconds" binding:"omitempty,min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Command != "" {
+		schedule.Command = input.Command
+	}
+	if input.CronExpression != nil {
+		schedule.CronExpression = *input.CronExpression
+	}
+	if input.Timezone != nil {
+		schedule.Timezone = *input.Timezone
+	}
+	if input.MaxRetries != nil {
+		schedule.MaxRetries = *input.MaxRetries
+	}
+	if input.RetryDelay != nil {
+		schedule.RetryDelaySeconds = *input.RetryDelay
+	}
+	if input.MisfirePolicy != "" {
+		schedule.MisfirePolicy = input.MisfirePolicy
+	}
+	if input.MisfireGrace != nil {
+		schedule.MisfireGraceSeconds = *input.MisfireGrace
+	}
+	// 設定變更後重新計算重試次數
+	schedule.RetryCount = 0
+
+	if schedule.IsRecurring() {
+		next, err := scheduler.NextRunTime(schedule.CronExpression, schedule.Timezone, time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cron expression: " + err.Error()})
+			return
+		}
+		schedule.ScheduledTime = next
+	} else {
+		if !input.ScheduledTime.IsZero() {
+			schedule.ScheduledTime = input.ScheduledTime
+		}
+		// 檢查排程時間是否在未來
+		if schedule.ScheduledTime.Before(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Scheduled time must be in the future"})
+			return
+		}
+		// 一次性排程沒有暫停狀態
+		schedule.Status = models.SchedulePending
+	}
+
+	if err := database.DB.Save(schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+
+	// 停止舊的定時器或 Cron Entry，並依新設定重新排程 (暫停中的週期排程維持暫停)
+	scheduler.UnscheduleJob(schedule.ID)
+	if schedule.Status == models.SchedulePending {
+		scheduler.ScheduleJob(*schedule)
+		database.DB.First(schedule, schedule.ID)
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// DeleteSchedule 刪除排程任務，並停止其定時器或 Cron Entry
+func DeleteSchedule(c *gin.Context) {
+	schedule := findProjectSchedule(c)
+	if schedule == nil {
+		return
+	}
+
+	scheduler.UnscheduleJob(schedule.ID)
+	if err := database.DB.Delete(schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+}
+
+// respondScheduleError 將 scheduler 服務的錯誤轉換為 HTTP 回應
+func respondScheduleError(c *gin.Context, err error) {
+	switch err {
+	case scheduler.ErrScheduleNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+	case scheduler.ErrNotRecurring:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only recurring schedules can be paused or resumed"})
+	case scheduler.ErrInvalidScheduleState:
+		c.JSON(http.StatusConflict, gin.H{"error": "Schedule cannot be changed in its current status"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// PauseSchedule 暫停週期排程
+func PauseSchedule(c *gin.Context) {
+	schedule := findProjectSchedule(c)
+	if schedule == nil {
+		return
+	}
+
+	updated, err := scheduler.PauseSchedule(schedule.ID)
+	if err != nil {
+		respondScheduleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// ResumeSchedule 恢復已暫停的週期排程
+func ResumeSchedule(c *gin.Context) {
+	schedule := findProjectSchedule(c)
+	if schedule == nil {
+		return
+	}
+
+	updated, err := scheduler.ResumeSchedule(schedule.ID)
+	if err != nil {
+		respondScheduleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// GetScheduleExecutions 取得排程觸發的執行記錄 (執行歷史)
+func GetScheduleExecutions(c *gin.Context) {
+	schedule := findProjectSchedule(c)
+	if schedule == nil {
+		return
+	}
+
+	var executions []models.Execution
+	// 依照建立時間倒序排列
+	if err := database.DB.Where("schedule_id = ?", schedule.ID).Order("created_at desc").Find(&executions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch executions"})
+		return
+	}
+	c.JSON(http.StatusOK, executions)
+}
+
 // GetAllSchedules 取得系統中所有等待中的排程
 func GetAllSchedules(c *gin.Context) {
 	var schedules []models.Schedule
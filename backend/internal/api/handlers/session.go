@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"agent-workspace-manager/internal/services/executor"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSession 取得對話工作階段與其執行記錄 (由舊到新)
+func GetSession(c *gin.Context) {
+	sessionID, err := strconv.ParseUint(c.Param("session_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	session, err := executor.GetSession(uint(sessionID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
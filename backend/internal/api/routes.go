@@ -21,8 +21,18 @@ func SetupRoutes(r *gin.Engine) {
 			projects.DELETE("/:id", handlers.DeleteProject)     // 刪除專案
 			projects.POST("/:id/run", handlers.RunProjectCommand) // 執行專案指令
 			projects.GET("/:id/executions", handlers.GetProjectExecutions) // 取得專案執行記錄
+			projects.GET("/:id/queue", handlers.GetProjectQueue)           // 取得專案執行佇列
+			projects.GET("/:id/memory", handlers.GetProjectMemory)            // 取得專案記憶
+			projects.PUT("/:id/memory", handlers.UpdateProjectMemory)         // 取代專案記憶
+			projects.POST("/:id/memory/notes", handlers.AddProjectMemoryNote) // 新增一筆專案記憶
+			projects.POST("/:id/prompt/preview", handlers.PreviewPrompt)   // 預覽執行時的完整提示詞 (不執行)
 			projects.POST("/:id/schedules", handlers.CreateSchedule) // 建立排程
 			projects.GET("/:id/schedules", handlers.GetSchedules)    // 取得排程列表
+			projects.PUT("/:id/schedules/:schedule_id", handlers.UpdateSchedule)                 // 更新排程
+			projects.DELETE("/:id/schedules/:schedule_id", handlers.DeleteSchedule)              // 刪除排程
+			projects.POST("/:id/schedules/:schedule_id/pause", handlers.PauseSchedule)           // 暫停週期排程
+			projects.POST("/:id/schedules/:schedule_id/resume", handlers.ResumeSchedule)         // 恢復週期排程
+			projects.GET("/:id/schedules/:schedule_id/executions", handlers.GetScheduleExecutions) // 取得排程執行歷史
 		}
 
 		// 執行記錄相關路由
@@ -31,6 +41,40 @@ func SetupRoutes(r *gin.Engine) {
 			executions.GET("/:execution_id", handlers.GetExecution) // 取得單一執行記錄
 			// SSE 串流路由
 			executions.GET("/:execution_id/stream", handlers.StreamExecutionLogs) 
+			executions.POST("/:execution_id/cancel", handlers.CancelExecution) // 取消執行
+			executions.POST("/:execution_id/revert", handlers.RevertExecution) // 還原執行的檔案變更
+			executions.POST("/:execution_id/approve", handlers.ApproveExecution) // 核准執行結果 (worktree 執行會合併)
+			executions.POST("/:execution_id/reject", handlers.RejectExecution)   // 拒絕執行結果 (刪除 worktree 或還原變更)
+			executions.GET("/:execution_id/diff", handlers.GetExecutionDiff)   // 取得 Git Diff
+			executions.GET("/:execution_id/history", handlers.GetExecutionHistory) // 取得提示詞中作為 Context 的執行記錄
+			executions.POST("/:execution_id/pin", handlers.PinExecution)           // 釘選執行記錄 (永遠包含在提示詞 Context 中)
+			executions.POST("/:execution_id/unpin", handlers.UnpinExecution)       // 取消釘選
+		}
+
+		// 對話工作階段相關路由
+		sessions := api.Group("/sessions")
+		{
+			sessions.GET("/:session_id", handlers.GetSession) // 取得工作階段與其執行記錄
+		}
+
+		// AgentProfile 相關路由
+		profiles := api.Group("/agent-profiles")
+		{
+			profiles.POST("", handlers.CreateAgentProfile)                // 建立 AgentProfile
+			profiles.GET("", handlers.GetAgentProfiles)                   // 取得 AgentProfile 列表
+			profiles.GET("/:profile_id", handlers.GetAgentProfile)        // 取得單一 AgentProfile
+			profiles.PUT("/:profile_id", handlers.UpdateAgentProfile)     // 更新 AgentProfile
+			profiles.DELETE("/:profile_id", handlers.DeleteAgentProfile)  // 刪除 AgentProfile
+		}
+
+		// 提示詞模版相關路由
+		promptTemplates := api.Group("/prompt-templates")
+		{
+			promptTemplates.POST("", handlers.CreatePromptTemplate)                // 建立提示詞模版
+			promptTemplates.GET("", handlers.GetPromptTemplates)                   // 取得提示詞模版列表
+			promptTemplates.GET("/:template_id", handlers.GetPromptTemplate)       // 取得單一提示詞模版
+			promptTemplates.PUT("/:template_id", handlers.UpdatePromptTemplate)    // 更新提示詞模版
+			promptTemplates.DELETE("/:template_id", handlers.DeletePromptTemplate) // 刪除提示詞模版
 		}
 
 		// 系統設定相關路由
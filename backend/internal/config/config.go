@@ -13,6 +13,12 @@ type Config struct {
 	DatabaseURL      string // 資料庫連線字串
 	TelegramBotToken string // Telegram Bot Token
 	TelegramWhitelist string // Telegram 白名單 (逗號分隔)
+	BackupDir        string // 非 Git 專案的檔案備份目錄 (用於還原執行變更)
+	WorktreeDir      string // worktree 模式專案建立執行專用 worktree 的目錄
+	SecretKey        string // 加密專案環境變數的金鑰 (未設定時使用 SecretKeyFile)
+	SecretKeyFile    string // 金鑰檔路徑 (不存在時自動產生)
+	AgentEnvWhitelist string // AI CLI 可繼承的伺服器環境變數 (逗號分隔，支援前綴比對例如 LC_*)
+	SessionIdleTimeout string // 對話工作階段的閒置過期時間 (Go duration 格式，例如 30m、2h)
 }
 
 // LoadConfig 從環境變數或 .env 檔案載入設定
@@ -27,6 +33,12 @@ func LoadConfig() *Config {
 		DatabaseURL:      getEnv("DATABASE_URL", "agent_workspace.db"),
 		TelegramBotToken: getEnv("TELEGRAM_BOT_TOKEN", ""),
 		TelegramWhitelist: getEnv("TELEGRAM_WHITELIST", ""),
+		BackupDir:        getEnv("BACKUP_DIR", "backups"),
+		WorktreeDir:      getEnv("WORKTREE_DIR", "worktrees"),
+		SecretKey:        getEnv("SECRET_KEY", ""),
+		SecretKeyFile:    getEnv("SECRET_KEY_FILE", "secret.key"),
+		AgentEnvWhitelist: getEnv("AGENT_ENV_WHITELIST", ""),
+		SessionIdleTimeout: getEnv("SESSION_IDLE_TIMEOUT", "1h"),
 	}
 }
 
@@ -28,9 +28,17 @@ func Connect(databaseURL string) {
 		&models.Execution{},
 		&models.Schedule{},
 		&models.Setting{},
+		&models.AgentProfile{},
+		&models.PromptTemplate{},
+		&models.Session{},
+		&models.NotificationMessage{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 	log.Println("Database migration completed")
+
+	// 建立內建的 AgentProfile 與提示詞模版
+	seedAgentProfiles()
+	seedPromptTemplates()
 }
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"agent-workspace-manager/internal/services/secrets"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+}
+
+// encryptedSerializer 以 JSON 序列化欄位後加密存入資料庫 (gorm 標籤 serializer:encrypted)
+//
+// 說明:
+//   零值 (例如空的 map) 存為 NULL，不需要金鑰；讀取時尚未加密的舊資料會直接以 JSON 解析，
+//   下次儲存時即會加密。
+type encryptedSerializer struct{}
+
+// Scan 實作 schema.SerializerInterface
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	fieldValue := reflect.New(field.FieldType)
+
+	var stored string
+	switch v := dbValue.(type) {
+	case []byte:
+		stored = string(v)
+	case string:
+		stored = v
+	case nil:
+	default:
+		return fmt.Errorf("unsupported encrypted value type %T", dbValue)
+	}
+
+	if stored != "" {
+		data := []byte(stored)
+		if secrets.IsEncrypted(stored) {
+			plaintext, err := secrets.Decrypt(stored)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+			}
+			data = plaintext
+		}
+		if err := json.Unmarshal(data, fieldValue.Interface()); err != nil {
+			return err
+		}
+	}
+
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+// Value 實作 schema.SerializerValuerInterface
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value := reflect.ValueOf(fieldValue)
+	if !value.IsValid() || value.IsZero() || (value.Kind() == reflect.Map && value.Len() == 0) {
+		return nil, nil
+	}
+	data, err := json.Marshal(fieldValue)
+	if err != nil {
+		return nil, err
+	}
+	return secrets.Encrypt(data)
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/utils"
+	"log"
+)
+
+// builtInAgentProfiles 是系統內建的常用 AI CLI 設定檔
+var builtInAgentProfiles = []models.AgentProfile{
+	{
+		Name:            "claude-cli",
+		Description:     "Claude Code CLI (non-interactive print mode, prompt via stdin)",
+		CommandTemplate: "claude -p --permission-mode acceptEdits",
+		PromptDelivery:  models.PromptDeliveryStdin,
+	},
+	{
+		Name:            "gemini-default",
+		Description:     "Gemini CLI (auto-approve tool calls, prompt via stdin)",
+		CommandTemplate: "gemini --yolo",
+		PromptDelivery:  models.PromptDeliveryStdin,
+	},
+	{
+		Name:            "aider-strict",
+		Description:     "Aider without auto-commits (prompt via message file)",
+		CommandTemplate: "aider --yes-always --no-auto-commits --no-stream --no-pretty --message-file {prompt_file}",
+		PromptDelivery:  models.PromptDeliveryFile,
+	},
+}
+
+// seedAgentProfiles 建立尚不存在的內建 AgentProfile
+//
+// 說明:
+//   只在名稱不存在時建立，不會覆蓋使用者對內建設定檔所做的修改。
+func seedAgentProfiles() {
+	for _, preset := range builtInAgentProfiles {
+		profile := preset
+		profile.BuiltIn = true
+		if err := DB.Where("name = ?", profile.Name).FirstOrCreate(&profile).Error; err != nil {
+			log.Printf("Failed to seed agent profile %s: %v", profile.Name, err)
+		}
+	}
+}
+
+// seedPromptTemplates 建立尚不存在的內建提示詞模版
+//
+// 說明:
+//   與 AgentProfile 相同，只在名稱不存在時建立，使用者對 default 模版的修改會保留。
+func seedPromptTemplates() {
+	tmpl := models.PromptTemplate{
+		Name:        models.DefaultPromptTemplateName,
+		Description: "Default prompt (safety rules, JSON result format, history, project info and task)",
+		Content:     utils.DefaultPromptTemplate,
+		BuiltIn:     true,
+	}
+	if err := DB.Where("name = ?", tmpl.Name).FirstOrCreate(&tmpl).Error; err != nil {
+		log.Printf("Failed to seed prompt template %s: %v", tmpl.Name, err)
+	}
+}
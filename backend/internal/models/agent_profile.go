@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AgentProfile 代表一組可重複使用的 AI CLI 設定 (例如 claude-cli、aider-strict)
+// 專案引用 AgentProfile 後，更新 AgentProfile 即可同時套用到所有使用它的專案。
+type AgentProfile struct {
+	gorm.Model
+	// Name 是設定檔名稱，必須唯一
+	Name string `json:"name" gorm:"unique;not null"`
+	// Description 是設定檔描述
+	Description string `json:"description"`
+	// CommandTemplate 是 AI CLI 指令模版 (語法與 Project.AICliCommand 相同)
+	CommandTemplate string `json:"command_template" gorm:"not null"`
+	// EnvVars 是執行 AI CLI 時額外設定的環境變數 (加密存放，API 只回傳名稱)
+	EnvVars map[string]string `json:"-" gorm:"serializer:encrypted"`
+	// EnvVarNames 是 EnvVars 的名稱列表 (依名稱排序)
+	EnvVarNames []string `json:"env_var_names" gorm:"-"`
+	// OutputParser 是解析 AI CLI 輸出的方式 (空字串視為 json)
+	OutputParser string `json:"output_parser"`
+	// TimeoutSeconds 是執行超時秒數 (0 表示使用系統預設值)
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// PromptDelivery 是提示詞傳遞方式 (argv/stdin/file，空字串視為 argv)
+	PromptDelivery string `json:"prompt_delivery"`
+	// ResumeArgs 是續接 AI CLI 先前對話的參數模版 (例如 --resume {session_id}，空字串表示 CLI 不支援續接)
+	// 對話工作階段的後續執行會將其插入執行檔之後，並以 CLI 續接取代提示詞中的先前對話。
+	ResumeArgs string `json:"resume_args"`
+	// BuiltIn 標示是否為系統內建的預設設定檔 (不可刪除)
+	BuiltIn bool `json:"built_in"`
+}
+
+// AfterFind 在讀取後填入 EnvVarNames
+func (p *AgentProfile) AfterFind(tx *gorm.DB) error {
+	p.EnvVarNames = envVarNames(p.EnvVars)
+	return nil
+}
+
+// AfterSave 在儲存後更新 EnvVarNames
+func (p *AgentProfile) AfterSave(tx *gorm.DB) error {
+	p.EnvVarNames = envVarNames(p.EnvVars)
+	return nil
+}
+
+// Timeout 返回設定檔的執行超時時間，未設定時返回 defaultTimeout
+func (p *AgentProfile) Timeout(defaultTimeout time.Duration) time.Duration {
+	if p.TimeoutSeconds <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(p.TimeoutSeconds) * time.Second
+}
@@ -8,10 +8,36 @@ import (
 
 // 定義執行狀態常數
 const (
-	StatusRunning     = "running"     // 執行中
-	StatusCompleted   = "completed"   // 已完成
-	StatusFailed      = "failed"      // 失敗
-	StatusParseFailed = "parse_failed" // 輸出解析失敗
+	StatusQueued           = "queued"            // 排隊等待中
+	StatusRunning          = "running"           // 執行中
+	StatusCompleted        = "completed"         // 已完成
+	StatusFailed           = "failed"            // 失敗
+	StatusParseFailed      = "parse_failed"      // 輸出解析失敗
+	StatusCancelled        = "cancelled"         // 已被使用者取消
+	StatusAwaitingApproval = "awaiting_approval" // Agent 已完成，等待人工核准變更
+	StatusRejected         = "rejected"          // 變更已被人工拒絕
+	StatusTimedOut         = "timed_out"         // 超過執行時間上限而被終止
+	StatusResourceExceeded = "resource_exceeded" // 超過記憶體或 CPU 時間上限而被終止
+)
+
+// 定義變更檔案列表的來源
+const (
+	ChangeSourceAgent      = "agent"      // 由 Agent 輸出的 JSON 自行回報
+	ChangeSourceGit        = "git"        // 由執行前後的 Git 狀態比對而得 (權威來源)
+	ChangeSourceFilesystem = "filesystem" // 由執行前後的檔案 Manifest 比對而得 (非 Git 專案，Agent 未回報時使用)
+)
+
+// 定義執行前快照的種類 (用於還原)
+const (
+	SnapshotGit    = "git"    // 以 Git ref 保存的工作目錄快照
+	SnapshotBackup = "backup" // 非 Git 專案，保存於內容定址備份庫
+)
+
+// 定義執行結果的審核狀態
+const (
+	ReviewPending  = "pending"  // 等待審核
+	ReviewApproved = "approved" // 已核准 (worktree 執行會合併回主分支)
+	ReviewRejected = "rejected" // 已拒絕 (worktree 執行會刪除 worktree，其餘執行會還原變更)
 )
 
 // Execution 代表一次指令執行的記錄
@@ -19,6 +45,10 @@ type Execution struct {
 	gorm.Model
 	// ProjectID 是關聯的專案 ID
 	ProjectID uint `json:"project_id"`
+	// ScheduleID 是觸發此次執行的排程 ID (手動執行則為空)
+	ScheduleID *uint `json:"schedule_id,omitempty" gorm:"index"`
+	// SessionID 是此次執行所屬的對話工作階段 ID (不屬於任何工作階段時為空)
+	SessionID *uint `json:"session_id,omitempty" gorm:"index"`
 	// Command 是執行的具體指令內容
 	Command string `json:"command"`
 	// Status 是執行狀態
@@ -39,4 +69,125 @@ type Execution struct {
 	DeletedFiles []string `json:"deleted_files" gorm:"serializer:json"`
 	// ErrorMessage 記錄錯誤訊息 (如果有)
 	ErrorMessage string `json:"error_message"`
+	// ChangeSource 標示 ModifiedFiles/CreatedFiles/DeletedFiles 的來源 (agent、git 或 filesystem)
+	ChangeSource string `json:"change_source,omitempty"`
+	// GitBaseCommit 是執行前的 HEAD commit (僅限 Git 專案)
+	GitBaseCommit string `json:"git_base_commit,omitempty"`
+	// GitHeadCommit 是執行後的 HEAD commit (僅限 Git 專案)
+	GitHeadCommit string `json:"git_head_commit,omitempty"`
+	// GitTreeBefore 是執行前工作目錄的 tree 物件 (含未 commit 的變更)
+	GitTreeBefore string `json:"git_tree_before,omitempty"`
+	// GitTreeAfter 是執行後工作目錄的 tree 物件
+	GitTreeAfter string `json:"git_tree_after,omitempty"`
+	// Diff 是執行前後的 unified diff (內容較大，僅透過 /diff 端點提供)
+	Diff string `json:"-"`
+	// SnapshotKind 是執行前快照的種類 (git 或 backup，無快照則為空)
+	SnapshotKind string `json:"snapshot_kind,omitempty"`
+	// SnapshotRef 是快照的位置 (Git ref 名稱或備份庫中的快照名稱)
+	SnapshotRef string `json:"snapshot_ref,omitempty"`
+	// RevertOfID 是此次執行所還原的執行記錄 ID (僅限還原執行)
+	RevertOfID *uint `json:"revert_of_id,omitempty" gorm:"index"`
+	// RevertedByID 是還原此次執行變更的執行記錄 ID
+	RevertedByID *uint `json:"reverted_by_id,omitempty"`
+	// WorktreePath 是此次執行專用的 git worktree 路徑 (僅限 worktree 模式的專案)
+	WorktreePath string `json:"worktree_path,omitempty"`
+	// WorktreeBranch 是此次執行專用的分支
+	WorktreeBranch string `json:"worktree_branch,omitempty"`
+	// BaseBranch 是建立 worktree 時主工作目錄所在的分支 (核准後合併的目標)
+	BaseBranch string `json:"base_branch,omitempty"`
+	// ReviewStatus 是執行結果的審核狀態 (pending/approved/rejected)，適用於 worktree 執行與需要核准的專案
+	ReviewStatus string `json:"review_status,omitempty"`
+	// Pinned 為 true 時，此執行記錄永遠會包含在同專案後續執行的提示詞 Context 中
+	Pinned bool `json:"pinned"`
+	// HistoryIDs 是此次提示詞中作為 Context 的過去執行記錄 ID (由舊到新)
+	HistoryIDs []uint `json:"history_ids,omitempty" gorm:"serializer:json"`
+	// CLISessionID 是 AI CLI 回報的工作階段 ID (由輸出解析器提取，同一工作階段的後續執行以此續接)
+	CLISessionID string `json:"cli_session_id,omitempty"`
+	// ResumedCLISessionID 是此次執行續接的 AI CLI 工作階段 ID (未續接時為空)
+	ResumedCLISessionID string `json:"resumed_cli_session_id,omitempty"`
+	// MemoryUpdates 是 Agent 回傳的專案記憶更新 (專案啟用 MemoryAutoUpdate 時已加入專案記憶)
+	MemoryUpdates []string `json:"memory_updates,omitempty" gorm:"serializer:json"`
+	// OutputParser 是解析此次輸出所使用的解析器名稱
+	OutputParser string `json:"output_parser,omitempty"`
+	// OutputAttempts 記錄輸出修復的過程 (第一筆為原本的輸出，其完整內容即 Details；未進行修復時為空)
+	OutputAttempts []OutputAttempt `json:"output_attempts,omitempty" gorm:"serializer:json"`
+	// DebugInfo 記錄實際執行的指令等除錯資訊
+	DebugInfo *ExecutionDebugInfo `json:"debug_info,omitempty" gorm:"serializer:json"`
+	// SandboxViolations 是沙箱中執行時輸出裡疑似違規 (寫入唯讀路徑、存取已停用的網路) 的行
+	SandboxViolations []string `json:"sandbox_violations,omitempty" gorm:"serializer:json"`
+	// PolicyViolations 是執行後檢查發現超出專案目錄的檔案路徑 (絕對路徑、.. 或經由符號連結跳脫)
+	PolicyViolations []string `json:"policy_violations,omitempty" gorm:"serializer:json"`
+	// QueuePosition 是排隊中的位置 (從 1 開始，不存入資料庫)
+	QueuePosition int `json:"queue_position,omitempty" gorm:"-"`
+}
+
+// OutputAttempt 是一次解析 Agent 輸出的嘗試
+type OutputAttempt struct {
+	// Repair 為 true 表示是要求 Agent 重新輸出 JSON 的修復回合
+	Repair bool `json:"repair"`
+	// Output 是修復回合的輸出 (原本的輸出記錄於 Execution.Details，此處不重複保存)
+	Output string `json:"output,omitempty"`
+	// Error 是解析或驗證失敗的原因 (成功時為空)
+	Error string `json:"error,omitempty"`
+}
+
+// ExecutionDebugInfo 是執行的除錯資訊
+type ExecutionDebugInfo struct {
+	// Command 是實際執行的執行檔與參數 (提示詞內容以長度標記取代)
+	Command []string `json:"command"`
+	// AgentProfile 是使用的 AgentProfile 名稱 (未使用時為空)
+	AgentProfile string `json:"agent_profile,omitempty"`
+	// PromptTemplate 是使用的提示詞模版名稱
+	PromptTemplate string `json:"prompt_template,omitempty"`
+	// PromptDelivery 是提示詞傳遞方式
+	PromptDelivery string `json:"prompt_delivery"`
+	// PromptBytes 是提示詞的長度 (位元組)
+	PromptBytes int `json:"prompt_bytes"`
+	// PromptFile 是提示詞暫存檔路徑 (執行結束後已刪除)
+	PromptFile string `json:"prompt_file,omitempty"`
+	// Sandbox 是使用的沙箱 (未使用時為空)
+	Sandbox string `json:"sandbox,omitempty"`
+	// SandboxCommand 是包裝 AI CLI 的沙箱指令 (不含 Command 的部分)
+	SandboxCommand []string `json:"sandbox_command,omitempty"`
+	// Limits 是此次執行套用的資源限制
+	Limits *ExecutionLimits `json:"limits,omitempty"`
+}
+
+// ExecutionLimits 是執行套用的資源限制 (0 表示不限制)
+type ExecutionLimits struct {
+	// TimeoutSeconds 是執行時間上限 (秒)
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// MaxOutputBytes 是保存的輸出上限 (位元組)
+	MaxOutputBytes int `json:"max_output_bytes"`
+	// MemoryLimitMB 是每個程序的記憶體上限 (MB)
+	MemoryLimitMB int `json:"memory_limit_mb,omitempty"`
+	// CPULimitSeconds 是每個程序的 CPU 時間上限 (秒)
+	CPULimitSeconds int `json:"cpu_limit_seconds,omitempty"`
+}
+
+// IsFinished 判斷執行記錄是否已結束 (不再排隊或執行中)
+func (e *Execution) IsFinished() bool {
+	return e.Status != StatusQueued && e.Status != StatusRunning
+}
+
+// IsApprovable 判斷等待審核的執行是否可以核准
+//
+// 說明:
+//   只有 Agent 成功完成的執行 (completed 或 awaiting_approval) 可以核准；
+//   失敗、逾時或被取消的 worktree 執行只能拒絕 (刪除 worktree)，避免把未完成的變更合併回主分支。
+func (e *Execution) IsApprovable() bool {
+	return e.Status == StatusCompleted || e.Status == StatusAwaitingApproval
+}
+
+// FailureStatuses 是代表執行以錯誤結束的狀態
+var FailureStatuses = []string{StatusFailed, StatusParseFailed, StatusTimedOut, StatusResourceExceeded}
+
+// IsFailure 判斷執行是否以錯誤結束 (ErrorMessage 說明原因)
+func (e *Execution) IsFailure() bool {
+	for _, status := range FailureStatuses {
+		if e.Status == status {
+			return true
+		}
+	}
+	return false
 }
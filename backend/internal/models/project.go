@@ -1,6 +1,31 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// 定義提示詞傳遞給 AI CLI 的方式
+const (
+	PromptDeliveryArgv  = "argv"  // 作為命令列參數 ({prompt} 或附加於最後，預設)
+	PromptDeliveryStdin = "stdin" // 寫入標準輸入
+	PromptDeliveryFile  = "file"  // 寫入暫存檔，以 {prompt_file} 傳入路徑
+)
+
+// 定義解析 AI CLI 輸出的方式
+const (
+	OutputParserJSON       = "json"        // 系統提示詞要求的 JSON 結果 (預設)
+	OutputParserFencedJSON = "fenced_json" // Markdown ```json 程式碼區塊中的 JSON 結果
+	OutputParserJSONLines  = "jsonl"       // 每行一個 JSON 事件的串流 (例如 stream-json)
+	OutputParserText       = "text"        // 純文字，以最後幾行作為摘要
+)
+
+// 定義 AI CLI 的沙箱模式
+const (
+	SandboxNone       = ""      // 不使用沙箱
+	SandboxBubblewrap = "bwrap" // 以 bubblewrap 限制檔案系統 (僅 Linux)
+)
 
 // Project 代表一個 AI Agent 專案
 type Project struct {
@@ -9,10 +34,87 @@ type Project struct {
 	Name string `json:"name" gorm:"unique;not null"`
 	// Description 是專案描述
 	Description string `json:"description"`
-	// AICliCommand 是用於執行此專案的 AI CLI 指令模板
+	// AICliCommand 是用於執行此專案的 AI CLI 指令模板 (設定 AgentProfileID 時不使用)
 	AICliCommand string `json:"ai_cli_command"`
+	// AgentProfileID 是專案使用的 AgentProfile (設定後取代 AICliCommand 與 PromptDelivery)
+	AgentProfileID *uint `json:"agent_profile_id"`
+	// AgentProfile 是關聯的 AgentProfile
+	AgentProfile *AgentProfile `json:"agent_profile,omitempty" gorm:"foreignKey:AgentProfileID"`
+	// PromptTemplateID 是專案使用的提示詞模版 (未設定時使用名為 default 的模版)
+	PromptTemplateID *uint `json:"prompt_template_id"`
+	// ModelName 是替換指令模版中 {model} 佔位符的模型名稱
+	ModelName string `json:"model"`
+	// PromptDelivery 是提示詞傳遞方式 (argv/stdin/file，空字串視為 argv)
+	// 長提示詞以 argv 傳遞可能超過 ARG_MAX，且會出現在 ps 輸出中
+	PromptDelivery string `json:"prompt_delivery"`
+	// OutputParser 是解析 AI CLI 輸出的方式 (空字串時使用 AgentProfile 的設定，皆未設定時為 json)
+	OutputParser string `json:"output_parser"`
+	// RepairOutput 為 true 時，輸出無法解析或不符合 JSON Schema 會再呼叫一次同一個 Agent，要求只輸出修正後的 JSON
+	RepairOutput bool `json:"repair_output"`
+	// HistoryCount 是提示詞中作為 Context 的最近執行記錄數量 (null 時使用預設值 5，0 表示不包含；釘選的執行記錄不受限制)
+	HistoryCount *int `json:"history_count"`
+	// HistoryMaxChars 是最近執行記錄的字元數預算 (0 表示不限制)，超過時捨棄較舊的記錄
+	HistoryMaxChars int `json:"history_max_chars"`
+	// HistoryIncludeFailed 為 true 時也包含失敗的執行記錄與其錯誤訊息
+	HistoryIncludeFailed bool `json:"history_include_failed"`
+	// HistoryIncludeFiles 為 true 時包含執行記錄的變更檔案列表
+	HistoryIncludeFiles bool `json:"history_include_files"`
+	// Memory 是專案的長期記憶 (慣例、決策、注意事項)，每次執行都會放入提示詞
+	Memory string `json:"memory"`
+	// MemoryAutoUpdate 為 true 時，Agent 在 JSON 結果中回傳的 memory_updates 會自動加入 Memory
+	MemoryAutoUpdate bool `json:"memory_auto_update"`
+	// EnvVars 是執行 AI CLI 時額外設定的環境變數 (加密存放，API 只回傳名稱)
+	// 與 AgentProfile 的環境變數同名時以專案的設定為準
+	EnvVars map[string]string `json:"-" gorm:"serializer:encrypted"`
+	// EnvVarNames 是 EnvVars 的名稱列表 (依名稱排序)
+	EnvVarNames []string `json:"env_var_names" gorm:"-"`
 	// DirectoryPath 是專案在檔案系統中的絕對路徑
 	DirectoryPath string `json:"directory_path" gorm:"not null"`
+	// SandboxMode 是執行 AI CLI 的沙箱 (空字串表示不使用)
+	// 沙箱中只有專案目錄 (或 worktree) 與 SandboxWritablePaths 可寫入，其餘檔案系統皆為唯讀
+	SandboxMode string `json:"sandbox_mode"`
+	// SandboxWritablePaths 是沙箱中額外可寫入的絕對路徑 (例如 AI CLI 的設定目錄)
+	SandboxWritablePaths []string `json:"sandbox_writable_paths" gorm:"serializer:json"`
+	// SandboxDisableNetwork 為 true 時沙箱中的 AI CLI 無法存取網路
+	SandboxDisableNetwork bool `json:"sandbox_disable_network"`
+	// IgnorePatterns 是非 Git 專案比對變更時額外略過的路徑規則 (.gitignore 語法的子集)
+	IgnorePatterns []string `json:"ignore_patterns" gorm:"serializer:json"`
+	// TimeoutSeconds 是執行時間上限 (秒，0 表示使用 AgentProfile 或系統預設值)
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// MaxOutputBytes 是保存於執行記錄的輸出上限 (位元組，0 表示使用系統預設值)，超過時保留開頭與結尾
+	MaxOutputBytes int `json:"max_output_bytes"`
+	// MemoryLimitMB 是 AI CLI 每個程序可使用的記憶體上限 (MB，0 表示不限制)
+	MemoryLimitMB int `json:"memory_limit_mb"`
+	// CPULimitSeconds 是 AI CLI 每個程序可使用的 CPU 時間上限 (秒，0 表示不限制)
+	CPULimitSeconds int `json:"cpu_limit_seconds"`
+	// UseWorktree 為 true 時，每次執行都在獨立的 git worktree 與分支中進行，核准後才合併回主分支
+	UseWorktree bool `json:"use_worktree"`
+	// RequireApproval 為 true 時，Agent 完成後執行會進入 awaiting_approval 狀態，等待人工核准或拒絕
+	// 搭配 UseWorktree 時變更在核准前不會進入專案目錄；未啟用 worktree 時變更已直接寫入專案目錄，
+	// 拒絕時會從執行前的快照還原 (需為 Git 專案或已啟用備份庫)
+	RequireApproval bool `json:"require_approval"`
 	// Executions 關聯到該專案的所有執行記錄
 	Executions []Execution `json:"executions,omitempty" gorm:"foreignKey:ProjectID"`
 }
+
+// AfterFind 在讀取後填入 EnvVarNames
+func (p *Project) AfterFind(tx *gorm.DB) error {
+	p.EnvVarNames = envVarNames(p.EnvVars)
+	return nil
+}
+
+// AfterSave 在儲存後更新 EnvVarNames
+func (p *Project) AfterSave(tx *gorm.DB) error {
+	p.EnvVarNames = envVarNames(p.EnvVars)
+	return nil
+}
+
+// envVarNames 返回環境變數的名稱列表 (依名稱排序)
+func envVarNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+// DefaultPromptTemplateName 是未指定模版的專案所使用的提示詞模版名稱
+const DefaultPromptTemplateName = "default"
+
+// PromptTemplate 代表一個提示詞模版 (Go text/template 語法)
+// 專案未指定模版時使用名為 default 的模版，修改它即可調整所有專案的提示詞。
+type PromptTemplate struct {
+	gorm.Model
+	// Name 是模版名稱，必須唯一
+	Name string `json:"name" gorm:"unique;not null"`
+	// Description 是模版描述
+	Description string `json:"description"`
+	// Content 是模版內容，可使用的資料見 utils.PromptData
+	Content string `json:"content" gorm:"not null"`
+	// BuiltIn 標示是否為系統內建的模版 (不可刪除)
+	BuiltIn bool `json:"built_in"`
+}
@@ -9,8 +9,18 @@ import (
 // 定義排程狀態常數
 const (
 	SchedulePending   = "pending"   // 等待執行
-	ScheduleCompleted = "completed" // 已執行
+	ScheduleTriggered = "triggered" // 已觸發，等待執行結果
+	ScheduleCompleted = "completed" // 執行成功
 	ScheduleFailed    = "failed"    // 執行失敗
+	SchedulePaused    = "paused"    // 已暫停 (僅週期排程)
+	ScheduleMissed    = "missed"    // 伺服器停機期間錯過，依策略略過
+)
+
+// 定義錯過排程 (Misfire) 的處理策略
+const (
+	MisfireRunOnce = "run_once" // 重啟後立即補執行一次 (預設)
+	MisfireSkip    = "skip"     // 略過錯過的執行
+	MisfireGrace   = "grace"    // 僅在寬限時間內補執行，否則略過
 )
 
 // Schedule 代表一個排程任務
@@ -18,10 +28,65 @@ type Schedule struct {
 	gorm.Model
 	// ProjectID 是關聯的專案 ID
 	ProjectID uint `json:"project_id"`
+	// Project 是關聯的專案 (供排程總覽顯示專案資訊)
+	Project *Project `json:"project,omitempty" gorm:"foreignKey:ProjectID"`
 	// Command 是要執行的指令
 	Command string `json:"command"`
-	// ScheduledTime 是預定執行時間
+	// ScheduledTime 是預定執行時間 (週期排程為下一次執行時間)
 	ScheduledTime time.Time `json:"scheduled_time"`
+	// CronExpression 是週期排程的 Cron 表達式 (空值代表一次性排程)
+	CronExpression string `json:"cron_expression"`
+	// Timezone 是解讀 Cron 表達式所使用的 IANA 時區 (空值代表伺服器時區)
+	Timezone string `json:"timezone"`
+	// LastRunTime 是週期排程最後一次觸發的時間
+	LastRunTime *time.Time `json:"last_run_time"`
 	// Status 是排程狀態
 	Status string `json:"status"`
+	// ExecutionID 是最近一次觸發所產生的執行記錄 ID
+	ExecutionID *uint `json:"execution_id"`
+	// LastRunStatus 是最近一次觸發的結果 (triggered / completed / failed)
+	LastRunStatus string `json:"last_run_status"`
+	// MaxRetries 是執行失敗時自動重試的最大次數 (0 代表不重試)
+	MaxRetries int `json:"max_retries"`
+	// RetryDelaySeconds 是第一次重試前的等待秒數，之後每次加倍 (0 代表使用預設值)
+	RetryDelaySeconds int `json:"retry_delay_seconds"`
+	// RetryCount 是本輪觸發已進行的重試次數
+	RetryCount int `json:"retry_count"`
+	// MisfirePolicy 是伺服器重啟後發現錯過執行時的處理策略 (空值視為 run_once)
+	MisfirePolicy string `json:"misfire_policy"`
+	// MisfireGraceSeconds 是 grace 策略下允許補執行的最大延遲秒數
+	MisfireGraceSeconds int `json:"misfire_grace_seconds"`
+}
+
+// IsRecurring 判斷是否為週期排程
+func (s *Schedule) IsRecurring() bool {
+	return s.CronExpression != ""
+}
+
+// ShouldRunMisfired 判斷錯過的排程在 now 時是否仍應補執行
+func (s *Schedule) ShouldRunMisfired(now time.Time) bool {
+	switch s.MisfirePolicy {
+	case MisfireSkip:
+		return false
+	case MisfireGrace:
+		return now.Sub(s.ScheduledTime) <= time.Duration(s.MisfireGraceSeconds)*time.Second
+	default:
+		return true
+	}
+}
+
+// RetryDelay 計算第 attempt 次重試前的等待時間 (指數退避)
+//
+// 參數:
+//   - attempt: 重試次數 (從 1 開始)。
+//   - defaultDelay: RetryDelaySeconds 未設定時使用的基準時間。
+func (s *Schedule) RetryDelay(attempt int, defaultDelay time.Duration) time.Duration {
+	base := defaultDelay
+	if s.RetryDelaySeconds > 0 {
+		base = time.Duration(s.RetryDelaySeconds) * time.Second
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+	return base << (attempt - 1)
 }
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session 代表一段對話式的工作階段，將同一任務的多次執行串在一起
+// 在 Telegram 回覆 Bot 的執行結果通知即可延續該工作階段，後續執行的提示詞會包含先前的對話。
+type Session struct {
+	gorm.Model
+	// ProjectID 是工作階段所屬的專案 ID
+	ProjectID uint `json:"project_id" gorm:"index"`
+	// ChatID 是建立工作階段的 Telegram 聊天室 ID
+	ChatID int64 `json:"chat_id,omitempty"`
+	// LastActivityAt 是最後一次延續工作階段的時間
+	LastActivityAt time.Time `json:"last_activity_at"`
+	// Executions 是工作階段中的執行記錄 (由舊到新)
+	Executions []Execution `json:"executions,omitempty" gorm:"foreignKey:SessionID"`
+
+	// ExpiresAt 是工作階段閒置過期的時間 (由最後一次活動或執行結束時間計算，不存入資料庫)
+	ExpiresAt time.Time `json:"expires_at" gorm:"-"`
+	// Expired 為 true 時工作階段已過期，無法再延續
+	Expired bool `json:"expired" gorm:"-"`
+}
+
+// NotificationMessage 記錄 Telegram 執行結果通知的訊息，用於將使用者的回覆對應到執行記錄
+type NotificationMessage struct {
+	ID uint `gorm:"primarykey"`
+	// ChatID 與 MessageID 唯一識別一則 Telegram 訊息
+	ChatID    int64 `gorm:"uniqueIndex:idx_notification_message"`
+	MessageID int   `gorm:"uniqueIndex:idx_notification_message"`
+	// ExecutionID 是通知所屬的執行記錄 ID
+	ExecutionID uint
+	CreatedAt   time.Time
+}
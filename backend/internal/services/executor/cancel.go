@@ -0,0 +1,84 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/realtime"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrCancelled 是使用者取消執行時設定於 Context 的原因
+	ErrCancelled = errors.New("execution cancelled by user")
+	// ErrExecutionNotFound 表示執行記錄不存在
+	ErrExecutionNotFound = errors.New("execution not found")
+	// ErrNotCancellable 表示執行記錄已結束，無法取消
+	ErrNotCancellable = errors.New("execution is not running or queued")
+)
+
+// CancelExecution 取消執行中或排隊中的執行記錄
+//
+// 參數:
+//   - executionID: 要取消的執行記錄 ID。
+//
+// 返回:
+//   - error: 執行記錄不存在 (ErrExecutionNotFound) 或已結束 (ErrNotCancellable) 時返回錯誤。
+//
+// 邏輯:
+//  1. 若執行中: 取消其 Context，runExecution 會終止整個 Process Group、
+//     保留部分輸出並將狀態標記為 Cancelled。
+//  2. 若排隊中: 直接將記錄標記為 Cancelled 並從佇列移除，同時呼叫其完成回呼。
+func CancelExecution(executionID uint) error {
+	queueLock.Lock()
+	if cancel, ok := running[executionID]; ok {
+		queueLock.Unlock()
+		Log.Info("Cancelling running execution", "execution_id", executionID)
+		cancel(ErrCancelled)
+		return nil
+	}
+
+	// 持有 queueLock 更新狀態，確保 Worker 不會在同一時間取出這筆記錄
+	result := database.DB.Model(&models.Execution{}).
+		Where("id = ? AND status = ?", executionID, models.StatusQueued).
+		Updates(map[string]interface{}{
+			"status":        models.StatusCancelled,
+			"error_message": "Cancelled by user",
+			"end_time":      time.Now(),
+		})
+	onComplete := callbacks[executionID]
+	delete(callbacks, executionID)
+	queueLock.Unlock()
+
+	var execution models.Execution
+	if err := database.DB.First(&execution, executionID).Error; err != nil {
+		return ErrExecutionNotFound
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotCancellable
+	}
+
+	Log.Info("Cancelled queued execution", "execution_id", executionID)
+	if realtime.Broker != nil {
+		realtime.Broker.Publish(executionID, "Execution cancelled")
+		realtime.Broker.CloseExecution(executionID)
+	}
+	if onComplete != nil {
+		onComplete(&execution)
+	}
+	return nil
+}
+
+// GetRunningExecution 取得專案目前執行中的執行記錄
+//
+// 返回:
+//   - *models.Execution: 執行中的記錄；若專案目前沒有執行中的指令則返回 nil。
+func GetRunningExecution(projectID uint) *models.Execution {
+	var execution models.Execution
+	if err := database.DB.Where("project_id = ? AND status = ?", projectID, models.StatusRunning).
+		Order("id desc").
+		First(&execution).Error; err != nil {
+		return nil
+	}
+	return &execution
+}
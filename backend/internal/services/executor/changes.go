@@ -0,0 +1,180 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/workspace"
+	"fmt"
+	"sync"
+)
+
+// snapshotState 是執行前擷取的專案狀態
+// Git 專案使用 git，其餘專案使用 manifest (備份庫啟用時 stored 為 true，檔案內容已保存可供還原)。
+type snapshotState struct {
+	git      *workspace.GitState
+	manifest workspace.Manifest
+	stored   bool
+	ignore   *workspace.IgnoreList
+}
+
+// manifestCache 保存各目錄最近一次掃描的 Manifest，鍵為目錄路徑
+// 下一次執行前的掃描可沿用大小與修改時間未變的檔案 Hash，不需重新讀取整個專案。
+var (
+	manifestCache     = make(map[string]workspace.Manifest)
+	manifestCacheLock sync.Mutex
+)
+
+// cachedManifest 取得目錄最近一次掃描的 Manifest (沒有時返回 nil)
+func cachedManifest(dir string) workspace.Manifest {
+	manifestCacheLock.Lock()
+	defer manifestCacheLock.Unlock()
+	return manifestCache[dir]
+}
+
+// cacheManifest 保存目錄最近一次掃描的 Manifest
+func cacheManifest(dir string, manifest workspace.Manifest) {
+	manifestCacheLock.Lock()
+	defer manifestCacheLock.Unlock()
+	manifestCache[dir] = manifest
+}
+
+// ignoreList 組合預設與專案設定的略過規則
+func ignoreList(project *models.Project) *workspace.IgnoreList {
+	return workspace.NewIgnoreList(workspace.DefaultIgnorePatterns, project.IgnorePatterns)
+}
+
+// captureSnapshot 在執行前擷取專案狀態
+//
+// 參數:
+//   - execution: 執行記錄，Git 專案會填入 GitBaseCommit 與 GitTreeBefore。
+//   - dir: 專案目錄。
+//   - ignore: 非 Git 專案掃描時略過的路徑規則。
+//
+// 返回:
+//   - *snapshotState: 執行前的狀態；無法擷取時返回 nil (執行仍會繼續，但無法比對變更與還原)。
+func captureSnapshot(execution *models.Execution, dir string, ignore *workspace.IgnoreList) *snapshotState {
+	if workspace.IsGitRepo(dir) {
+		state, err := workspace.CaptureGitState(dir)
+		if err != nil {
+			Log.Warn("Failed to capture git state before execution", "execution_id", execution.ID, "error", err)
+			return nil
+		}
+		execution.GitBaseCommit = state.Head
+		execution.GitTreeBefore = state.Tree
+		return &snapshotState{git: state}
+	}
+
+	// 備份庫未啟用時仍建立 Manifest，用於比對變更 (但無法還原)
+	store := workspace.BackupEnabled()
+	manifest, err := workspace.ScanManifest(dir, cachedManifest(dir), store, ignore)
+	if err != nil && store {
+		// 備份失敗時仍以不保存內容的 Manifest 比對變更
+		Log.Warn("Failed to back up project before execution", "execution_id", execution.ID, "error", err)
+		store = false
+		manifest, err = workspace.ScanManifest(dir, cachedManifest(dir), false, ignore)
+	}
+	if err != nil {
+		Log.Warn("Failed to scan project before execution", "execution_id", execution.ID, "error", err)
+		return nil
+	}
+	return &snapshotState{manifest: manifest, stored: store, ignore: ignore}
+}
+
+// recordChanges 在執行後比對專案狀態，記錄變更並保存可供還原的快照
+//
+// 參數:
+//   - execution: 執行記錄。
+//   - dir: 專案目錄。
+//   - before: captureSnapshot 的結果 (nil 時不做任何事)。
+func recordChanges(execution *models.Execution, dir string, before *snapshotState) {
+	if before == nil {
+		return
+	}
+	if before.git != nil {
+		recordGitChanges(execution, dir, before.git)
+		return
+	}
+	recordManifestChanges(execution, dir, before)
+}
+
+// recordGitChanges 在執行後比對 Git 狀態，記錄真實的 Diff 與變更檔案列表
+//
+// 參數:
+//   - execution: 執行記錄，成功時 ChangeSource 會標示為 git。
+//   - dir: 專案目錄。
+//   - before: 執行前的 Git 狀態。
+//
+// 說明:
+//   Git 比對結果是權威來源，後續解析 Agent 輸出時不會再覆蓋檔案列表。
+//   執行前的狀態會以 ref 保存，供 RevertExecution 還原。
+func recordGitChanges(execution *models.Execution, dir string, before *workspace.GitState) {
+	after, err := workspace.CaptureGitState(dir)
+	if err != nil {
+		Log.Warn("Failed to capture git state after execution", "execution_id", execution.ID, "error", err)
+		return
+	}
+	execution.GitHeadCommit = after.Head
+	execution.GitTreeAfter = after.Tree
+
+	diff, err := workspace.DiffGitStates(dir, before, after)
+	if err != nil {
+		Log.Warn("Failed to diff git states", "execution_id", execution.ID, "error", err)
+		return
+	}
+	execution.Diff = diff.Patch
+	execution.ModifiedFiles = diff.ModifiedFiles
+	execution.CreatedFiles = diff.CreatedFiles
+	execution.DeletedFiles = diff.DeletedFiles
+	execution.ChangeSource = models.ChangeSourceGit
+
+	// worktree 執行的變更保留在執行分支上，以拒絕取代還原，不需要快照
+	if execution.WorktreePath != "" {
+		return
+	}
+	ref := fmt.Sprintf("refs/agent-workspace/executions/%d", execution.ID)
+	if err := workspace.PinGitState(dir, ref, before); err != nil {
+		Log.Warn("Failed to pin git snapshot", "execution_id", execution.ID, "error", err)
+		return
+	}
+	execution.SnapshotKind = models.SnapshotGit
+	execution.SnapshotRef = ref
+}
+
+// recordManifestChanges 在執行後比對檔案狀態，記錄變更檔案列表，並將被觸及檔案的執行前狀態保存到備份庫
+//
+// 參數:
+//   - execution: 執行記錄，成功時 ChangeSource 會標示為 filesystem；備份庫啟用時會填入 SnapshotKind 與 SnapshotRef。
+//   - dir: 專案目錄。
+//   - before: 執行前的狀態 (manifest 的檔案內容在 stored 為 true 時已保存於備份庫)。
+//
+// 說明:
+//   比對結果不依賴 Agent 的輸出；Agent 沒有回報檔案列表時 (例如輸出無法解析) 仍能得知實際的變更。
+func recordManifestChanges(execution *models.Execution, dir string, before *snapshotState) {
+	after, err := workspace.ScanManifest(dir, before.manifest, false, before.ignore)
+	if err != nil {
+		Log.Warn("Failed to scan project after execution", "execution_id", execution.ID, "error", err)
+		return
+	}
+	cacheManifest(dir, after)
+
+	modified, created, deleted := workspace.DiffManifests(before.manifest, after)
+	execution.ModifiedFiles = modified
+	execution.CreatedFiles = created
+	execution.DeletedFiles = deleted
+	execution.ChangeSource = models.ChangeSourceFilesystem
+
+	if !before.stored {
+		return
+	}
+	snapshot := &workspace.BackupSnapshot{Files: make(workspace.Manifest), Created: created}
+	for _, path := range append(append([]string{}, modified...), deleted...) {
+		snapshot.Files[path] = before.manifest[path]
+	}
+
+	name := fmt.Sprintf("execution-%d", execution.ID)
+	if err := workspace.SaveBackupSnapshot(name, snapshot); err != nil {
+		Log.Warn("Failed to save backup snapshot", "execution_id", execution.ID, "error", err)
+		return
+	}
+	execution.SnapshotKind = models.SnapshotBackup
+	execution.SnapshotRef = name
+}
@@ -0,0 +1,227 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/realtime"
+	"agent-workspace-manager/internal/utils"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// commandSpec 是建構與執行 AI CLI 指令所需的設定
+// 主要執行與輸出修復回合共用同一份設定，只有提示詞不同。
+type commandSpec struct {
+	agent    *agentConfig
+	template *utils.CommandTemplate
+	delivery string
+	workDir  string
+	// values 是提示詞以外的佔位符值
+	values map[string]string
+	// sandboxMode 為空字串時不使用沙箱
+	sandboxMode string
+	sandbox     sandboxOptions
+	// violations 收集沙箱中執行時的疑似違規 (未使用沙箱時為 nil)
+	violations *violationCollector
+	// resumeArgs 是續接 AI CLI 工作階段的參數 (插入執行檔之後，未續接時為 nil)
+	resumeArgs []string
+	limits     resourceLimits
+	redact     *redactor
+}
+
+// agentCommand 是以特定提示詞建構完成的 AI CLI 指令
+type agentCommand struct {
+	exe  string
+	args []string
+	// stdin 是寫入標準輸入的提示詞 (僅 stdin 傳遞方式)
+	stdin string
+	// debugCommand 是 AI CLI 的執行檔與參數 (提示詞內容以長度標記取代，不含沙箱與資源限制)
+	debugCommand []string
+	// sandboxCommand 是包裝 AI CLI 的沙箱指令 (不含 debugCommand 的部分)
+	sandboxCommand []string
+	// promptFile 是提示詞暫存檔路徑 (由 cleanup 刪除)
+	promptFile string
+}
+
+// cleanup 刪除提示詞暫存檔
+func (c *agentCommand) cleanup() {
+	if c.promptFile != "" {
+		os.Remove(c.promptFile)
+	}
+}
+
+// maxOutputLineBytes 是讀取 AI CLI 輸出時單行的長度上限
+// stream-json 等格式可能把整個工具結果放在同一行，遠超過 bufio.Scanner 預設的 64KB。
+const maxOutputLineBytes = 16 << 20
+
+// agentResult 是 AI CLI 程序結束後的結果
+type agentResult struct {
+	// output 是保存的輸出 (已遮蔽機密，超過上限時已截斷)
+	output string
+	state  *os.ProcessState
+	// err 是 cmd.Wait 的錯誤 (非零結束碼、被終止等)；程序正常結束但輸出讀取失敗時為讀取錯誤
+	err error
+}
+
+// build 以提示詞替換模版佔位符，並依設定包裝沙箱與資源限制
+//
+// 參數:
+//   - prompt: 要傳給 AI CLI 的提示詞。
+//
+// 返回:
+//   - *agentCommand: 建構完成的指令 (呼叫端負責呼叫 cleanup)。
+//   - error: 寫入提示詞暫存檔、設定沙箱或資源限制失敗時返回可直接記錄的錯誤訊息。
+func (s *commandSpec) build(prompt string) (*agentCommand, error) {
+	command := &agentCommand{}
+	values := make(map[string]string, len(s.values)+2)
+	for k, v := range s.values {
+		values[k] = v
+	}
+	if s.template.Uses(utils.PlaceholderPromptFile) {
+		promptFile, err := writePromptFile(prompt)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to write prompt file: %v", err)
+		}
+		values[utils.PlaceholderPromptFile] = promptFile
+		command.promptFile = promptFile
+	}
+	// 除錯資訊中以長度標記取代提示詞，避免完整提示詞重複存入資料庫
+	promptMarker := fmt.Sprintf("<prompt: %d bytes>", len(prompt))
+	values[utils.PlaceholderPrompt] = promptMarker
+	exe, debugArgs := s.template.Render(values)
+	values[utils.PlaceholderPrompt] = prompt
+	_, args := s.template.Render(values)
+	// argv 模式下模版未指定提示詞位置時，沿用舊行為將提示詞附加為最後一個參數
+	if s.delivery == models.PromptDeliveryArgv && !s.template.Uses(utils.PlaceholderPrompt) && !s.template.Uses(utils.PlaceholderPromptFile) {
+		args = append(args, prompt)
+		debugArgs = append(debugArgs, promptMarker)
+	}
+	if s.delivery == models.PromptDeliveryStdin {
+		command.stdin = prompt
+	}
+	if len(s.resumeArgs) > 0 {
+		args = append(append([]string{}, s.resumeArgs...), args...)
+		debugArgs = append(append([]string{}, s.resumeArgs...), debugArgs...)
+	}
+	command.debugCommand = append([]string{exe}, debugArgs...)
+
+	if s.sandboxMode != models.SandboxNone {
+		opts := s.sandbox
+		if command.promptFile != "" {
+			opts.ReadOnly = append(append([]string{}, opts.ReadOnly...), command.promptFile)
+		}
+		sandboxExe, sandboxArgs, err := sandboxCommand(s.sandboxMode, opts, exe, args)
+		if err != nil {
+			command.cleanup()
+			return nil, fmt.Errorf("Failed to set up sandbox: %v", err)
+		}
+		command.sandboxCommand = append([]string{sandboxExe}, sandboxArgs[:len(sandboxArgs)-len(args)-1]...)
+		exe, args = sandboxExe, sandboxArgs
+	}
+
+	// 記憶體與 CPU 時間限制包在沙箱外層，沙箱程序本身也受限制
+	exe, args, err := limitCommand(s.limits, exe, args)
+	if err != nil {
+		command.cleanup()
+		return nil, fmt.Errorf("Failed to apply resource limits: %v", err)
+	}
+	command.exe, command.args = exe, args
+	return command, nil
+}
+
+// run 執行指令並等待結束
+//
+// 參數:
+//   - ctx: 執行的 Context (取消或超時時終止整個程序樹)。
+//   - executionID: 執行記錄 ID，輸出會即時推送到 Realtime Broker。
+//   - command: build 建構的指令。
+//
+// 返回:
+//   - *agentResult: 程序結束後的結果。
+//   - error: 建立 Pipe 或啟動程序失敗時返回可直接記錄的錯誤訊息。
+//
+// 說明:
+//   AI CLI 只取得白名單中的伺服器環境變數與專案/AgentProfile 設定的環境變數，
+//   輸出中的機密值在推送、保存前即已遮蔽。
+//   單行輸出超過 maxOutputLineBytes 時，其後的輸出會被讀取但捨棄，
+//   程序正常結束時 agentResult.err 為讀取錯誤 (執行會標記為失敗)。
+func (s *commandSpec) run(ctx context.Context, executionID uint, command *agentCommand) (*agentResult, error) {
+	Log.Debug("Command", "exe", command.exe, "args", command.args)
+	cmd := exec.CommandContext(ctx, command.exe, command.args...)
+	cmd.Dir = s.workDir
+	cmd.Env = s.agent.environ()
+	if s.delivery == models.PromptDeliveryStdin {
+		cmd.Stdin = strings.NewReader(command.stdin)
+	}
+	// 在獨立的 Process Group 中執行，取消或超時時終止整個程序樹 (包含 Agent 產生的子程序)
+	group := newProcessGroup(cmd)
+	cmd.Cancel = group.terminate
+	cmd.WaitDelay = killGracePeriod
+
+	// 使用 Pipe 讀取 Stdout/Stderr，因為我們需要即時串流，而不僅僅是最後收集
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create stdout pipe: %v", err)
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create stderr pipe: %v", err)
+	}
+
+	// 啟動 Goroutine 讀取輸出並廣播 (保存的輸出超過上限時截斷)
+	output := newOutputBuffer(s.limits.MaxOutputBytes)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	var readErrOnce sync.Once
+	var readErr error
+
+	// 定義讀取並廣播的輔助函式
+	readAndBroadcast := func(r io.Reader) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxOutputLineBytes)
+		for scanner.Scan() {
+			// 遮蔽環境變數中的機密值，避免經由串流、資料庫、日誌或 Telegram 外洩
+			text := s.redact.Redact(scanner.Text())
+			if s.violations != nil {
+				s.violations.Inspect(text)
+			}
+			// 廣播到前端
+			if realtime.Broker != nil {
+				realtime.Broker.Publish(executionID, text)
+			}
+			// 收集到 Buffer
+			output.WriteLine(text)
+		}
+		if err := scanner.Err(); err != nil {
+			if err == bufio.ErrTooLong {
+				err = fmt.Errorf("output line exceeds %d bytes", maxOutputLineBytes)
+			}
+			Log.Warn("Failed to read command output", "execution_id", executionID, "error", err)
+			readErrOnce.Do(func() { readErr = err })
+			// 繼續讀完剩餘輸出 (不保存)，避免 Pipe 填滿而讓 Agent 阻塞
+			io.Copy(io.Discard, r)
+		}
+	}
+
+	go readAndBroadcast(stdoutPipe)
+	go readAndBroadcast(stderrPipe)
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to start command: %v", err)
+	}
+
+	// 必須先讀完所有輸出再呼叫 Wait，Wait 會在程序結束後關閉 Pipe
+	wg.Wait()
+	err = cmd.Wait()
+	group.release()
+	if err == nil && readErr != nil {
+		err = fmt.Errorf("Failed to read command output: %v", readErr)
+	}
+	return &agentResult{output: output.String(), state: cmd.ProcessState, err: err}, nil
+}
@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// redactedValue 是輸出中取代機密值的標記
+const redactedValue = "[REDACTED]"
+
+// minRedactLength 是需要遮蔽的最短值 (過短的值例如 "1" 或 "on" 遮蔽後反而會破壞輸出)
+const minRedactLength = 4
+
+// defaultHostEnvWhitelist 是預設允許 AI CLI 繼承的伺服器環境變數
+var defaultHostEnvWhitelist = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_*", "TERM", "TZ", "TMPDIR"}
+
+// hostEnvWhitelist 是允許 AI CLI 繼承的伺服器環境變數 (結尾為 * 時比對前綴)
+var hostEnvWhitelist = defaultHostEnvWhitelist
+
+// SetHostEnvWhitelist 設定允許 AI CLI 繼承的伺服器環境變數
+//
+// 參數:
+//   - names: 環境變數名稱，結尾為 * 時比對前綴 (例如 LC_*)；空列表時恢復預設值。
+//
+// 說明:
+//   不在白名單中的伺服器環境變數 (例如其他專案的 API Key) 不會傳給 AI CLI，
+//   專案需要的機密請設定於專案或 AgentProfile 的環境變數。
+func SetHostEnvWhitelist(names []string) {
+	var whitelist []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			whitelist = append(whitelist, name)
+		}
+	}
+	if len(whitelist) == 0 {
+		whitelist = defaultHostEnvWhitelist
+	}
+	hostEnvWhitelist = whitelist
+}
+
+// inheritedEnv 判斷伺服器環境變數是否在白名單中
+func inheritedEnv(name string) bool {
+	for _, pattern := range hostEnvWhitelist {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// environ 組合 AI CLI 的環境變數: 白名單中的伺服器環境變數，加上設定的環境變數 (同名時以設定為準)
+func (c *agentConfig) environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, overridden := c.EnvVars[name]; !overridden && inheritedEnv(name) {
+			env = append(env, kv)
+		}
+	}
+
+	keys := make([]string, 0, len(c.EnvVars))
+	for k := range c.EnvVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+c.EnvVars[k])
+	}
+	return env
+}
+
+// redactor 將設定的環境變數值從輸出中遮蔽
+type redactor struct {
+	replacer *strings.Replacer
+}
+
+// newRedactor 以環境變數的值建立 redactor
+//
+// 說明:
+//   較長的值優先比對，避免某個值是另一個值的一部分時只遮蔽了一半。
+func newRedactor(env map[string]string) *redactor {
+	values := make([]string, 0, len(env))
+	for _, value := range env {
+		if len(value) >= minRedactLength {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return &redactor{}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	pairs := make([]string, 0, len(values)*2)
+	for _, value := range values {
+		pairs = append(pairs, value, redactedValue)
+	}
+	return &redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// Redact 遮蔽字串中的機密值
+func (r *redactor) Redact(s string) string {
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
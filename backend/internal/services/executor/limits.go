@@ -0,0 +1,129 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/models"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxOutputBytes 是預設保存於執行記錄的輸出上限 (10 MB)
+const DefaultMaxOutputBytes = 10 << 20
+
+// resourceLimits 是單次執行套用的資源限制 (0 表示不限制)
+type resourceLimits struct {
+	Timeout        time.Duration
+	MaxOutputBytes int
+	MemoryMB       int
+	CPUSeconds     int
+}
+
+// resolveLimits 取得專案執行時的資源限制
+//
+// 說明:
+//   執行時間上限依序使用專案、AgentProfile 的設定，都未設定時使用 DefaultTimeout；
+//   輸出上限未設定時使用 DefaultMaxOutputBytes。
+func resolveLimits(project *models.Project, agent *agentConfig) resourceLimits {
+	limits := resourceLimits{
+		Timeout:        agent.Timeout,
+		MaxOutputBytes: DefaultMaxOutputBytes,
+		MemoryMB:       project.MemoryLimitMB,
+		CPUSeconds:     project.CPULimitSeconds,
+	}
+	if project.TimeoutSeconds > 0 {
+		limits.Timeout = time.Duration(project.TimeoutSeconds) * time.Second
+	}
+	if project.MaxOutputBytes > 0 {
+		limits.MaxOutputBytes = project.MaxOutputBytes
+	}
+	return limits
+}
+
+// debugInfo 返回記錄於執行除錯資訊的資源限制
+func (l resourceLimits) debugInfo() *models.ExecutionLimits {
+	return &models.ExecutionLimits{
+		TimeoutSeconds:  int(l.Timeout / time.Second),
+		MaxOutputBytes:  l.MaxOutputBytes,
+		MemoryLimitMB:   l.MemoryMB,
+		CPULimitSeconds: l.CPUSeconds,
+	}
+}
+
+// exceeded 判斷以錯誤結束的程序是否因資源限制而終止
+//
+// 參數:
+//   - state: 程序結束狀態。
+//
+// 返回:
+//   - string: 可直接寫入 ErrorMessage 的原因；不是因資源限制終止時返回空字串。
+//
+// 說明:
+//   只依程序結束的信號判斷，不參考輸出內容 (輸出可能來自 Agent 處理的檔案或日誌)。
+//   CPU 時間：收到 SIGXCPU，或忽略 SIGXCPU 後在硬限制被 SIGKILL 終止 (見 killedByCPULimit)。
+//   記憶體：超過上限時配置記憶體會失敗，但配置失敗常見的信號 (見 killedByMemoryLimit)
+//   也可能來自其他錯誤，因此訊息只說明「可能」超過上限。
+func (l resourceLimits) exceeded(state *os.ProcessState) string {
+	if state == nil {
+		return ""
+	}
+	if l.CPUSeconds > 0 && killedByCPULimit(state, l.CPUSeconds) {
+		return fmt.Sprintf("CPU time limit exceeded (%ds)", l.CPUSeconds)
+	}
+	if l.MemoryMB > 0 && killedByMemoryLimit(state) {
+		return fmt.Sprintf("Memory limit probably exceeded (%d MB, %s)", l.MemoryMB, state)
+	}
+	return ""
+}
+
+// outputBuffer 收集 AI CLI 的輸出 (可同時由 Stdout 與 Stderr 寫入)
+//
+// 說明:
+//   超過上限時保留開頭與結尾各一半 (Agent 的 JSON 結果通常位於結尾)，
+//   並以標記說明省略的位元組數。
+type outputBuffer struct {
+	mu        sync.Mutex
+	limit     int
+	head      strings.Builder
+	tail      []string
+	tailBytes int
+	omitted   int
+}
+
+// newOutputBuffer 建立上限為 limit 位元組的 outputBuffer
+func newOutputBuffer(limit int) *outputBuffer {
+	return &outputBuffer{limit: limit}
+}
+
+// WriteLine 寫入一行輸出
+func (b *outputBuffer) WriteLine(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	line += "\n"
+	if b.tail == nil && b.head.Len()+len(line) <= b.limit/2 {
+		b.head.WriteString(line)
+		return
+	}
+
+	b.tail = append(b.tail, line)
+	b.tailBytes += len(line)
+	for b.tailBytes > b.limit-b.limit/2 && len(b.tail) > 0 {
+		b.omitted += len(b.tail[0])
+		b.tailBytes -= len(b.tail[0])
+		b.tail = b.tail[1:]
+	}
+}
+
+// String 返回保存的輸出
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.omitted == 0 {
+		return b.head.String() + strings.Join(b.tail, "")
+	}
+	marker := fmt.Sprintf("\n... [output truncated: %d bytes omitted] ...\n\n", b.omitted)
+	return b.head.String() + marker + strings.Join(b.tail, "")
+}
@@ -0,0 +1,88 @@
+//go:build !windows
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cpuHardLimitGrace 是 CPU 時間硬限制比軟限制多出的秒數
+const cpuHardLimitGrace = 5
+
+// limitCommand 在 AI CLI 執行前以 ulimit 套用記憶體與 CPU 時間限制
+//
+// 參數:
+//   - limits: 資源限制。
+//   - exe: 要執行的執行檔。
+//   - args: 參數。
+//
+// 返回:
+//   - string: 實際執行的執行檔 (有限制時為 /bin/sh)。
+//   - []string: 實際執行的參數。
+//   - error: 目前平台不支援時返回錯誤。
+//
+// 說明:
+//   限制在 exec 之前由 sh 設定，因此在 AI CLI 啟動的第一刻即生效，並由其子程序繼承。
+//   限制的對象是每個程序 (RLIMIT_DATA 與 RLIMIT_CPU)，而非整個程序樹的總和：
+//   Agent 產生多個子程序時，整體使用量可以超過上限。
+//   執行檔與參數以位置參數傳給 sh，不會被 Shell 解讀。
+func limitCommand(limits resourceLimits, exe string, args []string) (string, []string, error) {
+	var script []string
+	if limits.MemoryMB > 0 {
+		script = append(script, fmt.Sprintf("ulimit -d %d", limits.MemoryMB*1024))
+	}
+	if limits.CPUSeconds > 0 {
+		// 達到軟限制時送出 SIGXCPU；硬限制多保留一些時間，程序忽略 SIGXCPU 時才以 SIGKILL 終止
+		script = append(script,
+			fmt.Sprintf("ulimit -t %d", limits.CPUSeconds+cpuHardLimitGrace),
+			fmt.Sprintf("ulimit -S -t %d", limits.CPUSeconds))
+	}
+	if len(script) == 0 {
+		return exe, args, nil
+	}
+	script = append(script, `exec "$0" "$@"`)
+	return "/bin/sh", append([]string{"-c", strings.Join(script, " && "), exe}, args...), nil
+}
+
+// killedByCPULimit 判斷程序是否因超過 CPU 時間上限而終止
+//
+// 參數:
+//   - state: 程序結束狀態。
+//   - limitSeconds: CPU 時間軟限制 (秒)。
+//
+// 說明:
+//   達到軟限制時程序收到 SIGXCPU；忽略 SIGXCPU 的程序在硬限制 (limitSeconds + cpuHardLimitGrace)
+//   由核心以 SIGKILL 終止，此時以已使用的 CPU 時間區分是否為其他來源的 SIGKILL。
+func killedByCPULimit(state *os.ProcessState, limitSeconds int) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return false
+	}
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		return true
+	case syscall.SIGKILL:
+		used := state.UserTime() + state.SystemTime()
+		return used >= time.Duration(limitSeconds+cpuHardLimitGrace)*time.Second
+	}
+	return false
+}
+
+// killedByMemoryLimit 判斷程序是否以記憶體配置失敗時常見的信號 (SIGABRT、SIGSEGV、SIGBUS) 結束
+// 例如 C++ 的 std::bad_alloc 與 Node.js 的 heap 配置失敗會 abort；以錯誤碼結束的程序無法與一般失敗區分。
+// 這些信號也可能來自程式本身的錯誤，因此只能作為「可能」超過上限的依據。
+func killedByMemoryLimit(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return false
+	}
+	switch status.Signal() {
+	case syscall.SIGABRT, syscall.SIGSEGV, syscall.SIGBUS:
+		return true
+	}
+	return false
+}
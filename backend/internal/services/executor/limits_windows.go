@@ -0,0 +1,26 @@
+//go:build windows
+
+package executor
+
+import (
+	"errors"
+	"os"
+)
+
+// limitCommand 在 Windows 上不支援記憶體與 CPU 時間限制
+func limitCommand(limits resourceLimits, exe string, args []string) (string, []string, error) {
+	if limits.MemoryMB > 0 || limits.CPUSeconds > 0 {
+		return "", nil, errors.New("memory and CPU limits are not supported on Windows")
+	}
+	return exe, args, nil
+}
+
+// killedByCPULimit 在 Windows 上永遠返回 false
+func killedByCPULimit(state *os.ProcessState, limitSeconds int) bool {
+	return false
+}
+
+// killedByMemoryLimit 在 Windows 上永遠返回 false
+func killedByMemoryLimit(state *os.ProcessState) bool {
+	return false
+}
@@ -0,0 +1,106 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/utils"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// MaxProjectMemoryLength 是專案記憶的長度上限 (字元數)，避免提示詞無限制成長
+const MaxProjectMemoryLength = 20000
+
+// ErrMemoryFull 表示新增筆記後專案記憶會超過 MaxProjectMemoryLength
+var ErrMemoryFull = fmt.Errorf("project memory would exceed %d characters", MaxProjectMemoryLength)
+
+// memoryMu 序列化專案記憶的讀取與寫入 (API、Telegram 與 Agent 的更新可能同時發生)
+var memoryMu sync.Mutex
+
+// ValidateMemoryNote 驗證單筆專案記憶筆記 (不可為空且不超過 utils.MaxMemoryNoteLength)
+func ValidateMemoryNote(note string) error {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return errors.New("note must not be empty")
+	}
+	if n := utf8.RuneCountInString(note); n > utils.MaxMemoryNoteLength {
+		return fmt.Errorf("note must be at most %d characters (got %d)", utils.MaxMemoryNoteLength, n)
+	}
+	return nil
+}
+
+// AppendProjectMemory 將筆記以 "- 筆記" 的格式逐行加入專案記憶
+//
+// 參數:
+//   - projectID: 專案 ID。
+//   - notes: 要加入的筆記 (呼叫端負責以 ValidateMemoryNote 驗證)。
+//
+// 返回:
+//   - string: 更新後的專案記憶。
+//   - error: 專案不存在時返回 ErrProjectNotFound，超過長度上限時返回 ErrMemoryFull (不會寫入任何筆記)。
+func AppendProjectMemory(projectID uint, notes []string) (string, error) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	var project models.Project
+	if err := database.DB.Select("id", "memory").First(&project, projectID).Error; err != nil {
+		return "", ErrProjectNotFound
+	}
+
+	var memory strings.Builder
+	memory.WriteString(project.Memory)
+	if project.Memory != "" && !strings.HasSuffix(project.Memory, "\n") {
+		memory.WriteString("\n")
+	}
+	for _, note := range notes {
+		// 筆記中的換行會破壞清單格式，合併為單行
+		memory.WriteString("- " + strings.Join(strings.Fields(note), " ") + "\n")
+	}
+	if utf8.RuneCountInString(memory.String()) > MaxProjectMemoryLength {
+		return "", ErrMemoryFull
+	}
+
+	// 只更新 memory 欄位，避免覆蓋其他欄位
+	if err := database.DB.Model(&project).UpdateColumn("memory", memory.String()).Error; err != nil {
+		return "", err
+	}
+	return memory.String(), nil
+}
+
+// SetProjectMemory 取代整份專案記憶
+//
+// 返回:
+//   - error: 專案不存在時返回 ErrProjectNotFound，超過長度上限時返回 ErrMemoryFull。
+func SetProjectMemory(projectID uint, memory string) error {
+	if utf8.RuneCountInString(memory) > MaxProjectMemoryLength {
+		return ErrMemoryFull
+	}
+
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	result := database.DB.Model(&models.Project{}).Where("id = ?", projectID).UpdateColumn("memory", memory)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrProjectNotFound
+	}
+	return nil
+}
+
+// applyMemoryUpdates 將 Agent 回傳的 memory_updates 加入專案記憶 (專案啟用 MemoryAutoUpdate 時)
+// 失敗時只記錄警告，不影響執行結果。
+func applyMemoryUpdates(project *models.Project, execution *models.Execution) {
+	if !project.MemoryAutoUpdate || len(execution.MemoryUpdates) == 0 {
+		return
+	}
+	if _, err := AppendProjectMemory(project.ID, execution.MemoryUpdates); err != nil {
+		Log.Warn("Failed to apply memory updates", "execution_id", execution.ID, "error", err)
+		return
+	}
+	Log.Info("Project memory updated", "execution_id", execution.ID, "notes", len(execution.MemoryUpdates))
+}
@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/utils"
+	"context"
+	"fmt"
+)
+
+// repairOutput 在輸出無法解析或不符合 JSON Schema 時，要求同一個 Agent 只重新輸出修正後的 JSON
+//
+// 參數:
+//   - ctx: 由佇列 Worker 建立的 Context (使用者取消時修復回合也會被終止)。
+//   - execution: 執行記錄，兩次嘗試都會記錄於 OutputAttempts。
+//   - spec: 主要執行使用的指令設定 (相同的模版、沙箱、資源限制與環境變數)。
+//   - parser: 主要執行使用的輸出解析器。
+//   - previousOutput: 主要執行的輸出。
+//   - cause: 主要執行的輸出解析或驗證失敗的原因。
+//
+// 返回:
+//   - *utils.ParsedOutput: 修復後通過驗證的結果。
+//   - error: 修復回合仍失敗時返回錯誤 (包含兩次失敗的原因)。
+//
+// 說明:
+//   修復回合只進行一次，提示詞包含失敗原因、上一次輸出的結尾與 JSON Schema。
+func repairOutput(ctx context.Context, execution *models.Execution, spec *commandSpec, parser utils.OutputParser, previousOutput string, cause error) (*utils.ParsedOutput, error) {
+	execution.OutputAttempts = []models.OutputAttempt{{Error: cause.Error()}}
+	attempt := models.OutputAttempt{Repair: true}
+	defer func() {
+		execution.OutputAttempts = append(execution.OutputAttempts, attempt)
+	}()
+
+	// 修復回合只需要上一次的輸出，不續接 AI CLI 的工作階段
+	repairSpec := *spec
+	repairSpec.resumeArgs = nil
+
+	Log.Info("Starting output repair round", "execution_id", execution.ID, "error", cause)
+	parsed, err := runRepairRound(ctx, execution, &repairSpec, parser, utils.BuildRepairPrompt(previousOutput, cause), &attempt)
+	if err != nil {
+		attempt.Error = err.Error()
+		Log.Warn("Output repair round failed", "execution_id", execution.ID, "error", err)
+		return nil, fmt.Errorf("%v (repair attempt: %v)", cause, err)
+	}
+	Log.Info("Output repaired", "execution_id", execution.ID)
+	return parsed, nil
+}
+
+// runRepairRound 執行修復回合並解析、驗證其輸出 (輸出會記錄於 attempt)
+func runRepairRound(ctx context.Context, execution *models.Execution, spec *commandSpec, parser utils.OutputParser, prompt string, attempt *models.OutputAttempt) (*utils.ParsedOutput, error) {
+	command, err := spec.build(prompt)
+	if err != nil {
+		return nil, err
+	}
+	defer command.cleanup()
+
+	runCtx, cancel := context.WithTimeout(ctx, spec.limits.Timeout)
+	defer cancel()
+	result, err := spec.run(runCtx, execution.ID, command)
+	if err != nil {
+		return nil, err
+	}
+	attempt.Output = result.output
+
+	if runCtx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("timed out after %s", spec.limits.Timeout)
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	parsed, err := parser.Parse(result.output)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.ValidateOutput(parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
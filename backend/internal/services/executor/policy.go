@@ -0,0 +1,44 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/workspace"
+	"agent-workspace-manager/internal/utils"
+	"fmt"
+)
+
+// verifyPathSafety 檢查 Agent 觸及的所有檔案路徑是否位於專案目錄內，並將違規記錄於執行記錄
+//
+// 參數:
+//   - execution: 執行記錄，違規會寫入 PolicyViolations (每次呼叫都會重新計算)。
+//   - root: Agent 的工作目錄。
+//   - parsed: Agent 輸出中回報的檔案列表 (可選；Git 比對結果優先時仍需檢查 Agent 自行回報的路徑)。
+//
+// 說明:
+//   提示詞要求 Agent 不使用絕對路徑或 ..，這裡在執行後實際驗證。
+//   檢查的路徑包含執行記錄上的檔案列表 (Git 或備份比對結果) 與 Agent 回報的列表。
+func verifyPathSafety(execution *models.Execution, root string, parsed *utils.ParsedOutput) {
+	lists := [][]string{execution.ModifiedFiles, execution.CreatedFiles, execution.DeletedFiles}
+	if parsed != nil {
+		lists = append(lists, parsed.ModifiedFiles, parsed.CreatedFiles, parsed.DeletedFiles)
+	}
+
+	var violations []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, path := range list {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if reason := workspace.CheckPathSafety(root, path); reason != "" {
+				violations = append(violations, fmt.Sprintf("%s: %s", path, reason))
+			}
+		}
+	}
+
+	execution.PolicyViolations = violations
+	if len(violations) > 0 {
+		Log.Warn("File path policy violations detected", "execution_id", execution.ID, "count", len(violations))
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !windows
+
+package executor
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// killGracePeriod 是送出 SIGTERM 後等待程序結束的時間，逾時則改送 SIGKILL
+const killGracePeriod = 10 * time.Second
+
+// processGroup 是指令所在的 Process Group (PGID 即為主程序的 PID)
+//
+// 說明:
+//   信號只在指令的生命週期內送出: cmd.Wait 返回後呼叫 release，之後不再對此 PGID 送出任何信號，
+//   避免在整個群組結束、PGID 被其他程序重複使用後誤殺無關的程序。
+type processGroup struct {
+	cmd *exec.Cmd
+
+	mu          sync.Mutex
+	killTimer   *time.Timer
+	terminating bool
+	released    bool
+}
+
+// newProcessGroup 讓指令在新的 Process Group 中執行
+func newProcessGroup(cmd *exec.Cmd) *processGroup {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return &processGroup{cmd: cmd}
+}
+
+// terminate 終止整個 Process Group (作為 cmd.Cancel 使用)
+//
+// 說明:
+//   先送出 SIGTERM 讓 Agent 有機會自行收尾，經過 killGracePeriod 後
+//   若指令仍未結束則送出 SIGKILL。
+func (g *processGroup) terminate() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.released || g.cmd.Process == nil {
+		return nil
+	}
+	if err := syscall.Kill(-g.cmd.Process.Pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	g.terminating = true
+	g.killTimer = time.AfterFunc(killGracePeriod, g.kill)
+	return nil
+}
+
+// kill 在寬限期結束後以 SIGKILL 終止仍存活的程序 (指令已結束時不做任何事)
+func (g *processGroup) kill() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.released {
+		return
+	}
+	syscall.Kill(-g.cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// release 在 cmd.Wait 返回後呼叫，停止尚未觸發的 SIGKILL 定時器
+//
+// 說明:
+//   已要求終止但群組中仍有存活的程序 (例如忽略 SIGTERM、在主程序結束後仍佔用輸出的子程序) 時，
+//   立即送出 SIGKILL；群組仍有成員時 PGID 不會被重複使用。
+func (g *processGroup) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.released {
+		return
+	}
+	g.released = true
+	if !g.terminating {
+		return
+	}
+	g.killTimer.Stop()
+	pgid := g.cmd.Process.Pid
+	if syscall.Kill(-pgid, 0) == nil {
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+}
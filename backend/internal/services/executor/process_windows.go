@@ -0,0 +1,32 @@
+//go:build windows
+
+package executor
+
+import (
+	"os/exec"
+	"time"
+)
+
+// killGracePeriod 是終止程序後等待輸出 Pipe 關閉的時間
+const killGracePeriod = 10 * time.Second
+
+// processGroup 在 Windows 上只追蹤主程序
+type processGroup struct {
+	cmd *exec.Cmd
+}
+
+// newProcessGroup 在 Windows 上不建立新的程序群組
+func newProcessGroup(cmd *exec.Cmd) *processGroup {
+	return &processGroup{cmd: cmd}
+}
+
+// terminate 在 Windows 上僅終止主程序 (作為 cmd.Cancel 使用)
+func (g *processGroup) terminate() error {
+	if g.cmd.Process == nil {
+		return nil
+	}
+	return g.cmd.Process.Kill()
+}
+
+// release 在 Windows 上不做任何事
+func (g *processGroup) release() {}
@@ -0,0 +1,65 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"fmt"
+	"time"
+)
+
+// agentConfig 是執行時實際使用的 AI CLI 設定 (來自 AgentProfile 或專案本身)
+type agentConfig struct {
+	// ProfileName 是使用的 AgentProfile 名稱 (未使用時為空字串)
+	ProfileName     string
+	CommandTemplate string
+	PromptDelivery  string
+	// EnvVars 是 AgentProfile 與專案設定的環境變數 (同名時以專案為準)
+	EnvVars      map[string]string
+	OutputParser string
+	Timeout      time.Duration
+	// ResumeArgs 是續接 AI CLI 先前對話的參數模版 (只能由 AgentProfile 設定)
+	ResumeArgs string
+}
+
+// resolveAgentConfig 取得專案執行時使用的 AI CLI 設定
+//
+// 說明:
+//   專案引用 AgentProfile 時使用設定檔的指令模版、提示詞傳遞方式、環境變數與超時時間；
+//   否則使用專案自身的 AICliCommand 與 PromptDelivery。專案的環境變數會疊加在設定檔之上，
+//   專案設定的 OutputParser 優先於設定檔。
+func resolveAgentConfig(project *models.Project) (*agentConfig, error) {
+	if project.AgentProfileID == nil {
+		return &agentConfig{
+			CommandTemplate: project.AICliCommand,
+			PromptDelivery:  project.PromptDelivery,
+			EnvVars:         project.EnvVars,
+			OutputParser:    project.OutputParser,
+			Timeout:         DefaultTimeout,
+		}, nil
+	}
+
+	var profile models.AgentProfile
+	if err := database.DB.First(&profile, *project.AgentProfileID).Error; err != nil {
+		return nil, fmt.Errorf("agent profile %d not found", *project.AgentProfileID)
+	}
+	env := make(map[string]string, len(profile.EnvVars)+len(project.EnvVars))
+	for k, v := range profile.EnvVars {
+		env[k] = v
+	}
+	for k, v := range project.EnvVars {
+		env[k] = v
+	}
+	parser := profile.OutputParser
+	if project.OutputParser != "" {
+		parser = project.OutputParser
+	}
+	return &agentConfig{
+		ProfileName:     profile.Name,
+		CommandTemplate: profile.CommandTemplate,
+		PromptDelivery:  profile.PromptDelivery,
+		EnvVars:         env,
+		OutputParser:    parser,
+		Timeout:         profile.Timeout(DefaultTimeout),
+		ResumeArgs:      profile.ResumeArgs,
+	}, nil
+}
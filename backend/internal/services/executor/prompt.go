@@ -0,0 +1,142 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/utils"
+	"fmt"
+	"sort"
+)
+
+// DefaultHistoryCount 是專案未設定 HistoryCount 時，提示詞中作為 Context 的最近執行記錄數量
+const DefaultHistoryCount = 5
+
+// resolvePromptTemplate 取得專案使用的提示詞模版
+//
+// 說明:
+//   專案指定 PromptTemplateID 時使用該模版，否則使用名為 default 的模版；
+//   資料庫中沒有 default 模版時使用內建的 utils.DefaultPromptTemplate。
+func resolvePromptTemplate(project *models.Project) (*models.PromptTemplate, error) {
+	var tmpl models.PromptTemplate
+	if project.PromptTemplateID != nil {
+		if err := database.DB.First(&tmpl, *project.PromptTemplateID).Error; err != nil {
+			return nil, fmt.Errorf("prompt template %d not found", *project.PromptTemplateID)
+		}
+		return &tmpl, nil
+	}
+	if err := database.DB.Where("name = ?", models.DefaultPromptTemplateName).First(&tmpl).Error; err != nil {
+		return &models.PromptTemplate{Name: models.DefaultPromptTemplateName, Content: utils.DefaultPromptTemplate}, nil
+	}
+	return &tmpl, nil
+}
+
+// selectHistory 依專案設定選出提示詞中作為 Context 的過去執行記錄
+//
+// 參數:
+//   - project: 所屬專案 (HistoryCount、HistoryMaxChars、HistoryIncludeFailed、HistoryIncludeFiles)。
+//   - execution: 此次執行記錄 (不會被選入)。
+//
+// 返回:
+//   - []utils.HistoryItem: 選出的記錄 (由舊到新)。
+//
+// 說明:
+//   釘選 (Pinned) 的記錄不論狀態、數量與字元數預算都會包含；
+//   其餘記錄從最新的已完成 (與專案設定包含的失敗) 執行開始選取，直到數量上限或超過字元數預算為止。
+//   同一個工作階段的執行已作為對話放入提示詞，不會重複選入。
+func selectHistory(project *models.Project, execution *models.Execution) []utils.HistoryItem {
+	var pinned []models.Execution
+	database.DB.Where("project_id = ? AND id != ? AND pinned = ?", execution.ProjectID, execution.ID, true).
+		Order("id asc").
+		Find(&pinned)
+
+	count := DefaultHistoryCount
+	if project.HistoryCount != nil {
+		count = *project.HistoryCount
+	}
+	var recent []models.Execution
+	if count > 0 {
+		statuses := []string{models.StatusCompleted}
+		if project.HistoryIncludeFailed {
+			statuses = append(statuses, models.FailureStatuses...)
+		}
+		query := database.DB.Where("project_id = ? AND id != ? AND pinned = ? AND status IN ?", execution.ProjectID, execution.ID, false, statuses)
+		if execution.SessionID != nil {
+			query = query.Where("session_id IS NULL OR session_id != ?", *execution.SessionID)
+		}
+		query.Order("created_at desc").
+			Limit(count).
+			Find(&recent)
+	}
+
+	items := make([]utils.HistoryItem, 0, len(pinned)+len(recent))
+	budget := project.HistoryMaxChars
+	for _, e := range pinned {
+		item := utils.NewHistoryItem(e, project.HistoryIncludeFiles)
+		budget -= item.Size()
+		items = append(items, item)
+	}
+	for _, e := range recent {
+		item := utils.NewHistoryItem(e, project.HistoryIncludeFiles)
+		if project.HistoryMaxChars > 0 {
+			if item.Size() > budget {
+				break
+			}
+			budget -= item.Size()
+		}
+		items = append(items, item)
+	}
+
+	// 依建立順序排列，讓舊的在前，新的在後，符合閱讀邏輯
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+// ExecutionPrompt 是建構完成的執行提示詞
+type ExecutionPrompt struct {
+	// Prompt 是完整提示詞
+	Prompt string
+	// Template 是使用的模版名稱 (使用預覽的模版內容時為空字串)
+	Template string
+	// HistoryIDs 是作為 Context 的過去執行記錄 ID (由舊到新)
+	HistoryIDs []uint
+}
+
+// BuildExecutionPrompt 以專案的提示詞模版建構執行的完整提示詞
+//
+// 參數:
+//   - project: 所屬專案。
+//   - execution: 執行記錄 (預覽時可為尚未寫入資料庫的記錄，ID 為 0)。
+//   - override: 取代專案模版的模版內容 (可選，用於預覽尚未儲存的模版)。
+//
+// 返回:
+//   - *ExecutionPrompt: 完整提示詞、使用的模版與作為 Context 的執行記錄。
+//   - error: 模版不存在或渲染失敗時返回錯誤。
+func BuildExecutionPrompt(project *models.Project, execution *models.Execution, override string) (*ExecutionPrompt, error) {
+	result := &ExecutionPrompt{}
+	content := override
+	if override == "" {
+		tmpl, err := resolvePromptTemplate(project)
+		if err != nil {
+			return nil, err
+		}
+		content, result.Template = tmpl.Content, tmpl.Name
+	}
+
+	history := selectHistory(project, execution)
+	for _, item := range history {
+		result.HistoryIDs = append(result.HistoryIDs, item.ID)
+	}
+
+	prompt, err := utils.RenderPrompt(content, utils.PromptData{
+		Project:   utils.NewPromptProject(*project),
+		History:   history,
+		Session:   sessionTurns(execution),
+		Execution: *execution,
+		UserInput: execution.Command,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render prompt template %q: %v", result.Template, err)
+	}
+	result.Prompt = prompt
+	return result, nil
+}
@@ -0,0 +1,225 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrProjectNotFound 表示要加入佇列的專案不存在
+var ErrProjectNotFound = errors.New("project not found")
+
+// queueLock 保護 workers、callbacks 與 running 三個 map
+var queueLock sync.Mutex
+
+// workers 記錄目前有 Worker 正在消化佇列的專案
+// 鍵為專案 ID，值為是否有 Worker 執行中
+var workers = make(map[uint]bool)
+
+// callbacks 儲存尚在佇列中的執行記錄對應的回呼函式
+// 鍵為執行記錄 ID。回呼函式只存在記憶體中，伺服器重啟後會改用 recoveredCallback。
+var callbacks = make(map[uint]CompletionCallback)
+
+// running 儲存執行中記錄的取消函式，供 CancelExecution 使用
+// 鍵為執行記錄 ID
+var running = make(map[uint]context.CancelCauseFunc)
+
+// recoveredCallback 是重啟後恢復的佇列項目所使用的回呼函式
+var recoveredCallback CompletionCallback
+
+// InitQueue 初始化持久化執行佇列
+//
+// 參數:
+//   - onRecovered: 伺服器重啟前已排入佇列之執行記錄的完成回呼 (可選)。
+//
+// 功能:
+//   將重啟前仍為 Running 的執行記錄標記為失敗 (程序已隨伺服器結束)，並呼叫 onRecovered。
+//
+// 說明:
+//   必須在啟動排程器之前呼叫，否則排程器補執行的記錄會被誤判為被中斷而標記為失敗。
+//   仍在佇列中的執行記錄由 ResumeQueue 繼續執行。
+func InitQueue(onRecovered CompletionCallback) {
+	if Log == nil {
+		Log = slog.Default()
+	}
+	recoveredCallback = onRecovered
+
+	var interrupted []models.Execution
+	database.DB.Where("status = ?", models.StatusRunning).Find(&interrupted)
+	for i := range interrupted {
+		execution := &interrupted[i]
+		execution.Status = models.StatusFailed
+		execution.ErrorMessage = "Execution interrupted by server restart"
+		execution.EndTime = time.Now()
+		database.DB.Save(execution)
+		Log.Warn("Marked interrupted execution as failed", "execution_id", execution.ID)
+		if recoveredCallback != nil {
+			recoveredCallback(execution)
+		}
+	}
+}
+
+// ResumeQueue 為所有仍有 Queued 執行記錄的專案啟動 Worker，繼續依序消化重啟前的佇列
+func ResumeQueue() {
+	var projectIDs []uint
+	database.DB.Model(&models.Execution{}).
+		Where("status = ?", models.StatusQueued).
+		Distinct().
+		Pluck("project_id", &projectIDs)
+
+	for _, projectID := range projectIDs {
+		Log.Info("Resuming execution queue", "project_id", projectID)
+		startWorker(projectID)
+	}
+}
+
+// ExecuteCommand 將 AI Agent 指令加入專案的執行佇列
+//
+// 參數:
+//   - projectID: 目標專案 ID。
+//   - userCommand: 使用者輸入的指令或提示詞。
+//   - onComplete: 執行完成後的回呼函式 (可選)。
+//
+// 返回:
+//   - *models.Execution: 已建立的執行記錄 (Queued 狀態)。
+//   - error: 專案不存在或寫入資料庫失敗時返回錯誤。
+//
+// 說明:
+//   執行記錄會先以 Queued 狀態寫入 SQLite，再由該專案的 Worker 依 FIFO 順序執行，
+//   因此專案忙碌時新的指令會排隊等待，而不是被拒絕。
+func ExecuteCommand(projectID uint, userCommand string, onComplete CompletionCallback) (*models.Execution, error) {
+	execution := &models.Execution{
+		ProjectID: projectID,
+		Command:   userCommand,
+	}
+	if err := Enqueue(execution, onComplete); err != nil {
+		return nil, err
+	}
+	return execution, nil
+}
+
+// Enqueue 將預先填好欄位的執行記錄加入專案的執行佇列
+//
+// 參數:
+//   - execution: 至少需填入 ProjectID 與 Command，其餘欄位 (如 ScheduleID) 由呼叫端決定。
+//   - onComplete: 執行完成後的回呼函式 (可選)。
+//
+// 返回:
+//   - error: 專案不存在或寫入資料庫失敗時返回錯誤。
+func Enqueue(execution *models.Execution, onComplete CompletionCallback) error {
+	if Log == nil {
+		Log = slog.Default()
+	}
+
+	var project models.Project
+	if err := database.DB.First(&project, execution.ProjectID).Error; err != nil {
+		return ErrProjectNotFound
+	}
+
+	execution.Status = models.StatusQueued
+	// 持有 queueLock 寫入記錄並註冊回呼，避免執行中的 Worker 在回呼註冊前就取出這筆記錄
+	queueLock.Lock()
+	if err := database.DB.Create(execution).Error; err != nil {
+		queueLock.Unlock()
+		return err
+	}
+	if onComplete != nil {
+		callbacks[execution.ID] = onComplete
+	}
+	execution.QueuePosition = GetQueuePosition(execution)
+	queueLock.Unlock()
+	Log.Info("Execution queued", "execution_id", execution.ID, "project_id", execution.ProjectID, "position", execution.QueuePosition)
+
+	startWorker(execution.ProjectID)
+	return nil
+}
+
+// GetQueuePosition 計算執行記錄在專案佇列中的位置
+//
+// 參數:
+//   - execution: 執行記錄。
+//
+// 返回:
+//   - int: 從 1 開始的佇列位置；若執行記錄不在佇列中則返回 0。
+func GetQueuePosition(execution *models.Execution) int {
+	if execution.Status != models.StatusQueued {
+		return 0
+	}
+	var ahead int64
+	database.DB.Model(&models.Execution{}).
+		Where("project_id = ? AND status = ? AND id < ?", execution.ProjectID, models.StatusQueued, execution.ID).
+		Count(&ahead)
+	return int(ahead) + 1
+}
+
+// GetQueue 取得專案目前排隊中的執行記錄 (依 FIFO 順序，並填入佇列位置)
+func GetQueue(projectID uint) []models.Execution {
+	var queued []models.Execution
+	database.DB.Where("project_id = ? AND status = ?", projectID, models.StatusQueued).
+		Order("id asc").
+		Find(&queued)
+	for i := range queued {
+		queued[i].QueuePosition = i + 1
+	}
+	return queued
+}
+
+// startWorker 確保專案有一個 Worker 在消化佇列
+//
+// 說明:
+//   若該專案已有 Worker 執行中則不做任何事，Worker 會在下一輪取到新的佇列項目。
+func startWorker(projectID uint) {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+
+	if workers[projectID] {
+		return
+	}
+	workers[projectID] = true
+	go runWorker(projectID)
+}
+
+// runWorker 依 FIFO 順序逐一執行專案佇列中的執行記錄
+//
+// 說明:
+//   取下一筆佇列項目時持有 queueLock，確保佇列清空與 Worker 結束是原子操作，
+//   避免與 startWorker 之間產生遺失喚醒 (lost wake-up) 的競態。
+func runWorker(projectID uint) {
+	for {
+		queueLock.Lock()
+		var next models.Execution
+		err := database.DB.Where("project_id = ? AND status = ?", projectID, models.StatusQueued).
+			Order("id asc").
+			First(&next).Error
+		if err != nil {
+			delete(workers, projectID)
+			queueLock.Unlock()
+			return
+		}
+		onComplete, ok := callbacks[next.ID]
+		if ok {
+			delete(callbacks, next.ID)
+		} else {
+			onComplete = recoveredCallback
+		}
+		// 在釋放 queueLock 前註冊取消函式，避免 CancelExecution 在此空檔把記錄當成仍在排隊
+		ctx, cancel := context.WithCancelCause(context.Background())
+		running[next.ID] = cancel
+		queueLock.Unlock()
+
+		if next.RevertOfID != nil {
+			runRevert(ctx, &next, onComplete)
+		} else {
+			runExecution(ctx, &next, onComplete)
+		}
+
+		queueLock.Lock()
+		delete(running, next.ID)
+		queueLock.Unlock()
+		cancel(nil)
+	}
+}
@@ -0,0 +1,135 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/realtime"
+	"agent-workspace-manager/internal/services/workspace"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNotRevertable 表示執行記錄尚未結束，無法還原
+	ErrNotRevertable = errors.New("execution is still queued or running")
+	// ErrNoSnapshot 表示執行記錄沒有可供還原的快照
+	ErrNoSnapshot = errors.New("execution has no snapshot to revert")
+	// ErrAlreadyReverted 表示執行記錄已被還原 (或還原正在排隊/執行中)
+	ErrAlreadyReverted = errors.New("execution has already been reverted")
+)
+
+// RevertExecution 將執行記錄所做的檔案變更還原為執行前的狀態
+//
+// 參數:
+//   - executionID: 要還原的執行記錄 ID。
+//   - onComplete: 還原完成後的回呼函式 (可選)。
+//
+// 返回:
+//   - *models.Execution: 代表此次還原的執行記錄 (Queued 狀態)。
+//   - error: 執行記錄不存在 (ErrExecutionNotFound)、尚未結束 (ErrNotRevertable)、
+//     沒有快照 (ErrNoSnapshot) 或已被還原 (ErrAlreadyReverted) 時返回錯誤。
+//
+// 說明:
+//   還原本身會作為一筆新的執行記錄排入專案佇列，與其他指令依序執行，
+//   因此也會有自己的快照，必要時可以再次還原。
+//   只有被該次執行觸及的檔案會被還原，其餘檔案 (包含之後其他執行的變更) 不受影響。
+func RevertExecution(executionID uint, onComplete CompletionCallback) (*models.Execution, error) {
+	var original models.Execution
+	if err := database.DB.First(&original, executionID).Error; err != nil {
+		return nil, ErrExecutionNotFound
+	}
+	if !original.IsFinished() {
+		return nil, ErrNotRevertable
+	}
+	if original.SnapshotKind == "" {
+		return nil, ErrNoSnapshot
+	}
+	if original.RevertedByID != nil {
+		// 先前的還原失敗或被取消時允許重試
+		var previous models.Execution
+		if err := database.DB.First(&previous, *original.RevertedByID).Error; err == nil &&
+			(!previous.IsFinished() || previous.Status == models.StatusCompleted) {
+			return nil, ErrAlreadyReverted
+		}
+	}
+
+	revert := &models.Execution{
+		ProjectID:  original.ProjectID,
+		Command:    fmt.Sprintf("Revert execution #%d", original.ID),
+		RevertOfID: &original.ID,
+	}
+	if err := Enqueue(revert, onComplete); err != nil {
+		return nil, err
+	}
+	database.DB.Model(&original).Update("reverted_by_id", revert.ID)
+	return revert, nil
+}
+
+// runRevert 執行還原的核心邏輯 (由佇列 Worker 呼叫)
+//
+// 參數:
+//   - ctx: 由佇列 Worker 建立的 Context (還原不會中途取消，僅在開始前檢查)。
+//   - execution: 代表還原的執行記錄 (RevertOfID 不為空)。
+//   - onComplete: 完成後的回呼函式 (可選)。
+func runRevert(ctx context.Context, execution *models.Execution, onComplete CompletionCallback) {
+	var project models.Project
+	if err := database.DB.First(&project, execution.ProjectID).Error; err != nil {
+		finalizeExecution(execution, models.StatusFailed, "Project not found", "", onComplete)
+		return
+	}
+	var original models.Execution
+	if err := database.DB.First(&original, *execution.RevertOfID).Error; err != nil {
+		finalizeExecution(execution, models.StatusFailed, "Reverted execution not found", "", onComplete)
+		return
+	}
+	if context.Cause(ctx) == ErrCancelled {
+		finalizeExecution(execution, models.StatusCancelled, "Cancelled by user", "", onComplete)
+		return
+	}
+
+	execution.Status = models.StatusRunning
+	execution.StartTime = time.Now()
+	database.DB.Save(execution)
+	Log.Info("Starting revert", "execution_id", execution.ID, "reverted_execution_id", original.ID)
+
+	snapshot := captureSnapshot(execution, project.DirectoryPath, ignoreList(&project))
+
+	var restored, removed []string
+	var err error
+	switch original.SnapshotKind {
+	case models.SnapshotGit:
+		restored = append(append(restored, original.ModifiedFiles...), original.DeletedFiles...)
+		removed = original.CreatedFiles
+		err = workspace.RestoreGitSnapshot(project.DirectoryPath, original.SnapshotRef, restored, removed)
+	case models.SnapshotBackup:
+		var backup *workspace.BackupSnapshot
+		if backup, err = workspace.LoadBackupSnapshot(original.SnapshotRef); err == nil {
+			restored, removed, err = workspace.RestoreBackupSnapshot(project.DirectoryPath, backup)
+		}
+	default:
+		err = ErrNoSnapshot
+	}
+
+	execution.EndTime = time.Now()
+	recordChanges(execution, project.DirectoryPath, snapshot)
+	if err != nil {
+		finalizeExecution(execution, models.StatusFailed, fmt.Sprintf("Revert failed: %v", err), "", onComplete)
+		return
+	}
+
+	execution.Status = models.StatusCompleted
+	execution.Summary = fmt.Sprintf("Reverted execution #%d", original.ID)
+	execution.Details = fmt.Sprintf("Restored %d file(s), removed %d file(s).", len(restored), len(removed))
+	database.DB.Save(execution)
+	Log.Info("Revert completed", "execution_id", execution.ID, "reverted_execution_id", original.ID)
+
+	if realtime.Broker != nil {
+		realtime.Broker.Publish(execution.ID, execution.Summary)
+		realtime.Broker.CloseExecution(execution.ID)
+	}
+	if onComplete != nil {
+		onComplete(execution)
+	}
+}
@@ -0,0 +1,212 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/workspace"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// ErrNotReviewable 表示執行記錄不在等待審核 (worktree 執行或需要核准的專案)
+	ErrNotReviewable = errors.New("execution is not awaiting review")
+	// ErrNotApprovable 表示執行未成功完成 (失敗、逾時或被取消)，只能拒絕
+	ErrNotApprovable = errors.New("execution did not complete successfully and can only be rejected")
+)
+
+// reviewLock 序列化核准與拒絕操作，避免同一筆執行被同時合併與刪除
+var reviewLock sync.Mutex
+
+// prepareWorktree 為 worktree 模式的專案建立此次執行專用的 worktree 與分支
+//
+// 參數:
+//   - execution: 執行記錄，會填入 WorktreePath、WorktreeBranch、BaseBranch 並標記為等待審核。
+//   - project: 所屬專案。
+//
+// 返回:
+//   - string: Agent 應執行的目錄 (專案目錄在 worktree 中對應的位置)。
+//   - error: 建立 worktree 失敗時返回錯誤。
+func prepareWorktree(execution *models.Execution, project *models.Project) (string, error) {
+	path := filepath.Join(workspace.WorktreeRoot(), fmt.Sprintf("project-%d", project.ID), fmt.Sprintf("execution-%d", execution.ID))
+	branch := fmt.Sprintf("agent/execution-%d", execution.ID)
+	worktree, err := workspace.CreateWorktree(project.DirectoryPath, path, branch)
+	if err != nil {
+		return "", err
+	}
+
+	execution.WorktreePath = worktree.Path
+	execution.WorktreeBranch = worktree.Branch
+	execution.BaseBranch = worktree.BaseBranch
+	// 一建立就標記為等待審核，執行失敗或伺服器重啟時仍可透過拒絕清理 worktree
+	execution.ReviewStatus = models.ReviewPending
+	database.DB.Save(execution)
+	Log.Info("Created worktree for execution", "execution_id", execution.ID, "path", worktree.Path, "branch", worktree.Branch)
+	return worktree.WorkDir, nil
+}
+
+// discardUnusedWorktree 包裝執行完成的回呼函式，Agent 未曾啟動時先刪除此次執行的 worktree 與分支
+//
+// 參數:
+//   - project: 所屬專案。
+//   - agentStarted: Agent 程序是否已執行 (runExecution 在程序結束後設定)。
+//   - onComplete: 原本的回呼函式 (可選)。
+//
+// 說明:
+//   prepareWorktree 會立即將執行標記為等待審核；Agent 未執行時沒有需要審核的變更，
+//   因此取消等待審核，避免留下無人清理的 worktree 與分支。
+func discardUnusedWorktree(project *models.Project, agentStarted *bool, onComplete CompletionCallback) CompletionCallback {
+	return func(execution *models.Execution) {
+		if !*agentStarted && execution.WorktreeBranch != "" {
+			if err := workspace.RemoveWorktree(project.DirectoryPath, execution.WorktreePath, execution.WorktreeBranch); err != nil {
+				Log.Warn("Failed to remove unused worktree", "execution_id", execution.ID, "error", err)
+			} else {
+				execution.ReviewStatus = ""
+				database.DB.Model(execution).UpdateColumn("review_status", "")
+				Log.Info("Removed unused worktree", "execution_id", execution.ID)
+			}
+		}
+		if onComplete != nil {
+			onComplete(execution)
+		}
+	}
+}
+
+// commitWorktree 將 Agent 在 worktree 中的變更提交到執行分支
+func commitWorktree(execution *models.Execution) {
+	head, err := workspace.CommitWorktree(execution.WorktreePath, fmt.Sprintf("Agent execution #%d\n\n%s", execution.ID, execution.Command))
+	if err != nil {
+		Log.Warn("Failed to commit worktree changes", "execution_id", execution.ID, "error", err)
+		return
+	}
+	execution.GitHeadCommit = head
+}
+
+// requestApproval 將 Agent 已完成的執行標記為等待人工核准
+func requestApproval(execution *models.Execution) {
+	execution.Status = models.StatusAwaitingApproval
+	execution.ReviewStatus = models.ReviewPending
+}
+
+// findReviewable 取得等待審核的執行記錄與其專案
+func findReviewable(executionID uint) (*models.Execution, *models.Project, error) {
+	var execution models.Execution
+	if err := database.DB.First(&execution, executionID).Error; err != nil {
+		return nil, nil, ErrExecutionNotFound
+	}
+	if execution.ReviewStatus != models.ReviewPending || !execution.IsFinished() {
+		return nil, nil, ErrNotReviewable
+	}
+	var project models.Project
+	if err := database.DB.First(&project, execution.ProjectID).Error; err != nil {
+		return nil, nil, ErrProjectNotFound
+	}
+	return &execution, &project, nil
+}
+
+// ApproveExecution 核准等待審核的執行結果
+//
+// 參數:
+//   - executionID: 等待審核的執行記錄 ID。
+//
+// 返回:
+//   - *models.Execution: 已核准的執行記錄。
+//   - error: 執行記錄不存在 (ErrExecutionNotFound)、不在等待審核 (ErrNotReviewable)、
+//     未成功完成 (ErrNotApprovable) 或無法合併 (workspace.ErrMergeFailed) 時返回錯誤。
+//
+// 說明:
+//   worktree 執行會將執行分支合併回主分支 (優先 fast-forward，主分支已前進時建立 merge commit)，
+//   合併成功後刪除 worktree 與執行分支；直接修改專案目錄的執行已套用變更，核准只記錄結果。
+//   awaiting_approval 狀態的執行會轉為 completed。
+func ApproveExecution(executionID uint) (*models.Execution, error) {
+	reviewLock.Lock()
+	defer reviewLock.Unlock()
+
+	execution, project, err := findReviewable(executionID)
+	if err != nil {
+		return nil, err
+	}
+	if !execution.IsApprovable() {
+		return nil, ErrNotApprovable
+	}
+
+	if execution.WorktreeBranch != "" {
+		message := fmt.Sprintf("Merge agent execution #%d", execution.ID)
+		if err := workspace.MergeWorktreeBranch(project.DirectoryPath, execution.BaseBranch, execution.WorktreeBranch, message); err != nil {
+			Log.Warn("Failed to merge execution branch", "execution_id", execution.ID, "error", err)
+			return nil, err
+		}
+		if err := workspace.RemoveWorktree(project.DirectoryPath, execution.WorktreePath, execution.WorktreeBranch); err != nil {
+			Log.Warn("Failed to remove worktree after merge", "execution_id", execution.ID, "error", err)
+		}
+	}
+
+	if execution.Status == models.StatusAwaitingApproval {
+		execution.Status = models.StatusCompleted
+	}
+	execution.ReviewStatus = models.ReviewApproved
+	saveReview(execution, nil)
+	Log.Info("Execution approved", "execution_id", execution.ID)
+	return execution, nil
+}
+
+// RejectExecution 拒絕等待審核的執行結果
+//
+// 參數:
+//   - executionID: 等待審核的執行記錄 ID。
+//   - onRevertComplete: 還原完成後的回呼函式 (可選，僅直接修改專案目錄的執行會排入還原)。
+//
+// 返回:
+//   - *models.Execution: 已拒絕的執行記錄。
+//   - error: 執行記錄不存在 (ErrExecutionNotFound) 或不在等待審核 (ErrNotReviewable) 時返回錯誤。
+//
+// 說明:
+//   worktree 執行會刪除 worktree 與執行分支；直接修改專案目錄的執行則透過 RevertExecution 還原變更。
+//   若變更無法還原 (例如沒有快照)，仍會記錄拒絕並在 ErrorMessage 中說明。
+func RejectExecution(executionID uint, onRevertComplete CompletionCallback) (*models.Execution, error) {
+	reviewLock.Lock()
+	defer reviewLock.Unlock()
+
+	execution, project, err := findReviewable(executionID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if execution.WorktreeBranch != "" {
+		if err := workspace.RemoveWorktree(project.DirectoryPath, execution.WorktreePath, execution.WorktreeBranch); err != nil {
+			return nil, err
+		}
+	} else {
+		// RevertExecution 會自行記錄 reverted_by_id
+		revert, err := RevertExecution(execution.ID, onRevertComplete)
+		if err != nil {
+			Log.Warn("Failed to revert rejected execution", "execution_id", execution.ID, "error", err)
+			execution.ErrorMessage = fmt.Sprintf("Rejected, but changes could not be reverted: %v", err)
+			updates["error_message"] = execution.ErrorMessage
+		} else {
+			execution.RevertedByID = &revert.ID
+		}
+	}
+
+	if execution.Status == models.StatusAwaitingApproval {
+		execution.Status = models.StatusRejected
+	}
+	execution.ReviewStatus = models.ReviewRejected
+	saveReview(execution, updates)
+	Log.Info("Execution rejected", "execution_id", execution.ID)
+	return execution, nil
+}
+
+// saveReview 只寫入審核結果 (status、review_status 與 updates 中的欄位)
+// 不以讀取時的執行記錄覆蓋整列，避免蓋掉審核期間由其他操作 (例如釘選或還原) 寫入的欄位。
+func saveReview(execution *models.Execution, updates map[string]interface{}) {
+	if updates == nil {
+		updates = map[string]interface{}{}
+	}
+	updates["status"] = execution.Status
+	updates["review_status"] = execution.ReviewStatus
+	database.DB.Model(execution).UpdateColumns(updates)
+}
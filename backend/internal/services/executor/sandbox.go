@@ -0,0 +1,100 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/models"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrSandboxUnavailable 表示專案啟用了沙箱，但系統無法提供 (非 Linux 或未安裝 bubblewrap)
+var ErrSandboxUnavailable = errors.New("sandbox is not available")
+
+// maxSandboxViolations 是每次執行最多記錄的沙箱違規數量
+const maxSandboxViolations = 20
+
+// sandboxOptions 描述沙箱中 AI CLI 可存取的範圍
+type sandboxOptions struct {
+	// WorkDir 是 AI CLI 的工作目錄
+	WorkDir string
+	// Writable 是可讀寫的路徑 (專案目錄或 worktree，以及專案額外設定的路徑)，其餘檔案系統皆為唯讀
+	Writable []string
+	// ReadOnly 是額外掛入的唯讀路徑 (例如位於 /tmp 的提示詞暫存檔)
+	ReadOnly []string
+	// DisableNetwork 為 true 時 AI CLI 無法存取網路
+	DisableNetwork bool
+}
+
+// sandboxCommand 依沙箱模式包裝 AI CLI 指令
+//
+// 參數:
+//   - mode: 沙箱模式 (models.SandboxBubblewrap)。
+//   - opts: 沙箱設定。
+//   - exe: AI CLI 執行檔。
+//   - args: AI CLI 參數。
+//
+// 返回:
+//   - string: 實際執行的執行檔。
+//   - []string: 實際執行的參數 (最後接上原本的執行檔與參數)。
+//   - error: 未知的沙箱模式，或系統無法提供沙箱 (ErrSandboxUnavailable) 時返回錯誤。
+//     啟用沙箱卻無法使用時不會退回無沙箱執行。
+func sandboxCommand(mode string, opts sandboxOptions, exe string, args []string) (string, []string, error) {
+	switch mode {
+	case models.SandboxBubblewrap:
+		return bubblewrapCommand(opts, exe, args)
+	default:
+		return "", nil, fmt.Errorf("unknown sandbox mode %q", mode)
+	}
+}
+
+// fileViolationMarkers 是沙箱阻擋寫入時常見的錯誤訊息
+var fileViolationMarkers = []string{
+	"Read-only file system",
+}
+
+// networkViolationMarkers 是沙箱停用網路時常見的錯誤訊息
+var networkViolationMarkers = []string{
+	"Network is unreachable",
+	"Could not resolve host",
+	"Temporary failure in name resolution",
+	"getaddrinfo",
+}
+
+// violationCollector 從 AI CLI 的輸出中收集疑似沙箱違規的行 (可同時由 Stdout 與 Stderr 使用)
+type violationCollector struct {
+	mu         sync.Mutex
+	markers    []string
+	violations []string
+}
+
+// newViolationCollector 依沙箱設定建立 violationCollector
+func newViolationCollector(opts sandboxOptions) *violationCollector {
+	markers := append([]string{}, fileViolationMarkers...)
+	if opts.DisableNetwork {
+		markers = append(markers, networkViolationMarkers...)
+	}
+	return &violationCollector{markers: markers}
+}
+
+// Inspect 檢查一行輸出，符合違規特徵時記錄下來
+func (v *violationCollector) Inspect(line string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.violations) >= maxSandboxViolations {
+		return
+	}
+	for _, marker := range v.markers {
+		if strings.Contains(line, marker) {
+			v.violations = append(v.violations, strings.TrimSpace(line))
+			return
+		}
+	}
+}
+
+// Violations 返回收集到的違規
+func (v *violationCollector) Violations() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.violations
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// bubblewrapCommand 以 bubblewrap 包裝 AI CLI 指令
+//
+// 參數:
+//   - opts: 沙箱設定。
+//   - exe: AI CLI 執行檔。
+//   - args: AI CLI 參數。
+//
+// 返回:
+//   - string: 實際執行的執行檔 (bwrap)。
+//   - []string: bwrap 參數，最後接上原本的執行檔與參數。
+//   - error: 找不到 bwrap 時返回 ErrSandboxUnavailable。
+//
+// 說明:
+//   整個檔案系統以唯讀掛載，只有 opts.Writable 可寫入；/tmp 為沙箱專用的 tmpfs。
+//   AI CLI 在獨立的 PID Namespace 中執行，bwrap 結束 (例如取消或超時) 時所有子程序都會被終止。
+func bubblewrapCommand(opts sandboxOptions, exe string, args []string) (string, []string, error) {
+	bwrap, err := exec.LookPath("bwrap")
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: bubblewrap (bwrap) is not installed", ErrSandboxUnavailable)
+	}
+
+	wrapped := []string{
+		"--die-with-parent",
+		"--unshare-pid",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	}
+	// 掛載順序有意義: 後掛載的路徑會覆蓋前面的唯讀掛載與 /tmp
+	for _, path := range opts.Writable {
+		wrapped = append(wrapped, "--bind", path, path)
+	}
+	for _, path := range opts.ReadOnly {
+		wrapped = append(wrapped, "--ro-bind", path, path)
+	}
+	if opts.DisableNetwork {
+		wrapped = append(wrapped, "--unshare-net")
+	}
+	wrapped = append(wrapped, "--chdir", opts.WorkDir, "--", exe)
+	return bwrap, append(wrapped, args...), nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package executor
+
+import "fmt"
+
+// bubblewrapCommand 在非 Linux 系統上無法使用 (沙箱依賴 Linux Namespace)
+func bubblewrapCommand(opts sandboxOptions, exe string, args []string) (string, []string, error) {
+	return "", nil, fmt.Errorf("%w: sandboxing requires Linux", ErrSandboxUnavailable)
+}
@@ -5,14 +5,12 @@ import (
 	"agent-workspace-manager/internal/models"
 	"agent-workspace-manager/internal/services/realtime"
 	"agent-workspace-manager/internal/utils"
-	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"log/slog"
-	"os/exec"
-	"strings"
-	"sync"
+	"os"
+	"strconv"
 	"time"
 )
 
@@ -21,12 +19,7 @@ import (
 //   - execution: 指向已完成的 Execution 模型的指標
 type CompletionCallback func(*models.Execution)
 
-// executionLocks 用於控制每個專案的並行執行
-// 鍵為專案 ID，值為該專案的互斥鎖
-var executionLocks = make(map[uint]*sync.Mutex)
-var mapLock sync.Mutex
-
-// DefaultTimeout 預設執行超時時間 (30分鐘)
+// DefaultTimeout 預設執行超時時間 (30分鐘，AgentProfile 與專案可覆寫)
 const DefaultTimeout = 30 * time.Minute
 
 // Log 是 Executor 服務專用的 Logger
@@ -37,27 +30,6 @@ func SetLogger(logger *slog.Logger) {
 	Log = logger
 }
 
-// getProjectLock 取得專案的執行鎖
-//
-// 參數:
-//   - projectID: 專案 ID
-//
-// 返回:
-//   - *sync.Mutex: 該專案的互斥鎖
-//
-// 說明:
-//   使用 mapLock 確保並發安全地存取 executionLocks map。
-//   如果該專案的鎖不存在，則創建一個新的。
-func getProjectLock(projectID uint) *sync.Mutex {
-	mapLock.Lock()
-	defer mapLock.Unlock()
-
-	if _, exists := executionLocks[projectID]; !exists {
-		executionLocks[projectID] = &sync.Mutex{}
-	}
-	return executionLocks[projectID]
-}
-
 // streamWriter 是一個自定義 Writer，用於將輸出同時寫入 Broker 和底層 Writer
 type streamWriter struct {
 	executionID uint
@@ -78,193 +50,308 @@ func (sw *streamWriter) Write(p []byte) (n int, err error) {
 	return sw.writer.Write(p)
 }
 
-// ExecuteCommand 執行 AI Agent 指令的核心邏輯
+// runExecution 執行 AI Agent 指令的核心邏輯
 //
 // 參數:
-//   - projectID: 目標專案 ID。
-//   - userCommand: 使用者輸入的指令或提示詞。
+//   - ctx: 由佇列 Worker 建立的 Context，呼叫 CancelExecution 時會被取消。
+//   - execution: 由佇列取出的執行記錄 (Queued 狀態)。
 //   - onComplete: 執行完成後的回呼函式 (可選)。
 //
 // 流程:
-//  1. 並行控制: 嘗試取得專案鎖，若失敗則返回 "Project is busy"。
-//  2. 準備資料: 獲取專案資訊、建立執行記錄 (Running 狀態)、獲取歷史記錄。
-//  3. 建構指令: 組合 Prompt、解析 CLI 模版、替換參數。
-//  4. 執行環境: 設定 Context (Timeout)、工作目錄。
-//  5. 執行程序: 啟動外部指令，並透過 Pipe 即時讀取 Stdout/Stderr。
-//  6. 串流輸出: 將輸出即時推送到 Realtime Broker，同時收集完整日誌。
-//  7. 結果處理: 等待指令結束，解析輸出 (JSON)，更新執行記錄狀態 (Completed/Failed)。
-//  8. 收尾: 釋放鎖，呼叫 onComplete。
-func ExecuteCommand(projectID uint, userCommand string, onComplete CompletionCallback) {
-	// 確保 Logger 已初始化
-	if Log == nil {
-		Log = slog.Default()
-	}
-
-	// 0. 並行控制：嘗試取得鎖
-	lock := getProjectLock(projectID)
-	if !lock.TryLock() {
-		Log.Warn("Project is busy", "project_id", projectID)
-		execution := models.Execution{
-			ProjectID:    projectID,
-			Command:      userCommand,
-			Status:       models.StatusFailed,
-			ErrorMessage: "Project is busy (concurrency limit)",
-			StartTime:    time.Now(),
-			EndTime:      time.Now(),
-		}
-		database.DB.Create(&execution)
-		if onComplete != nil {
-			onComplete(&execution)
-		}
-		return
-	}
-	defer lock.Unlock()
+//  1. 準備資料: 獲取專案資訊、將執行記錄轉為 Running 狀態、獲取歷史記錄。
+//  2. 建構指令: 取得 AI CLI 設定 (AgentProfile 或專案)、解析 CLI 模版、決定是否續接 AI CLI 的工作階段、以提示詞模版組合 Prompt、替換佔位符。
+//  3. 執行環境: 設定沙箱 (可選)、Context (Timeout)、工作目錄 (worktree 模式下為專用的 worktree)、獨立的 Process Group。
+//  4. 執行程序: 擷取執行前的專案快照，啟動外部指令，並透過 Pipe 即時讀取 Stdout/Stderr。
+//  5. 串流輸出: 將輸出即時推送到 Realtime Broker，同時收集完整日誌。
+//  6. 結果處理: 等待指令結束，比對 Git 變更 (worktree 模式下提交到執行分支)，以專案選擇的解析器解析輸出並以 JSON Schema 驗證
+//     (專案啟用 RepairOutput 時，失敗會再執行一次修復回合)，更新執行記錄狀態 (Completed/AwaitingApproval/Failed/TimedOut/ResourceExceeded/Cancelled)。
+//  7. 收尾: 呼叫 onComplete。
+//
+// 說明:
+//   並行控制由佇列 Worker 負責，同一專案同一時間只會有一個 runExecution 在執行。
+func runExecution(ctx context.Context, execution *models.Execution, onComplete CompletionCallback) {
+	projectID := execution.ProjectID
 
 	// 1. 取得專案資訊
 	var project models.Project
 	if err := database.DB.First(&project, projectID).Error; err != nil {
 		Log.Error("Project not found", "project_id", projectID)
+		finalizeExecution(execution, models.StatusFailed, "Project not found", "", onComplete)
 		return
 	}
 
-	// 2. 建立執行記錄
-	execution := models.Execution{
-		ProjectID: projectID,
-		Command:   userCommand,
-		Status:    models.StatusRunning,
-		StartTime: time.Now(),
-	}
-	database.DB.Create(&execution)
-
-	// 2.5 取得最近 5 筆執行記錄 (作為 Context)
-	var history []models.Execution
-	database.DB.Where("project_id = ? AND id != ? AND status = ?", projectID, execution.ID, models.StatusCompleted).
-		Order("created_at desc").
-		Limit(5).
-		Find(&history)
-
-	// 3. 建構完整指令內容
-	promptContent := utils.BuildPrompt(userCommand, history, project)
-	
-	// 解析 CLI 指令模版
-	templateParts := strings.Fields(project.AICliCommand)
-	if len(templateParts) == 0 {
-		execution.Status = models.StatusFailed
-		execution.ErrorMessage = "Empty AI CLI command configuration"
-		execution.EndTime = time.Now()
-		database.DB.Save(&execution)
-		if onComplete != nil {
-			onComplete(&execution)
-		}
+	// 2. 更新執行記錄為執行中
+	execution.Status = models.StatusRunning
+	execution.StartTime = time.Now()
+	database.DB.Save(execution)
+
+	// 取得 AI CLI 設定 (AgentProfile 或專案本身)
+	agent, err := resolveAgentConfig(&project)
+	if err != nil {
+		finalizeExecution(execution, models.StatusFailed, err.Error(), "", onComplete)
 		return
 	}
-	// 取得指令執行檔
-	exe := templateParts[0]
-	var args []string
-	// 處理參數
-	for _, part := range templateParts[1:] {
-		args = append(args, part)
-	}
-	// 加入提示詞作為最後一個參數
-	args = append(args, promptContent)
-
-	// 4. 準備執行 Context (Timeout)
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
-	defer cancel()
-	Log.Debug("Command", "exe", exe, "args", args)
-	cmd := exec.CommandContext(ctx, exe, args...)
-	cmd.Dir = project.DirectoryPath
 
-	// 設定輸出串流
-	// 使用 Pipe 讀取 Stdout/Stderr，因為我們需要即時串流，而不僅僅是最後收集
-	stdoutPipe, err := cmd.StdoutPipe()
+	// 解析 CLI 指令模版 (建立或更新時已驗證，這裡仍需處理舊資料)
+	template, err := utils.ParseCommandTemplate(agent.CommandTemplate)
+	if err == nil {
+		err = template.ValidatePromptDelivery(agent.PromptDelivery)
+	}
 	if err != nil {
-		finalizeExecution(&execution, models.StatusFailed, fmt.Sprintf("Failed to create stdout pipe: %v", err), "", onComplete)
+		finalizeExecution(execution, models.StatusFailed, fmt.Sprintf("Invalid AI CLI command template: %v", err), "", onComplete)
 		return
 	}
-	stderrPipe, err := cmd.StderrPipe()
+
+	// 取得輸出解析器 (專案或 AgentProfile 設定，預設為 JSON 結果)
+	parser, err := utils.GetOutputParser(agent.OutputParser)
 	if err != nil {
-		finalizeExecution(&execution, models.StatusFailed, fmt.Sprintf("Failed to create stderr pipe: %v", err), "", onComplete)
+		finalizeExecution(execution, models.StatusFailed, err.Error(), "", onComplete)
 		return
 	}
+	execution.OutputParser = parser.Name()
+
+	// 對話工作階段的後續執行：AI CLI 支援續接且上一輪回報了工作階段 ID 時，以 CLI 續接取代提示詞中的先前對話
+	var resumeArgs []string
+	if agent.ResumeArgs != "" {
+		resume, err := utils.ParseResumeTemplate(agent.ResumeArgs)
+		if err != nil {
+			finalizeExecution(execution, models.StatusFailed, fmt.Sprintf("Invalid resume arguments: %v", err), "", onComplete)
+			return
+		}
+		if sessionID := previousCLISessionID(execution); sessionID != "" {
+			execution.ResumedCLISessionID = sessionID
+			resumeArgs = resume.Render(sessionID)
+		}
+	}
 
-	// 啟動 Goroutine 讀取輸出並廣播
-	var outputBuilder strings.Builder
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// 定義讀取並廣播的輔助函式
-	readAndBroadcast := func(r io.Reader) {
-		defer wg.Done()
-		scanner := bufio.NewScanner(r)
-		for scanner.Scan() {
-			text := scanner.Text()
-			// 廣播到前端
-			if realtime.Broker != nil {
-				realtime.Broker.Publish(execution.ID, text)
-			}
-			// 收集到 Buffer
-			outputBuilder.WriteString(text + "\n")
+	// 3. 以專案的提示詞模版建構完整指令內容 (包含最近的執行記錄作為 Context)
+	prompt, err := BuildExecutionPrompt(&project, execution, "")
+	if err != nil {
+		finalizeExecution(execution, models.StatusFailed, err.Error(), "", onComplete)
+		return
+	}
+	promptContent := prompt.Prompt
+	execution.HistoryIDs = prompt.HistoryIDs
+
+	// worktree 模式: 在此次執行專用的 worktree 與分支中執行，不動到主工作目錄
+	workDir := project.DirectoryPath
+	agentStarted := false
+	if project.UseWorktree {
+		dir, err := prepareWorktree(execution, &project)
+		if err != nil {
+			finalizeExecution(execution, models.StatusFailed, fmt.Sprintf("Failed to create worktree: %v", err), "", onComplete)
+			return
 		}
+		workDir = dir
+		// Agent 啟動前就失敗 (例如建構指令、擷取快照或啟動程序失敗) 時 worktree 中沒有可審核的變更，
+		// 在呼叫 onComplete 前刪除 worktree 與分支，不進入等待審核
+		onComplete = discardUnusedWorktree(&project, &agentStarted, onComplete)
+	}
+
+	// 替換模版佔位符並依傳遞方式提供提示詞
+	delivery := agent.PromptDelivery
+	if delivery == "" {
+		delivery = models.PromptDeliveryArgv
+	}
+	spec := &commandSpec{
+		agent:    agent,
+		template: template,
+		delivery: delivery,
+		workDir:  workDir,
+		values: map[string]string{
+			utils.PlaceholderProjectDir:  workDir,
+			utils.PlaceholderExecutionID: strconv.FormatUint(uint64(execution.ID), 10),
+			utils.PlaceholderModel:       project.ModelName,
+		},
+		resumeArgs: resumeArgs,
+		limits:     resolveLimits(&project, agent),
+		redact:     newRedactor(agent.EnvVars),
 	}
 
-	go readAndBroadcast(stdoutPipe)
-	go readAndBroadcast(stderrPipe)
+	// 啟用沙箱時以沙箱指令包裝 AI CLI，只有專案目錄 (worktree 模式下為 worktree) 可寫入
+	if project.SandboxMode != models.SandboxNone {
+		writableRoot := project.DirectoryPath
+		if execution.WorktreePath != "" {
+			writableRoot = execution.WorktreePath
+		}
+		spec.sandboxMode = project.SandboxMode
+		spec.sandbox = sandboxOptions{
+			WorkDir:        workDir,
+			Writable:       append([]string{writableRoot}, project.SandboxWritablePaths...),
+			DisableNetwork: project.SandboxDisableNetwork,
+		}
+		spec.violations = newViolationCollector(spec.sandbox)
+	}
 
-	// 5. 啟動指令
-	Log.Info("Starting execution", "execution_id", execution.ID, "project_id", projectID, "command", exe)
-	if err := cmd.Start(); err != nil {
-		finalizeExecution(&execution, models.StatusFailed, fmt.Sprintf("Failed to start command: %v", err), "", onComplete)
+	// 套用沙箱與記憶體、CPU 時間限制
+	execution.DebugInfo = &models.ExecutionDebugInfo{AgentProfile: agent.ProfileName, PromptTemplate: prompt.Template, PromptDelivery: delivery, PromptBytes: len(promptContent)}
+	command, err := spec.build(promptContent)
+	if err != nil {
+		finalizeExecution(execution, models.StatusFailed, err.Error(), "", onComplete)
 		return
 	}
+	defer command.cleanup()
+	execution.DebugInfo.Command = command.debugCommand
+	execution.DebugInfo.PromptFile = command.promptFile
+	if spec.sandboxMode != models.SandboxNone {
+		execution.DebugInfo.Sandbox = spec.sandboxMode
+		execution.DebugInfo.SandboxCommand = command.sandboxCommand
+	}
+	execution.DebugInfo.Limits = spec.limits.debugInfo()
 
-	// 等待指令完成
-	err = cmd.Wait()
-	wg.Wait() // 確保所有輸出都已讀取完畢
+	// 記錄執行前的專案狀態 (Git 快照或備份)，供比對變更與還原
+	snapshot := captureSnapshot(execution, workDir, ignoreList(&project))
 
-	// 關閉 Broker (通知前端串流結束)
-	if realtime.Broker != nil {
-		realtime.Broker.CloseExecution(execution.ID)
+	// 4~5. 啟動指令並串流輸出 (Timeout 依資源限制)
+	runCtx, cancel := context.WithTimeout(ctx, spec.limits.Timeout)
+	defer cancel()
+	Log.Info("Starting execution", "execution_id", execution.ID, "project_id", projectID, "command", command.exe)
+	result, err := spec.run(runCtx, execution.ID, command)
+	agentStarted = err == nil
+	if err != nil {
+		if context.Cause(runCtx) == ErrCancelled {
+			finalizeExecution(execution, models.StatusCancelled, "Cancelled by user", "", onComplete)
+			return
+		}
+		finalizeExecution(execution, models.StatusFailed, err.Error(), "", onComplete)
+		return
 	}
 
-	fullOutput := outputBuilder.String()
+	fullOutput := result.output
 	execution.Details = fullOutput
 	execution.EndTime = time.Now()
 
+	// 無論結果如何都記錄實際的檔案變更 (取消或失敗的執行也可能已修改檔案)
+	recordExecutionChanges(execution, spec, snapshot)
+
+	// 檢查是否被使用者取消 (保留已產生的部分輸出)
+	if context.Cause(runCtx) == ErrCancelled {
+		finalizeExecution(execution, models.StatusCancelled, "Cancelled by user", fullOutput, onComplete)
+		return
+	}
+
 	// 檢查 Timeout
-	if ctx.Err() == context.DeadlineExceeded {
-		finalizeExecution(&execution, models.StatusFailed, "Execution timed out", fullOutput, onComplete)
+	if runCtx.Err() == context.DeadlineExceeded {
+		finalizeExecution(execution, models.StatusTimedOut, fmt.Sprintf("Execution timed out after %s", spec.limits.Timeout), fullOutput, onComplete)
 		return
 	}
 
-	if err != nil {
-		finalizeExecution(&execution, models.StatusFailed, err.Error(), fullOutput, onComplete)
+	if result.err != nil {
+		if reason := spec.limits.exceeded(result.state); reason != "" {
+			finalizeExecution(execution, models.StatusResourceExceeded, reason, fullOutput, onComplete)
+			return
+		}
+		finalizeExecution(execution, models.StatusFailed, result.err.Error(), fullOutput, onComplete)
 		return
 	}
 	Log.Debug("Command output", "execution_id", execution.ID, "output", fullOutput)
-	
-	// 6. 解析輸出 (嘗試從輸出中提取 JSON 結果)
-	parsedOutput, err := utils.ParseOutput(fullOutput)
+
+	// 6. 解析輸出並以 JSON Schema 驗證 (依專案選擇的解析器提取結果)
+	parsedOutput, err := parser.Parse(fullOutput)
+	claimed := parsedOutput
+	if err == nil {
+		err = utils.ValidateOutput(parsedOutput)
+	}
+	// 專案啟用輸出修復時，要求同一個 Agent 只重新輸出修正後的 JSON
+	if err != nil && project.RepairOutput {
+		parsedOutput, err = repairOutput(ctx, execution, spec, parser, fullOutput, err)
+		// 修復回合的 Agent 也可能修改檔案，重新比對執行前後的狀態
+		recordExecutionChanges(execution, spec, snapshot)
+		if context.Cause(ctx) == ErrCancelled {
+			finalizeExecution(execution, models.StatusCancelled, "Cancelled by user", fullOutput, onComplete)
+			return
+		}
+		if parsedOutput != nil {
+			claimed = parsedOutput
+		}
+	}
+
+	// 關閉 Broker (通知前端串流結束)
+	if realtime.Broker != nil {
+		realtime.Broker.CloseExecution(execution.ID)
+	}
+
 	if err != nil {
 		// 即使解析失敗，也視為完成，但在狀態上標記為 ParseFailed
 		execution.Status = models.StatusParseFailed
 		execution.ErrorMessage = fmt.Sprintf("Output parsing failed: %v", err)
 	} else {
-		execution.Status = models.StatusCompleted
 		execution.Summary = parsedOutput.Summary
-		execution.ModifiedFiles = parsedOutput.ModifiedFiles
-		execution.CreatedFiles = parsedOutput.CreatedFiles
-		execution.DeletedFiles = parsedOutput.DeletedFiles
+		execution.MemoryUpdates = parsedOutput.MemoryUpdates
+		execution.CLISessionID = parsedOutput.SessionID
+		if parsedOutput.Failed() {
+			// Agent 回報任務失敗 (例如 JSON Lines 的 is_error 結果事件)，不進入核准也不更新專案記憶
+			execution.Status = models.StatusFailed
+			execution.ErrorMessage = "Agent reported failure"
+			if parsedOutput.Summary != "" {
+				execution.ErrorMessage += ": " + parsedOutput.Summary
+			}
+		} else {
+			execution.Status = models.StatusCompleted
+			if project.RequireApproval {
+				requestApproval(execution)
+			}
+			applyMemoryUpdates(&project, execution)
+		}
+		// Git 比對結果優先於 Agent 自行回報的檔案列表；
+		// 非 Git 專案的檔案比對結果只在 Agent 沒有回報任何檔案時使用
+		if execution.ChangeSource != models.ChangeSourceGit && !(execution.ChangeSource == models.ChangeSourceFilesystem && !parsedOutput.HasFileChanges()) {
+			execution.ModifiedFiles = parsedOutput.ModifiedFiles
+			execution.CreatedFiles = parsedOutput.CreatedFiles
+			execution.DeletedFiles = parsedOutput.DeletedFiles
+			execution.ChangeSource = models.ChangeSourceAgent
+		}
 	}
+	// Agent 回報的路徑即使不符合格式 (例如絕對路徑) 也要檢查
+	verifyPathSafety(execution, workDir, claimed)
 
-	database.DB.Save(&execution)
+	database.DB.Save(execution)
 	Log.Info("Execution completed", "execution_id", execution.ID, "status", execution.Status)
 
 	if onComplete != nil {
-		onComplete(&execution)
+		onComplete(execution)
+	}
+}
+
+// recordExecutionChanges 記錄執行前後的檔案變更並檢查路徑
+//
+// 說明:
+//   同時更新沙箱違規、遮蔽 Diff 中的機密值；worktree 模式下會將變更提交到執行分支。
+//   輸出修復回合結束後會再次呼叫，涵蓋兩個回合的所有變更。
+func recordExecutionChanges(execution *models.Execution, spec *commandSpec, snapshot *snapshotState) {
+	if spec.violations != nil {
+		execution.SandboxViolations = spec.violations.Violations()
+		if len(execution.SandboxViolations) > 0 {
+			Log.Warn("Sandbox violations detected", "execution_id", execution.ID, "count", len(execution.SandboxViolations))
+		}
+	}
+
+	recordChanges(execution, spec.workDir, snapshot)
+	execution.Diff = spec.redact.Redact(execution.Diff)
+	verifyPathSafety(execution, spec.workDir, nil)
+	if execution.WorktreePath != "" {
+		commitWorktree(execution)
 	}
 }
 
+// writePromptFile 將提示詞寫入暫存檔，返回檔案路徑 (呼叫端負責刪除)
+func writePromptFile(prompt string) (string, error) {
+	f, err := os.CreateTemp("", "awm-prompt-*.txt")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(prompt); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
 // finalizeExecution 輔助函式：統一處理執行失敗或異常結束的狀態更新
 //
 // 參數:
@@ -281,14 +368,22 @@ func finalizeExecution(execution *models.Execution, status, errorMsg, details st
 	}
 	execution.EndTime = time.Now()
 	database.DB.Save(execution)
-	
-	Log.Error("Execution failed", "execution_id", execution.ID, "error", errorMsg)
 
 	if realtime.Broker != nil {
-		realtime.Broker.Publish(execution.ID, fmt.Sprintf("Error: %s", errorMsg))
+		if status == models.StatusCancelled {
+			realtime.Broker.Publish(execution.ID, "Execution cancelled")
+		} else {
+			realtime.Broker.Publish(execution.ID, fmt.Sprintf("Error: %s", errorMsg))
+		}
 		realtime.Broker.CloseExecution(execution.ID)
 	}
 
+	if status == models.StatusCancelled {
+		Log.Info("Execution cancelled", "execution_id", execution.ID)
+	} else {
+		Log.Error("Execution failed", "execution_id", execution.ID, "error", errorMsg)
+	}
+
 	if onComplete != nil {
 		onComplete(execution)
 	}
@@ -0,0 +1,170 @@
+package executor
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// DefaultSessionIdleTimeout 是未設定時工作階段的閒置過期時間
+const DefaultSessionIdleTimeout = time.Hour
+
+// sessionIdleTimeout 是工作階段的閒置過期時間
+var sessionIdleTimeout = DefaultSessionIdleTimeout
+
+// SessionMaxTurns 是提示詞中包含的先前對話輪數上限 (保留最新的)
+const SessionMaxTurns = 10
+
+// SessionDetailsTailChars 是提示詞中每輪對話保留的輸出結尾長度 (字元數)
+const SessionDetailsTailChars = 2000
+
+var (
+	// ErrSessionExpired 表示工作階段已超過閒置時間，無法再延續
+	ErrSessionExpired = errors.New("session expired")
+	// ErrSessionNotFound 表示工作階段不存在
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SetSessionIdleTimeout 設定工作階段的閒置過期時間 (小於等於 0 時使用預設值)
+func SetSessionIdleTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultSessionIdleTimeout
+	}
+	sessionIdleTimeout = timeout
+}
+
+// ContinueSession 延續執行記錄所屬的工作階段，將指令作為下一輪加入專案的執行佇列
+//
+// 參數:
+//   - executionID: 要延續的執行記錄 ID (例如使用者在 Telegram 回覆的執行結果)。
+//   - chatID: 發出指令的 Telegram 聊天室 ID (建立新工作階段時記錄)。
+//   - command: 下一輪的指令。
+//   - onComplete: 執行完成後的回呼函式 (可選)。
+//
+// 返回:
+//   - *models.Execution: 已加入佇列的執行記錄。
+//   - error: 執行記錄不存在 (ErrExecutionNotFound)、工作階段已過期 (ErrSessionExpired) 或加入佇列失敗時返回錯誤。
+//
+// 說明:
+//   執行記錄尚未屬於任何工作階段時，以它作為第一輪建立新的工作階段。
+func ContinueSession(executionID uint, chatID int64, command string, onComplete CompletionCallback) (*models.Execution, error) {
+	var previous models.Execution
+	if err := database.DB.First(&previous, executionID).Error; err != nil {
+		return nil, ErrExecutionNotFound
+	}
+
+	var session models.Session
+	if previous.SessionID != nil {
+		if err := database.DB.First(&session, *previous.SessionID).Error; err != nil {
+			return nil, ErrSessionNotFound
+		}
+		fillSessionExpiry(&session)
+		if session.Expired {
+			return nil, ErrSessionExpired
+		}
+	} else {
+		session = models.Session{ProjectID: previous.ProjectID, ChatID: chatID, LastActivityAt: time.Now()}
+		// 以執行結束時間判斷是否已閒置太久，避免延續很久以前的執行
+		if !previous.EndTime.IsZero() && time.Since(previous.EndTime) > sessionIdleTimeout {
+			return nil, ErrSessionExpired
+		}
+		if err := database.DB.Create(&session).Error; err != nil {
+			return nil, err
+		}
+		database.DB.Model(&previous).UpdateColumn("session_id", session.ID)
+	}
+
+	database.DB.Model(&session).UpdateColumn("last_activity_at", time.Now())
+	execution := &models.Execution{
+		ProjectID: previous.ProjectID,
+		SessionID: &session.ID,
+		Command:   command,
+	}
+	if err := Enqueue(execution, onComplete); err != nil {
+		return nil, err
+	}
+	return execution, nil
+}
+
+// GetSession 取得工作階段與其執行記錄 (由舊到新)，並計算過期狀態
+func GetSession(sessionID uint) (*models.Session, error) {
+	var session models.Session
+	err := database.DB.Preload("Executions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id asc")
+	}).First(&session, sessionID).Error
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	fillSessionExpiry(&session)
+	return &session, nil
+}
+
+// fillSessionExpiry 計算工作階段的 ExpiresAt 與 Expired
+// 最後活動時間取 LastActivityAt 與工作階段中最後一次執行結束時間較晚者。
+func fillSessionExpiry(session *models.Session) {
+	lastActivity := session.LastActivityAt
+	var latest models.Execution
+	if err := database.DB.Where("session_id = ?", session.ID).Order("end_time desc").First(&latest).Error; err == nil && latest.EndTime.After(lastActivity) {
+		lastActivity = latest.EndTime
+	}
+	// 仍有排隊或執行中的對話時不會過期
+	if hasUnfinishedTurn(session.ID) {
+		lastActivity = time.Now()
+	}
+	session.ExpiresAt = lastActivity.Add(sessionIdleTimeout)
+	session.Expired = time.Now().After(session.ExpiresAt)
+}
+
+// hasUnfinishedTurn 判斷工作階段中是否有排隊或執行中的執行記錄
+func hasUnfinishedTurn(sessionID uint) bool {
+	var count int64
+	database.DB.Model(&models.Execution{}).
+		Where("session_id = ? AND status IN ?", sessionID, []string{models.StatusQueued, models.StatusRunning}).
+		Count(&count)
+	return count > 0
+}
+
+// previousCLISessionID 返回工作階段中上一輪 (此次執行之前最後一個已結束的執行) 回報的 AI CLI 工作階段 ID
+// 不屬於工作階段、沒有上一輪或上一輪沒有回報 ID 時返回空字串。
+func previousCLISessionID(execution *models.Execution) string {
+	if execution.SessionID == nil {
+		return ""
+	}
+	var previous models.Execution
+	err := database.DB.Where("session_id = ? AND id < ? AND status NOT IN ?", *execution.SessionID, execution.ID, []string{models.StatusQueued, models.StatusRunning}).
+		Order("id desc").
+		First(&previous).Error
+	if err != nil {
+		return ""
+	}
+	return previous.CLISessionID
+}
+
+// sessionTurns 取得工作階段中此次執行之前的對話 (由舊到新，最多 SessionMaxTurns 輪)
+// 每輪的 Details 只保留結尾 SessionDetailsTailChars 個字元；續接 AI CLI 工作階段時 CLI 已有先前的對話，返回 nil。
+func sessionTurns(execution *models.Execution) []models.Execution {
+	if execution.SessionID == nil || execution.ResumedCLISessionID != "" {
+		return nil
+	}
+	var turns []models.Execution
+	query := database.DB.Where("session_id = ? AND status NOT IN ?", *execution.SessionID, []string{models.StatusQueued, models.StatusRunning})
+	if execution.ID != 0 {
+		query = query.Where("id < ?", execution.ID)
+	}
+	query.Order("id desc").Limit(SessionMaxTurns).Find(&turns)
+
+	for i, j := 0, len(turns)-1; i < j; i, j = i+1, j-1 {
+		turns[i], turns[j] = turns[j], turns[i]
+	}
+	for i := range turns {
+		if utf8.RuneCountInString(turns[i].Details) > SessionDetailsTailChars {
+			runes := []rune(turns[i].Details)
+			turns[i].Details = "..." + string(runes[len(runes)-SessionDetailsTailChars:])
+		}
+	}
+	return turns
+}
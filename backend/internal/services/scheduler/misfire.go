@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/telegram"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// recoverSchedules 重新註冊伺服器重啟前等待中的排程，並依 Misfire 策略處理錯過的執行
+//
+// 參數:
+//   - schedules: 狀態為 Pending 的排程。
+//   - now: 評估是否錯過的基準時間。
+//
+// 邏輯:
+//  1. 預定時間未到的排程直接重新排程。
+//  2. 錯過且策略允許補執行的排程: 一次性排程由 ScheduleJob 立即執行；
+//     週期排程重新註冊後補觸發一次 (無論錯過幾次都只補一次)。
+//  3. 錯過且策略不允許補執行的排程: 一次性排程標記為 Missed；
+//     週期排程記錄本次結果為 Missed 並從下一次執行時間繼續。
+//  4. 若有補執行或略過的排程，透過 Telegram 發送摘要。
+func recoverSchedules(schedules []models.Schedule, now time.Time) {
+	var caughtUp, skipped []models.Schedule
+
+	for _, s := range schedules {
+		if !s.ScheduledTime.Before(now) {
+			ScheduleJob(s)
+			continue
+		}
+
+		if s.ShouldRunMisfired(now) {
+			log.Printf("Schedule %d missed its run at %v, catching up", s.ID, s.ScheduledTime)
+			caughtUp = append(caughtUp, s)
+			ScheduleJob(s)
+			if s.IsRecurring() {
+				runRecurringJob(s.ID)
+			}
+			continue
+		}
+
+		log.Printf("Schedule %d missed its run at %v, skipping (policy: %s)", s.ID, s.ScheduledTime, s.MisfirePolicy)
+		skipped = append(skipped, s)
+		markScheduleMissed(s)
+		if s.IsRecurring() {
+			ScheduleJob(s)
+		}
+	}
+
+	if len(caughtUp) > 0 || len(skipped) > 0 {
+		telegram.SendNotification(formatMisfireSummary(caughtUp, skipped))
+	}
+}
+
+// markScheduleMissed 將錯過且不補執行的排程記錄為 Missed
+func markScheduleMissed(s models.Schedule) {
+	updates := map[string]interface{}{"last_run_status": models.ScheduleMissed}
+	if !s.IsRecurring() {
+		updates["status"] = models.ScheduleMissed
+	}
+	database.DB.Model(&models.Schedule{}).Where("id = ?", s.ID).Updates(updates)
+}
+
+// formatMisfireSummary 組合重啟後補執行與略過之排程的 Telegram 摘要
+func formatMisfireSummary(caughtUp, skipped []models.Schedule) string {
+	projectNames := make(map[uint]string)
+	describe := func(s models.Schedule) string {
+		name, ok := projectNames[s.ProjectID]
+		if !ok {
+			var project models.Project
+			if err := database.DB.First(&project, s.ProjectID).Error; err == nil {
+				name = project.Name
+			} else {
+				name = fmt.Sprintf("#%d", s.ProjectID)
+			}
+			projectNames[s.ProjectID] = name
+		}
+		return fmt.Sprintf("- [%s] %s (due %s)\n", name, s.Command, s.ScheduledTime.Format(time.RFC3339))
+	}
+
+	var b strings.Builder
+	b.WriteString("⏰ Missed schedules after restart\n")
+	if len(caughtUp) > 0 {
+		b.WriteString(fmt.Sprintf("\nCaught up (%d):\n", len(caughtUp)))
+		for _, s := range caughtUp {
+			b.WriteString(describe(s))
+		}
+	}
+	if len(skipped) > 0 {
+		b.WriteString(fmt.Sprintf("\nSkipped (%d):\n", len(skipped)))
+		for _, s := range skipped {
+			b.WriteString(describe(s))
+		}
+	}
+	return b.String()
+}
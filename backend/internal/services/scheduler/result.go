@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"log"
+	"time"
+)
+
+// DefaultRetryDelay 是排程未設定 RetryDelaySeconds 時第一次重試前的等待時間
+const DefaultRetryDelay = time.Minute
+
+// HandleExecutionResult 依排程所觸發之執行記錄的最終狀態更新排程
+//
+// 參數:
+//   - execution: 已結束的執行記錄；非排程觸發的記錄 (ScheduleID 為空) 會被忽略。
+//
+// 返回:
+//   - time.Duration: 若已安排自動重試，返回距離重試的時間；否則返回 0。
+//
+// 邏輯:
+//  1. 執行成功: 一次性排程標記為 Completed；週期排程記錄本次結果並重設重試次數。
+//  2. 執行失敗且仍有重試額度 (使用者取消除外): 依指數退避安排重試。
+//  3. 其餘情況: 一次性排程標記為 Failed；週期排程記錄本次結果，等待下一次觸發。
+func HandleExecutionResult(execution *models.Execution) time.Duration {
+	if execution.ScheduleID == nil || !execution.IsFinished() {
+		return 0
+	}
+
+	var s models.Schedule
+	if err := database.DB.First(&s, *execution.ScheduleID).Error; err != nil {
+		return 0
+	}
+
+	executionID := execution.ID
+	s.ExecutionID = &executionID
+
+	// 等待核准代表 Agent 已成功完成，核准與否不影響排程結果
+	if execution.Status == models.StatusCompleted || execution.Status == models.StatusAwaitingApproval {
+		s.LastRunStatus = models.ScheduleCompleted
+		if !s.IsRecurring() {
+			s.Status = models.ScheduleCompleted
+		}
+		s.RetryCount = 0
+		database.DB.Save(&s)
+		return 0
+	}
+
+	s.LastRunStatus = models.ScheduleFailed
+	if execution.Status != models.StatusCancelled && s.RetryCount < s.MaxRetries {
+		s.RetryCount++
+		delay := s.RetryDelay(s.RetryCount, DefaultRetryDelay)
+		scheduleRetry(&s, delay)
+		log.Printf("Scheduled job %d failed, retry %d/%d in %v", s.ID, s.RetryCount, s.MaxRetries, delay)
+		return delay
+	}
+
+	if !s.IsRecurring() {
+		s.Status = models.ScheduleFailed
+	}
+	database.DB.Save(&s)
+	return 0
+}
+
+// scheduleRetry 安排失敗排程的重試
+//
+// 說明:
+//   一次性排程會以新的 ScheduledTime 回到 Pending 狀態並寫入資料庫，伺服器重啟後仍會重試；
+//   週期排程則以記憶體中的定時器重新觸發本次執行，若期間被暫停、刪除或重新排程則取消重試。
+func scheduleRetry(s *models.Schedule, delay time.Duration) {
+	if !s.IsRecurring() {
+		s.Status = models.SchedulePending
+		s.ScheduledTime = time.Now().Add(delay)
+		database.DB.Save(s)
+		ScheduleJob(*s)
+		return
+	}
+
+	database.DB.Save(s)
+	scheduleID := s.ID
+	jobsLock.Lock()
+	if timer, exists := timers[scheduleID]; exists {
+		timer.Stop()
+	}
+	timers[scheduleID] = time.AfterFunc(delay, func() {
+		jobsLock.Lock()
+		delete(timers, scheduleID)
+		jobsLock.Unlock()
+		retryRecurringJob(scheduleID)
+	})
+	jobsLock.Unlock()
+}
+
+// retryRecurringJob 重新觸發週期排程失敗的執行
+func retryRecurringJob(scheduleID uint) {
+	var s models.Schedule
+	if err := database.DB.First(&s, scheduleID).Error; err != nil {
+		return
+	}
+	if s.Status != models.SchedulePending {
+		return
+	}
+
+	s.LastRunStatus = models.ScheduleTriggered
+	database.DB.Save(&s)
+	enqueueScheduled(s)
+}
+
+// markScheduleFailed 在排程無法加入執行佇列時標記為失敗
+func markScheduleFailed(s models.Schedule) {
+	updates := map[string]interface{}{"last_run_status": models.ScheduleFailed}
+	if !s.IsRecurring() {
+		updates["status"] = models.ScheduleFailed
+	}
+	database.DB.Model(&models.Schedule{}).Where("id = ?", s.ID).Updates(updates)
+}
+
+// reconcileTriggeredSchedules 修正伺服器重啟前已觸發、但尚未記錄結果的排程
+//
+// 說明:
+//   若對應的執行記錄已結束，直接套用其結果；仍在佇列中的執行記錄
+//   會在完成後經由 executor 的 recovered callback 呼叫 HandleExecutionResult。
+func reconcileTriggeredSchedules() {
+	var schedules []models.Schedule
+	database.DB.Where("status = ? OR last_run_status = ?", models.ScheduleTriggered, models.ScheduleTriggered).Find(&schedules)
+
+	for _, s := range schedules {
+		var execution models.Execution
+		if err := database.DB.Where("schedule_id = ?", s.ID).Order("id desc").First(&execution).Error; err != nil {
+			markScheduleFailed(s)
+			continue
+		}
+		if execution.IsFinished() {
+			HandleExecutionResult(&execution)
+		}
+	}
+}
@@ -5,26 +5,67 @@ import (
 	"agent-workspace-manager/internal/models"
 	"agent-workspace-manager/internal/services/executor"
 	"agent-workspace-manager/internal/services/telegram"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
+var (
+	// ErrScheduleNotFound 表示排程不存在
+	ErrScheduleNotFound = errors.New("schedule not found")
+	// ErrNotRecurring 表示操作僅適用於週期排程
+	ErrNotRecurring = errors.New("schedule is not recurring")
+	// ErrInvalidScheduleState 表示排程目前的狀態不允許此操作
+	ErrInvalidScheduleState = errors.New("invalid schedule state for this operation")
+)
+
 // Cron 是全域的排程器實例
 var Cron *cron.Cron
 
+// cronParser 解析 Cron 表達式，秒欄位為選填 (同時支援標準 5 欄位與含秒的 6 欄位格式)
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// cronEntries 記錄週期排程 ID 對應的 Cron Entry ID，供暫停或取消時移除
+var cronEntries = make(map[uint]cron.EntryID)
+
+// timers 記錄一次性排程 ID 對應的定時器，供取消或重新排程時停止
+var timers = make(map[uint]*time.Timer)
+
+// jobsLock 保護 cronEntries 與 timers 兩個 map
+var jobsLock sync.Mutex
+
 // InitScheduler 初始化排程器服務
 //
 // 功能:
-//  1. 建立並啟動一個支援秒級精度的 Cron 排程器。
-//  2. 從資料庫載入所有狀態為 Pending 的排程任務。
-//  3. 將這些任務重新加入排程系統，確保伺服器重啟後任務不丟失。
+//  1. 建立並啟動一個支援秒級精度 (選填) 的 Cron 排程器。
+//  2. 修正重啟前已觸發但尚未記錄結果的排程。
+//  3. 從資料庫載入所有狀態為 Pending 的排程任務。
+//  4. 依各排程的 Misfire 策略處理停機期間錯過的執行 (補執行或記錄為 Missed)。
+//  5. 將這些任務重新加入排程系統，確保伺服器重啟後任務不丟失。
+//  6. 透過 Telegram 發送補執行與略過的摘要。
 func InitScheduler() {
-	Cron = cron.New(cron.WithSeconds())
+	// 重新初始化時停止舊的排程器，避免殘留的 Cron Entry 與定時器繼續觸發
+	// (第一次初始化時保留定時器: executor.InitQueue 處理被中斷的執行時可能已安排重試)
+	if Cron != nil {
+		Cron.Stop()
+		jobsLock.Lock()
+		cronEntries = make(map[uint]cron.EntryID)
+		for _, timer := range timers {
+			timer.Stop()
+		}
+		timers = make(map[uint]*time.Timer)
+		jobsLock.Unlock()
+	}
+
+	Cron = cron.New(cron.WithParser(cronParser))
 	Cron.Start()
 
+	reconcileTriggeredSchedules()
+
 	// 載入等待中的排程
 	var schedules []models.Schedule
 	if err := database.DB.Where("status = ?", models.SchedulePending).Find(&schedules).Error; err != nil {
@@ -32,9 +73,36 @@ func InitScheduler() {
 		return
 	}
 
-	for _, s := range schedules {
-		ScheduleJob(s)
+	recoverSchedules(schedules, time.Now())
+}
+
+// ParseCronSpec 解析 Cron 表達式與時區
+//
+// 參數:
+//   - expression: Cron 表達式 (5 或 6 欄位，或 @daily 等描述符)。
+//   - timezone: IANA 時區名稱 (如 Asia/Taipei)，空值代表伺服器時區。
+//
+// 返回:
+//   - cron.Schedule: 可計算下一次執行時間的排程物件。
+//   - error: 表達式或時區無效時返回錯誤。
+func ParseCronSpec(expression, timezone string) (cron.Schedule, error) {
+	spec := expression
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", timezone, err)
+		}
+		spec = "CRON_TZ=" + timezone + " " + expression
+	}
+	return cronParser.Parse(spec)
+}
+
+// NextRunTime 計算 Cron 表達式在指定時間之後的下一次執行時間
+func NextRunTime(expression, timezone string, from time.Time) (time.Time, error) {
+	sched, err := ParseCronSpec(expression, timezone)
+	if err != nil {
+		return time.Time{}, err
 	}
+	return sched.Next(from), nil
 }
 
 // ScheduleJob 將單個任務加入排程
@@ -43,10 +111,19 @@ func InitScheduler() {
 //   - s: 排程任務模型物件。
 //
 // 邏輯:
-//  1. 檢查預定時間是否已過。
-//  2. 若時間已過，立即執行任務。
-//  3. 若時間未到，計算剩餘時間，使用 time.AfterFunc 設定定時器。
+//  1. 先移除該排程既有的定時器或 Cron Entry (重新排程時使用)。
+//  2. 週期排程交由 Cron 排程器處理。
+//  3. 檢查預定時間是否已過。
+//  4. 若時間已過，立即執行任務。
+//  5. 若時間未到，計算剩餘時間，使用 time.AfterFunc 設定定時器。
 func ScheduleJob(s models.Schedule) {
+	UnscheduleJob(s.ID)
+
+	if s.IsRecurring() {
+		scheduleRecurring(s)
+		return
+	}
+
 	now := time.Now()
 	if s.ScheduledTime.Before(now) {
 		// 如果時間已過，立即執行
@@ -56,25 +133,135 @@ func ScheduleJob(s models.Schedule) {
 	}
 
 	duration := s.ScheduledTime.Sub(now)
-	// 使用 time.AfterFunc 在指定時間後執行
-	time.AfterFunc(duration, func() {
-		runJob(s.ID)
+	scheduleID := s.ID
+	// 使用 time.AfterFunc 在指定時間後執行，並保留定時器以便取消
+	jobsLock.Lock()
+	timers[scheduleID] = time.AfterFunc(duration, func() {
+		jobsLock.Lock()
+		delete(timers, scheduleID)
+		jobsLock.Unlock()
+		runJob(scheduleID)
 	})
-	
+	jobsLock.Unlock()
+
 	log.Printf("Scheduled job %d for %v", s.ID, s.ScheduledTime)
 }
 
-// runJob 執行排程任務的實際邏輯
+// scheduleRecurring 將週期排程註冊到 Cron 排程器
+//
+// 參數:
+//   - s: 週期排程模型物件 (CronExpression 不為空)。
+//
+// 說明:
+//   註冊後會重新計算並儲存下一次執行時間 (ScheduledTime)。
+func scheduleRecurring(s models.Schedule) {
+	sched, err := ParseCronSpec(s.CronExpression, s.Timezone)
+	if err != nil {
+		log.Printf("Invalid cron expression for schedule %d: %v", s.ID, err)
+		return
+	}
+
+	scheduleID := s.ID
+	jobsLock.Lock()
+	cronEntries[scheduleID] = Cron.Schedule(sched, cron.FuncJob(func() {
+		runRecurringJob(scheduleID)
+	}))
+	jobsLock.Unlock()
+
+	s.ScheduledTime = sched.Next(time.Now())
+	database.DB.Model(&models.Schedule{}).Where("id = ?", s.ID).Update("scheduled_time", s.ScheduledTime)
+
+	log.Printf("Scheduled recurring job %d (%s), next run at %v", s.ID, s.CronExpression, s.ScheduledTime)
+}
+
+// UnscheduleJob 從排程系統移除任務
+//
+// 參數:
+//   - scheduleID: 排程任務 ID。
+//
+// 說明:
+//   停止一次性排程的定時器並移除週期排程的 Cron Entry，
+//   讓已取消或重新排程的任務不會再以舊設定觸發。
+func UnscheduleJob(scheduleID uint) {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+
+	if timer, exists := timers[scheduleID]; exists {
+		timer.Stop()
+		delete(timers, scheduleID)
+	}
+	if entryID, exists := cronEntries[scheduleID]; exists {
+		Cron.Remove(entryID)
+		delete(cronEntries, scheduleID)
+	}
+}
+
+// PauseSchedule 暫停週期排程
+//
+// 參數:
+//   - scheduleID: 排程 ID。
+//
+// 返回:
+//   - *models.Schedule: 更新後的排程。
+//   - error: 排程不存在、非週期排程或目前不是 Pending 狀態時返回錯誤。
+func PauseSchedule(scheduleID uint) (*models.Schedule, error) {
+	var s models.Schedule
+	if err := database.DB.First(&s, scheduleID).Error; err != nil {
+		return nil, ErrScheduleNotFound
+	}
+	if !s.IsRecurring() {
+		return nil, ErrNotRecurring
+	}
+	if s.Status != models.SchedulePending {
+		return nil, ErrInvalidScheduleState
+	}
+
+	UnscheduleJob(s.ID)
+	s.Status = models.SchedulePaused
+	database.DB.Save(&s)
+
+	log.Printf("Paused recurring job %d", s.ID)
+	return &s, nil
+}
+
+// ResumeSchedule 恢復已暫停的週期排程
+//
+// 參數:
+//   - scheduleID: 排程 ID。
+//
+// 返回:
+//   - *models.Schedule: 更新後的排程 (含重新計算的下一次執行時間)。
+//   - error: 排程不存在、非週期排程或目前不是 Paused 狀態時返回錯誤。
+func ResumeSchedule(scheduleID uint) (*models.Schedule, error) {
+	var s models.Schedule
+	if err := database.DB.First(&s, scheduleID).Error; err != nil {
+		return nil, ErrScheduleNotFound
+	}
+	if !s.IsRecurring() {
+		return nil, ErrNotRecurring
+	}
+	if s.Status != models.SchedulePaused {
+		return nil, ErrInvalidScheduleState
+	}
+
+	s.Status = models.SchedulePending
+	database.DB.Save(&s)
+	scheduleRecurring(s)
+
+	// 重新讀取以取得最新的下一次執行時間
+	database.DB.First(&s, scheduleID)
+	return &s, nil
+}
+
+// runJob 執行一次性排程任務的實際邏輯
 //
 // 參數:
 //   - scheduleID: 排程任務 ID。
 //
 // 流程:
 //  1. 從資料庫查詢排程任務，確認其存在且狀態為 Pending。
-//  2. 將排程狀態更新為 Completed (表示已觸發)。
-//  3. 查詢關聯的專案資訊。
-//  4. 呼叫 executor.ExecuteCommand 執行 AI 指令。
-//  5. 設定回呼函式，在執行完成後透過 Telegram 發送通知。
+//  2. 將排程狀態更新為 Triggered，最終狀態由 HandleExecutionResult 依執行結果決定。
+//  3. 將指令加入執行佇列。
 func runJob(scheduleID uint) {
 	var s models.Schedule
 	if err := database.DB.First(&s, scheduleID).Error; err != nil {
@@ -86,25 +273,89 @@ func runJob(scheduleID uint) {
 		return
 	}
 
-	// 更新狀態為已完成 (表示已觸發執行)
-	s.Status = models.ScheduleCompleted
+	// 更新狀態為已觸發 (等待執行結果)
+	s.Status = models.ScheduleTriggered
+	s.LastRunStatus = models.ScheduleTriggered
+	database.DB.Save(&s)
+
+	enqueueScheduled(s)
+}
+
+// runRecurringJob 執行週期排程任務的實際邏輯 (由 Cron 排程器呼叫)
+//
+// 參數:
+//   - scheduleID: 排程任務 ID。
+//
+// 流程:
+//  1. 從資料庫查詢排程任務，確認其存在且狀態為 Pending。
+//  2. 記錄本次觸發時間並計算下一次執行時間。
+//  3. 將指令加入執行佇列。
+func runRecurringJob(scheduleID uint) {
+	var s models.Schedule
+	if err := database.DB.First(&s, scheduleID).Error; err != nil {
+		log.Printf("Schedule %d not found during execution", scheduleID)
+		UnscheduleJob(scheduleID)
+		return
+	}
+
+	if s.Status != models.SchedulePending {
+		return
+	}
+
+	now := time.Now()
+	s.LastRunTime = &now
+	s.LastRunStatus = models.ScheduleTriggered
+	s.RetryCount = 0
+	if next, err := NextRunTime(s.CronExpression, s.Timezone, now); err == nil {
+		s.ScheduledTime = next
+	}
 	database.DB.Save(&s)
 
-	// 觸發執行
+	enqueueScheduled(s)
+}
+
+// enqueueScheduled 將排程的指令加入 executor 執行佇列
+//
+// 說明:
+//   執行記錄會帶上 ScheduleID，以便查詢排程的執行歷史；
+//   執行完成後依結果更新排程狀態 (必要時安排重試)，並透過 Telegram 發送通知。
+func enqueueScheduled(s models.Schedule) {
 	var project models.Project
 	if err := database.DB.First(&project, s.ProjectID).Error; err != nil {
 		log.Printf("Project %d not found for schedule %d", s.ProjectID, s.ID)
+		markScheduleFailed(s)
 		return
 	}
 
 	log.Printf("Executing scheduled job %d: %s", s.ID, s.Command)
-	
-	// 使用 executor 執行指令
-	executor.ExecuteCommand(s.ProjectID, s.Command, func(execution *models.Execution) {
+
+	scheduleID := s.ID
+	execution := &models.Execution{
+		ProjectID:  s.ProjectID,
+		Command:    s.Command,
+		ScheduleID: &scheduleID,
+	}
+	err := executor.Enqueue(execution, func(execution *models.Execution) {
+		retryIn := HandleExecutionResult(execution)
+
 		msg := fmt.Sprintf("Scheduled Task Executed\nProject: %s\nStatus: %s\nSummary: %s", project.Name, execution.Status, execution.Summary)
-		if execution.Status == models.StatusFailed || execution.Status == models.StatusParseFailed {
+		if execution.IsFailure() {
 			msg += fmt.Sprintf("\nError: %s", execution.ErrorMessage)
 		}
-		telegram.SendNotification(msg)
+		if retryIn > 0 {
+			msg += fmt.Sprintf("\nRetrying in %s", retryIn)
+		}
+		telegram.SendNotification(telegram.FormatWarnings(execution) + msg)
+		if execution.ReviewStatus == models.ReviewPending {
+			telegram.RequestApproval(execution)
+		}
 	})
+	if err != nil {
+		log.Printf("Failed to queue scheduled job %d: %v", s.ID, err)
+		markScheduleFailed(s)
+		return
+	}
+
+	// 只更新 execution_id 欄位，避免覆蓋執行完成後已寫入的狀態
+	database.DB.Model(&models.Schedule{}).Where("id = ?", s.ID).Update("execution_id", execution.ID)
 }
@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// encryptedPrefix 標示以目前格式 (AES-256-GCM) 加密的內容
+const encryptedPrefix = "enc:v1:"
+
+// ErrKeyNotSet 表示尚未以 Init 設定加密金鑰
+var ErrKeyNotSet = errors.New("secret key is not set")
+
+// aead 是以加密金鑰建立的 AES-GCM 加密器
+var aead cipher.AEAD
+
+// Init 設定加密機密資料 (例如專案環境變數) 所使用的金鑰
+//
+// 參數:
+//   - key: 金鑰字串 (任意長度，以 SHA-256 衍生為 AES-256 金鑰)。
+//   - keyFile: key 為空字串時讀取的金鑰檔；檔案不存在時會產生隨機金鑰並以 0600 權限寫入。
+//
+// 說明:
+//   金鑰遺失或變更後，已加密的資料將無法解密，請妥善備份金鑰檔。
+func Init(key, keyFile string) error {
+	if key == "" {
+		if keyFile == "" {
+			return ErrKeyNotSet
+		}
+		loaded, err := loadOrCreateKeyFile(keyFile)
+		if err != nil {
+			return err
+		}
+		key = loaded
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	aead = gcm
+	return nil
+}
+
+// loadOrCreateKeyFile 讀取金鑰檔，不存在時產生新的隨機金鑰
+func loadOrCreateKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", errors.New("secret key file is empty")
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(raw)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Encrypt 加密資料，返回可存入資料庫的字串
+func Encrypt(plaintext []byte) (string, error) {
+	if aead == nil {
+		return "", ErrKeyNotSet
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// IsEncrypted 判斷字串是否為 Encrypt 產生的密文
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Decrypt 解密 Encrypt 產生的字串
+func Decrypt(value string) ([]byte, error) {
+	if aead == nil {
+		return nil, ErrKeyNotSet
+	}
+	if !IsEncrypted(value) {
+		return nil, errors.New("value is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
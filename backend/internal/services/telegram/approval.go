@@ -0,0 +1,167 @@
+package telegram
+
+import (
+	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/executor"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxMessageLength 是 Telegram 單則訊息的長度上限 (保留空間給標題與截斷標記)
+const maxMessageLength = 3500
+
+// 定義 Inline Keyboard 回呼資料的動作 (格式為 "動作:執行記錄 ID")
+const (
+	callbackApprove = "approve"
+	callbackReject  = "reject"
+	callbackDiff    = "diff"
+)
+
+// RequestApproval 發送等待審核的執行結果與核准按鈕給所有白名單使用者
+//
+// 參數:
+//   - execution: 等待審核的執行記錄。
+//
+// 功能:
+//   - 組合摘要與變更檔案列表。
+//   - 附上 Approve / Reject / Show diff 三個 Inline Keyboard 按鈕，由 handleCallbackQuery 處理。
+//   - 未成功完成的執行 (失敗、逾時或被取消) 不可核准，只提供 Reject 以清理 worktree。
+func RequestApproval(execution *models.Execution) {
+	if Bot == nil {
+		return
+	}
+	var project models.Project
+	if err := database.DB.First(&project, execution.ProjectID).Error; err != nil {
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("🔍 Approval required\nProject: %s\nExecution ID: %d\nSummary: %s\n", project.Name, execution.ID, execution.Summary))
+	if execution.WorktreeBranch != "" {
+		text.WriteString(fmt.Sprintf("Branch: %s\n", execution.WorktreeBranch))
+	}
+	text.WriteString(formatChangedFiles(execution))
+
+	var buttons []tgbotapi.InlineKeyboardButton
+	if execution.IsApprovable() {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("✅ Approve", fmt.Sprintf("%s:%d", callbackApprove, execution.ID)))
+	} else {
+		text.WriteString(fmt.Sprintf("Execution ended with status %s and can only be rejected.\n", execution.Status))
+	}
+	buttons = append(buttons,
+		tgbotapi.NewInlineKeyboardButtonData("❌ Reject", fmt.Sprintf("%s:%d", callbackReject, execution.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("📄 Show diff", fmt.Sprintf("%s:%d", callbackDiff, execution.ID)),
+	)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(buttons...))
+	for _, chatID := range allowedUserIDs {
+		msg := tgbotapi.NewMessage(chatID, text.String())
+		msg.ReplyMarkup = keyboard
+		Bot.Send(msg)
+	}
+}
+
+// formatChangedFiles 將執行記錄的變更檔案列表格式化為訊息內容
+func formatChangedFiles(execution *models.Execution) string {
+	var b strings.Builder
+	for _, group := range []struct {
+		label string
+		files []string
+	}{
+		{"Modified", execution.ModifiedFiles},
+		{"Created", execution.CreatedFiles},
+		{"Deleted", execution.DeletedFiles},
+	} {
+		if len(group.files) == 0 {
+			continue
+		}
+		b.WriteString(group.label + ":\n")
+		for _, f := range group.files {
+			b.WriteString("  - " + f + "\n")
+		}
+	}
+	if b.Len() == 0 {
+		return "No file changes\n"
+	}
+	return b.String()
+}
+
+// handleCallbackQuery 處理 Inline Keyboard 按鈕的回呼
+//
+// 參數:
+//   - query: Telegram 回呼查詢，Data 格式為 "動作:執行記錄 ID"。
+//
+// 功能:
+//   - 驗證按下按鈕的使用者是否在白名單中。
+//   - approve/reject: 呼叫 executor 核准或拒絕，並更新原訊息 (移除按鈕、附上結果)。
+//   - diff: 發送執行的 Diff (超過長度上限時截斷)。
+func handleCallbackQuery(query *tgbotapi.CallbackQuery) {
+	Log.Info("Received callback query", "user_id", query.From.ID, "data", query.Data)
+	if !isUserAllowed(query.From.ID) {
+		Bot.Request(tgbotapi.NewCallback(query.ID, "You are not authorized to use this bot."))
+		return
+	}
+
+	action, idStr, _ := strings.Cut(query.Data, ":")
+	executionID, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		Bot.Request(tgbotapi.NewCallback(query.ID, "Invalid execution ID"))
+		return
+	}
+
+	var result string
+	switch action {
+	case callbackApprove:
+		if _, err := executor.ApproveExecution(uint(executionID)); err != nil {
+			Bot.Request(tgbotapi.NewCallback(query.ID, fmt.Sprintf("Approve failed: %v", err)))
+			return
+		}
+		result = fmt.Sprintf("✅ Approved by %s", query.From.UserName)
+	case callbackReject:
+		execution, err := executor.RejectExecution(uint(executionID), NotifyExecutionResult)
+		if err != nil {
+			Bot.Request(tgbotapi.NewCallback(query.ID, fmt.Sprintf("Reject failed: %v", err)))
+			return
+		}
+		result = fmt.Sprintf("❌ Rejected by %s", query.From.UserName)
+		if execution.ErrorMessage != "" {
+			result += "\n" + execution.ErrorMessage
+		}
+	case callbackDiff:
+		Bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		if query.Message != nil {
+			sendExecutionDiff(query.Message.Chat.ID, uint(executionID))
+		}
+		return
+	default:
+		Bot.Request(tgbotapi.NewCallback(query.ID, "Unknown action"))
+		return
+	}
+
+	Bot.Request(tgbotapi.NewCallback(query.ID, result))
+	if query.Message != nil {
+		// 重新編輯訊息時不帶 ReplyMarkup，按鈕會被移除
+		Bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, query.Message.Text+"\n\n"+result))
+	}
+}
+
+// sendExecutionDiff 發送執行記錄的 Diff (非 Git 專案則發送變更檔案列表)
+func sendExecutionDiff(chatID int64, executionID uint) {
+	var execution models.Execution
+	if err := database.DB.First(&execution, executionID).Error; err != nil {
+		Bot.Send(tgbotapi.NewMessage(chatID, "Execution not found"))
+		return
+	}
+
+	diff := execution.Diff
+	if diff == "" {
+		diff = formatChangedFiles(&execution)
+	}
+	if len(diff) > maxMessageLength {
+		diff = strings.ToValidUTF8(diff[:maxMessageLength], "") + "\n... [truncated] ..."
+	}
+	Bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Diff for execution %d:\n%s", execution.ID, diff)))
+}
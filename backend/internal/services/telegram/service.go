@@ -80,7 +80,8 @@ func InitBot(cfg *config.Config, logger *slog.Logger) {
 //  1. 設定更新配置 (Timeout 為 60 秒)。
 //  2. 透過 Channel 接收更新。
 //  3. 驗證發送者是否在白名單中，若不在則拒絕存取。
-//  4. 辨識並分派指令給對應的處理函數。
+//  4. 辨識並分派指令給對應的處理函數；Inline Keyboard 的回呼交由 handleCallbackQuery 處理。
+//  5. 回覆執行結果通知的訊息交由 handleReply 延續對話工作階段。
 func listenForUpdates() {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
@@ -88,6 +89,11 @@ func listenForUpdates() {
 	updates := Bot.GetUpdatesChan(u)
 
 	for update := range updates {
+		// 處理 Inline Keyboard 按鈕 (核准/拒絕/顯示 Diff)
+		if update.CallbackQuery != nil {
+			handleCallbackQuery(update.CallbackQuery)
+			continue
+		}
 		if update.Message == nil {
 			continue
 		}
@@ -106,6 +112,8 @@ func listenForUpdates() {
 		if update.Message.IsCommand() {
 			Log.Info("Handling command", "command", update.Message.Command(), "args", update.Message.CommandArguments())
 			handleCommand(update.Message)
+		} else if update.Message.ReplyToMessage != nil {
+			handleReply(update.Message)
 		} else {
 			Log.Debug("Message is not a command", "text", update.Message.Text)
 		}
@@ -141,10 +149,14 @@ func isUserAllowed(userID int64) bool {
 //   - /pp [page]: 列出專案列表 (分頁)。
 //   - /run [project_name] [command]: 執行指定專案的 AI 指令。
 //   - /status [project_name]: 查詢指定專案的最後一次執行狀態。
+//   - /queue [project_name]: 查詢指定專案排隊中的指令。
+//   - /cancel [project_name]: 取消指定專案執行中的指令。
+//   - /revert [project_name] [execution_id]: 還原指定執行 (預設為最近一次) 的檔案變更。
+//   - /note [project_name] [text]: 在專案記憶加入一筆筆記 (未提供 text 時顯示專案記憶)。
 func handleCommand(msg *tgbotapi.Message) {
 	switch msg.Command() {
 	case "help":
-		msg := tgbotapi.NewMessage(msg.Chat.ID, "Available commands:\n/pp [page] - List projects\n/run [project_name] [command] - Run command\n/status [project_name] - Check status")
+		msg := tgbotapi.NewMessage(msg.Chat.ID, "Available commands:\n/pp [page] - List projects\n/run [project_name] [command] - Run command\n/status [project_name] - Check status\n/queue [project_name] - Show queued commands\n/cancel [project_name] - Cancel running command\n/revert [project_name] [execution_id] - Revert file changes of an execution\n/note [project_name] [text] - Add a note to the project memory (shows the memory without text)")
 		Bot.Send(msg)
 	case "pp":
 		handleListProjects(msg)
@@ -152,6 +164,14 @@ func handleCommand(msg *tgbotapi.Message) {
 		handleRun(msg)
 	case "status":
 		handleStatus(msg)
+	case "queue":
+		handleQueue(msg)
+	case "cancel":
+		handleCancel(msg)
+	case "revert":
+		handleRevert(msg)
+	case "note":
+		handleNote(msg)
 	default:
 		Log.Warn("Unknown command received", "command", msg.Command())
 		msg := tgbotapi.NewMessage(msg.Chat.ID, "Unknown command")
@@ -206,8 +226,8 @@ func handleListProjects(msg *tgbotapi.Message) {
 // 功能:
 //   - 驗證參數完整性。
 //   - 根據專案名稱查詢專案 ID。
-//   - 呼叫 executor 服務非同步執行指令。
-//   - 執行完成後透過 SendNotification 發送結果通知。
+//   - 將指令加入 executor 執行佇列，並回覆佇列位置。
+//   - 執行完成後透過 NotifyExecutionResult 發送結果通知。
 func handleRun(msg *tgbotapi.Message) {
 	args := strings.SplitN(msg.CommandArguments(), " ", 2)
 	if len(args) < 2 {
@@ -224,15 +244,14 @@ func handleRun(msg *tgbotapi.Message) {
 		return
 	}
 
-	// 非同步執行並在完成後通知
-	go executor.ExecuteCommand(project.ID, command, func(execution *models.Execution) {
-		msg := fmt.Sprintf("Project: %s\nStatus: %s\nSummary: %s", project.Name, execution.Status, execution.Summary)
-		if execution.Status == models.StatusFailed || execution.Status == models.StatusParseFailed {
-			msg += fmt.Sprintf("\nError: %s", execution.ErrorMessage)
-		}
-		SendNotification(msg)
-	})
-	Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Command execution started."))
+	// 加入執行佇列並在完成後通知
+	execution, err := executor.ExecuteCommand(project.ID, command, NotifyExecutionResult)
+	if err != nil {
+		Log.Error("Failed to queue command", "project", project.Name, "error", err)
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Failed to queue command."))
+		return
+	}
+	Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Command queued (Execution ID: %d, position: %d).", execution.ID, execution.QueuePosition)))
 }
 
 // handleStatus 處理 /status 指令：查詢專案狀態
@@ -263,13 +282,290 @@ func handleStatus(msg *tgbotapi.Message) {
 		return
 	}
 
-	response := fmt.Sprintf("Last Execution Status: %s\nStart Time: %s", lastExecution.Status, lastExecution.StartTime.Format(time.RFC3339))
+	response := fmt.Sprintf("Last Execution Status: %s", lastExecution.Status)
+	if lastExecution.Status == models.StatusQueued {
+		response += fmt.Sprintf("\nQueue Position: %d", executor.GetQueuePosition(&lastExecution))
+	} else {
+		response += fmt.Sprintf("\nStart Time: %s", lastExecution.StartTime.Format(time.RFC3339))
+	}
 	if !lastExecution.EndTime.IsZero() {
 		response += fmt.Sprintf("\nEnd Time: %s", lastExecution.EndTime.Format(time.RFC3339))
 	}
 	Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, response))
 }
 
+// handleQueue 處理 /queue 指令：查詢專案執行佇列
+//
+// 參數:
+//   - msg: Telegram 訊息物件，必須包含 [project_name]。
+//
+// 功能:
+//   - 根據專案名稱查詢專案。
+//   - 依 FIFO 順序列出排隊中的指令與其位置。
+func handleQueue(msg *tgbotapi.Message) {
+	projectName := msg.CommandArguments()
+	if projectName == "" {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Usage: /queue [project_name]"))
+		return
+	}
+
+	var project models.Project
+	if err := database.DB.Where("name = ?", projectName).First(&project).Error; err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Project not found"))
+		return
+	}
+
+	queued := executor.GetQueue(project.ID)
+	if len(queued) == 0 {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Queue is empty"))
+		return
+	}
+
+	var response strings.Builder
+	response.WriteString(fmt.Sprintf("Queue for %s:\n", project.Name))
+	for _, e := range queued {
+		response.WriteString(fmt.Sprintf("%d. [ID: %d] %s\n", e.QueuePosition, e.ID, e.Command))
+	}
+	Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, response.String()))
+}
+
+// handleCancel 處理 /cancel 指令：取消專案執行中的指令
+//
+// 參數:
+//   - msg: Telegram 訊息物件，必須包含 [project_name]。
+//
+// 功能:
+//   - 根據專案名稱查詢專案。
+//   - 找出該專案執行中的記錄並要求 executor 終止其程序。
+//   - 取消完成後的結果由 NotifyExecutionResult 另行通知。
+func handleCancel(msg *tgbotapi.Message) {
+	projectName := msg.CommandArguments()
+	if projectName == "" {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Usage: /cancel [project_name]"))
+		return
+	}
+
+	var project models.Project
+	if err := database.DB.Where("name = ?", projectName).First(&project).Error; err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Project not found"))
+		return
+	}
+
+	execution := executor.GetRunningExecution(project.ID)
+	if execution == nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "No running execution"))
+		return
+	}
+
+	if err := executor.CancelExecution(execution.ID); err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Failed to cancel execution: %v", err)))
+		return
+	}
+	Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Cancelling execution %d...", execution.ID)))
+}
+
+// handleRevert 處理 /revert 指令：還原執行的檔案變更
+//
+// 參數:
+//   - msg: Telegram 訊息物件，必須包含 [project_name]，可選 [execution_id]。
+//
+// 功能:
+//   - 根據專案名稱查詢專案。
+//   - 未指定 execution_id 時，選擇該專案最近一次有快照且尚未被還原的執行 (不含還原本身)。
+//   - 將還原加入執行佇列，完成後的結果由 NotifyExecutionResult 另行通知。
+func handleRevert(msg *tgbotapi.Message) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Usage: /revert [project_name] [execution_id]"))
+		return
+	}
+
+	var project models.Project
+	if err := database.DB.Where("name = ?", args[0]).First(&project).Error; err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Project not found"))
+		return
+	}
+
+	var target models.Execution
+	query := database.DB.Where("project_id = ?", project.ID)
+	if len(args) > 1 {
+		executionID, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Invalid execution ID"))
+			return
+		}
+		query = query.Where("id = ?", executionID)
+	} else {
+		query = query.Where("snapshot_kind != '' AND revert_of_id IS NULL AND reverted_by_id IS NULL AND status NOT IN ?",
+			[]string{models.StatusQueued, models.StatusRunning}).
+			Order("id desc")
+	}
+	if err := query.First(&target).Error; err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "No revertable execution found"))
+		return
+	}
+
+	revert, err := executor.RevertExecution(target.ID, NotifyExecutionResult)
+	if err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Failed to revert execution %d: %v", target.ID, err)))
+		return
+	}
+	Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Revert of execution %d queued (Execution ID: %d, position: %d).", target.ID, revert.ID, revert.QueuePosition)))
+}
+
+// handleNote 處理 /note 指令：在專案記憶加入筆記
+//
+// 參數:
+//   - msg: Telegram 訊息物件，必須包含 [project_name]，可選 [text]。
+//
+// 功能:
+//   - 根據專案名稱查詢專案。
+//   - 提供 text 時將其加入專案記憶，之後每次執行的提示詞都會包含。
+//   - 未提供 text 時回覆目前的專案記憶。
+func handleNote(msg *tgbotapi.Message) {
+	args := strings.SplitN(strings.TrimSpace(msg.CommandArguments()), " ", 2)
+	if args[0] == "" {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Usage: /note [project_name] [text]"))
+		return
+	}
+
+	var project models.Project
+	if err := database.DB.Where("name = ?", args[0]).First(&project).Error; err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Project not found"))
+		return
+	}
+
+	if len(args) < 2 || strings.TrimSpace(args[1]) == "" {
+		if project.Memory == "" {
+			Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Project %s has no memory yet.", project.Name)))
+		} else {
+			Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Memory of %s:\n%s", project.Name, project.Memory)))
+		}
+		return
+	}
+
+	if err := executor.ValidateMemoryNote(args[1]); err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Invalid note: %v", err)))
+		return
+	}
+	if _, err := executor.AppendProjectMemory(project.ID, []string{args[1]}); err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Failed to add note: %v", err)))
+		return
+	}
+	Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Note added to %s.", project.Name)))
+}
+
+// handleReply 處理回覆執行結果通知的訊息：延續該執行的對話工作階段
+//
+// 參數:
+//   - msg: Telegram 訊息物件，回覆的訊息必須是 NotifyExecutionResult 發送的通知。
+//
+// 功能:
+//   - 由回覆的訊息找出對應的執行記錄。
+//   - 以訊息內容作為下一輪指令延續工作階段 (執行尚未屬於工作階段時建立新的工作階段)。
+//   - 工作階段已閒置過期時提示改用 /run。
+func handleReply(msg *tgbotapi.Message) {
+	command := strings.TrimSpace(msg.Text)
+	if command == "" {
+		return
+	}
+
+	var notification models.NotificationMessage
+	if err := database.DB.Where("chat_id = ? AND message_id = ?", msg.Chat.ID, msg.ReplyToMessage.MessageID).First(&notification).Error; err != nil {
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Reply to an execution result to continue its session."))
+		return
+	}
+
+	execution, err := executor.ContinueSession(notification.ExecutionID, msg.Chat.ID, command, NotifyExecutionResult)
+	switch err {
+	case nil:
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Session #%d continued (Execution ID: %d, position: %d).", *execution.SessionID, execution.ID, execution.QueuePosition)))
+	case executor.ErrSessionExpired:
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "This session has expired. Use /run to start a new task."))
+	default:
+		Log.Error("Failed to continue session", "execution_id", notification.ExecutionID, "error", err)
+		Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Failed to continue session."))
+	}
+}
+
+// NotifyExecutionResult 發送執行結果通知給所有白名單使用者
+//
+// 參數:
+//   - execution: 已完成的執行記錄。
+//
+// 功能:
+//   - 查詢執行記錄所屬的專案名稱。
+//   - 組合狀態、摘要與錯誤訊息後發送給所有白名單使用者，並記錄訊息 ID (回覆此訊息即可延續對話)。
+//   - 執行結果等待審核時，另外以 RequestApproval 發送核准按鈕。
+//   - 可直接作為 executor.CompletionCallback 使用。
+func NotifyExecutionResult(execution *models.Execution) {
+	var project models.Project
+	if err := database.DB.First(&project, execution.ProjectID).Error; err != nil {
+		return
+	}
+
+	msg := fmt.Sprintf("Project: %s\nStatus: %s\nSummary: %s", project.Name, execution.Status, execution.Summary)
+	if execution.IsFailure() {
+		msg += fmt.Sprintf("\nError: %s", execution.ErrorMessage)
+	}
+	if len(execution.MemoryUpdates) > 0 && project.MemoryAutoUpdate {
+		msg += fmt.Sprintf("\nMemory updates: %d", len(execution.MemoryUpdates))
+	}
+	if execution.SessionID != nil {
+		msg += fmt.Sprintf("\nSession: #%d", *execution.SessionID)
+	}
+	if execution.Status != models.StatusCancelled {
+		msg += "\n\nReply to this message to continue."
+	}
+	sendExecutionNotification(execution.ID, FormatWarnings(execution)+msg)
+	if execution.ReviewStatus == models.ReviewPending {
+		RequestApproval(execution)
+	}
+}
+
+// maxListedViolations 是通知中最多列出的違規數量
+const maxListedViolations = 5
+
+// FormatWarnings 產生執行結果通知開頭的安全警告 (沒有違規時返回空字串)
+//
+// 說明:
+//   檔案路徑違規代表 Agent 可能修改了專案目錄以外的檔案，以醒目的標記放在訊息最前面；
+//   沙箱阻擋的操作則附上第一筆作為範例。
+func FormatWarnings(execution *models.Execution) string {
+	var warning string
+	if count := len(execution.PolicyViolations); count > 0 {
+		warning += fmt.Sprintf("🚨🚨 POLICY VIOLATION 🚨🚨\nExecution #%d touched %d path(s) outside the project directory:\n", execution.ID, count)
+		for i, violation := range execution.PolicyViolations {
+			if i == maxListedViolations {
+				warning += fmt.Sprintf("• ... and %d more\n", count-maxListedViolations)
+				break
+			}
+			warning += "• " + violation + "\n"
+		}
+		warning += "\n"
+	}
+	if count := len(execution.SandboxViolations); count > 0 {
+		warning += fmt.Sprintf("⚠️ Sandbox blocked %d operation(s), e.g.: %s\n\n", count, execution.SandboxViolations[0])
+	}
+	return warning
+}
+
+// sendExecutionNotification 發送執行結果通知給所有白名單使用者，並記錄每則訊息對應的執行記錄
+func sendExecutionNotification(executionID uint, message string) {
+	if Bot == nil {
+		return
+	}
+
+	for _, chatID := range allowedUserIDs {
+		sent, err := Bot.Send(tgbotapi.NewMessage(chatID, message))
+		if err != nil {
+			Log.Warn("Failed to send execution result", "chat_id", chatID, "error", err)
+			continue
+		}
+		database.DB.Create(&models.NotificationMessage{ChatID: chatID, MessageID: sent.MessageID, ExecutionID: executionID})
+	}
+}
+
 // SendNotification 發送通知給所有白名單使用者
 //
 // 參數:
@@ -0,0 +1,301 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxBackupFileSize 是備份單一檔案的大小上限，超過的檔案只記錄於 Manifest 而不保存內容
+const MaxBackupFileSize = 100 << 20
+
+// ErrBackupStoreDisabled 表示尚未呼叫 InitBackupStore
+var ErrBackupStoreDisabled = errors.New("backup store is not initialized")
+
+// backupDir 是內容定址備份庫的根目錄 (blobs/ 存放檔案內容，snapshots/ 存放快照)
+var backupDir string
+
+// InitBackupStore 初始化內容定址備份庫
+//
+// 參數:
+//   - dir: 備份庫根目錄，不存在時會自動建立。
+func InitBackupStore(dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for _, sub := range []string{"blobs", "snapshots"} {
+		if err := os.MkdirAll(filepath.Join(absDir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	backupDir = absDir
+	return nil
+}
+
+// BackupEnabled 判斷備份庫是否已初始化
+func BackupEnabled() bool {
+	return backupDir != ""
+}
+
+// ManifestEntry 記錄單一檔案的狀態
+type ManifestEntry struct {
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`
+	Mode    fs.FileMode `json:"mode"`
+	// Hash 是檔案內容的 SHA-256；超過 MaxBackupFileSize 的檔案為空字串 (未備份)
+	Hash string `json:"hash"`
+}
+
+// Manifest 是目錄內所有檔案的狀態，鍵為相對於目錄的路徑 (使用 / 分隔)
+type Manifest map[string]ManifestEntry
+
+// BackupSnapshot 是一次執行所觸及檔案的執行前狀態，用於還原
+type BackupSnapshot struct {
+	// Files 是執行中被修改或刪除的檔案在執行前的狀態
+	Files Manifest `json:"files"`
+	// Created 是執行中新建立的檔案 (還原時刪除)
+	Created []string `json:"created"`
+}
+
+// ScanManifest 掃描目錄並建立 Manifest
+//
+// 參數:
+//   - dir: 要掃描的目錄。
+//   - reuse: 先前的 Manifest (可為 nil)；大小與修改時間相同的檔案直接沿用其 Hash，不重新讀取。
+//   - store: 是否將檔案內容保存到備份庫。
+//   - ignore: 略過的路徑規則 (可為 nil)。
+//
+// 說明:
+//   略過 .git 目錄、符合 ignore 的路徑與符號連結，只記錄一般檔案。
+func ScanManifest(dir string, reuse Manifest, store bool, ignore *IgnoreList) (Manifest, error) {
+	if store && !BackupEnabled() {
+		return nil, ErrBackupStoreDisabled
+	}
+
+	manifest := make(Manifest)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if d.Name() == ".git" || ignore.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || ignore.Match(rel, false) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := ManifestEntry{Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode().Perm()}
+		// 需要備份時，沿用的 Hash 必須已有對應的內容 (先前的掃描可能沒有保存內容)
+		if prev, ok := reuse[rel]; ok && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime) && (!store || prev.Hash == "" || blobExists(prev.Hash)) {
+			entry.Hash = prev.Hash
+		} else if entry.Size <= MaxBackupFileSize {
+			hash, err := hashFile(path, store)
+			if err != nil {
+				return err
+			}
+			entry.Hash = hash
+		}
+		manifest[rel] = entry
+		return nil
+	})
+	return manifest, err
+}
+
+// DiffManifests 比較執行前後的 Manifest
+//
+// 返回:
+//   - modified: 內容被修改的檔案。
+//   - created: 新建立的檔案。
+//   - deleted: 被刪除的檔案。
+func DiffManifests(before, after Manifest) (modified, created, deleted []string) {
+	for path, a := range after {
+		b, ok := before[path]
+		if !ok {
+			created = append(created, path)
+		} else if a.Size != b.Size || a.Hash != b.Hash {
+			modified = append(modified, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	sort.Strings(modified)
+	sort.Strings(created)
+	sort.Strings(deleted)
+	return modified, created, deleted
+}
+
+// SaveBackupSnapshot 將快照寫入備份庫
+//
+// 參數:
+//   - name: 快照名稱 (例如 execution-1)。
+//   - snapshot: 快照內容。
+func SaveBackupSnapshot(name string, snapshot *BackupSnapshot) error {
+	if !BackupEnabled() {
+		return ErrBackupStoreDisabled
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(backupDir, "snapshots", name+".json"), data, 0644)
+}
+
+// LoadBackupSnapshot 從備份庫讀取快照
+func LoadBackupSnapshot(name string) (*BackupSnapshot, error) {
+	if !BackupEnabled() {
+		return nil, ErrBackupStoreDisabled
+	}
+	data, err := os.ReadFile(filepath.Join(backupDir, "snapshots", name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var snapshot BackupSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// RestoreBackupSnapshot 將目錄還原為快照記錄的執行前狀態
+//
+// 參數:
+//   - dir: 專案目錄。
+//   - snapshot: 要還原的快照。
+//
+// 返回:
+//   - restored: 已從備份還原的檔案。
+//   - removed: 已刪除的檔案 (執行中新建立的檔案)。
+//   - error: 任一檔案還原失敗時返回錯誤 (已處理的檔案不會回復)。
+func RestoreBackupSnapshot(dir string, snapshot *BackupSnapshot) (restored, removed []string, err error) {
+	for path, entry := range snapshot.Files {
+		if entry.Hash == "" {
+			return restored, removed, fmt.Errorf("file %s was too large to back up", path)
+		}
+		target, err := SafeJoin(dir, path)
+		if err != nil {
+			return restored, removed, err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return restored, removed, err
+		}
+		// 檔案被換成符號連結時，先刪除連結本身再寫入，避免經由連結寫到其他位置
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return restored, removed, err
+			}
+		}
+		if err := copyFile(blobPath(entry.Hash), target); err != nil {
+			return restored, removed, err
+		}
+		os.Chmod(target, entry.Mode)
+		restored = append(restored, path)
+	}
+	if err := removeFiles(dir, snapshot.Created); err != nil {
+		return restored, removed, err
+	}
+	removed = snapshot.Created
+	sort.Strings(restored)
+	return restored, removed, nil
+}
+
+// SafeJoin 組合目錄與相對路徑，並拒絕跳出目錄的路徑
+//
+// 說明:
+//   除了絕對路徑與 ..，也會以 CheckPathSafety 逐層解析上層目錄的符號連結：
+//   Agent 將子目錄換成指向專案外的符號連結時，還原不會寫入或刪除專案外的檔案。
+//   路徑本身是符號連結時不檢查其目標 (刪除只會移除連結本身，寫入前由呼叫端先移除連結)。
+func SafeJoin(dir, rel string) (string, error) {
+	if filepath.IsAbs(rel) {
+		return "", fmt.Errorf("path %s is absolute", rel)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(rel))
+	if target != filepath.Clean(dir) && !strings.HasPrefix(target, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s escapes the project directory", rel)
+	}
+	if reason := CheckPathSafety(dir, filepath.Dir(filepath.FromSlash(rel))); reason != "" {
+		return "", fmt.Errorf("path %s is unsafe: %s", rel, reason)
+	}
+	return target, nil
+}
+
+// hashFile 計算檔案的 SHA-256，store 為 true 時同時將內容保存到備份庫
+func hashFile(path string, store bool) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if !store {
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	// 先寫入暫存檔，計算出 Hash 後再搬移到內容定址的位置
+	tmp, err := os.CreateTemp(filepath.Join(backupDir, "blobs"), "tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(io.MultiWriter(h, tmp), f); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	dst := blobPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp.Name(), dst)
+}
+
+// blobExists 判斷備份庫中是否已有 Hash 對應的內容
+func blobExists(hash string) bool {
+	_, err := os.Stat(blobPath(hash))
+	return err == nil
+}
+
+// blobPath 返回 Hash 對應的備份檔案路徑 (以前兩碼分目錄)
+func blobPath(hash string) string {
+	return filepath.Join(backupDir, "blobs", hash[:2], hash)
+}
@@ -0,0 +1,237 @@
+package workspace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// MaxDiffBytes 是儲存在執行記錄上的 Diff 最大長度，超過的部分會被截斷
+const MaxDiffBytes = 1 << 20
+
+// DiffTruncatedMarker 是 Diff 被截斷時附加在結尾的標記
+const DiffTruncatedMarker = "\n... [diff truncated] ...\n"
+
+// GitState 描述某一時間點的 Git 儲存庫狀態
+type GitState struct {
+	// Head 是 HEAD 指向的 commit (尚無 commit 的儲存庫為空字串)
+	Head string
+	// Tree 是工作目錄 (含未追蹤但未被忽略的檔案) 寫入物件庫後的 tree 物件
+	Tree string
+}
+
+// GitDiff 是兩個 GitState 之間的差異
+type GitDiff struct {
+	// Patch 是 unified diff 內容 (可能被截斷)
+	Patch string
+	// ModifiedFiles 是被修改的檔案 (相對於專案目錄)
+	ModifiedFiles []string
+	// CreatedFiles 是新建立的檔案 (相對於專案目錄)
+	CreatedFiles []string
+	// DeletedFiles 是被刪除的檔案 (相對於專案目錄)
+	DeletedFiles []string
+}
+
+// runGit 在指定目錄執行 git 指令並返回去除結尾空白的標準輸出
+//
+// 參數:
+//   - dir: 執行 git 的工作目錄。
+//   - env: 額外的環境變數 (例如 GIT_INDEX_FILE)，可為 nil。
+//   - args: git 子指令與參數。
+func runGit(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// IsGitRepo 判斷目錄是否位於 Git 工作目錄中
+func IsGitRepo(dir string) bool {
+	out, err := runGit(dir, nil, "rev-parse", "--is-inside-work-tree")
+	return err == nil && out == "true"
+}
+
+// CaptureGitState 擷取目錄目前的 HEAD 與工作目錄狀態
+//
+// 參數:
+//   - dir: Git 工作目錄 (可為儲存庫的子目錄)。
+//
+// 返回:
+//   - *GitState: HEAD commit 與工作目錄的 tree 物件。
+//   - error: git 指令失敗時返回錯誤。
+//
+// 說明:
+//   使用暫存的 index 檔 (複製自儲存庫的 index 以沿用 stat 快取) 執行 git add -A 與 git write-tree，
+//   因此不會修改使用者的 index 或工作目錄，也能捕捉尚未 commit 的變更。
+func CaptureGitState(dir string) (*GitState, error) {
+	state := &GitState{}
+	if head, err := runGit(dir, nil, "rev-parse", "--verify", "-q", "HEAD"); err == nil {
+		state.Head = head
+	}
+
+	tmpDir, err := os.MkdirTemp("", "awm-git-index-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpIndex := filepath.Join(tmpDir, "index")
+
+	indexPath, err := runGit(dir, nil, "rev-parse", "--path-format=absolute", "--git-path", "index")
+	if err == nil {
+		if err := copyFile(indexPath, tmpIndex); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	env := []string{"GIT_INDEX_FILE=" + tmpIndex}
+	if _, err := runGit(dir, env, "add", "-A", "--", ":/"); err != nil {
+		return nil, err
+	}
+	tree, err := runGit(dir, env, "write-tree")
+	if err != nil {
+		return nil, err
+	}
+	state.Tree = tree
+	return state, nil
+}
+
+// DiffGitStates 比較兩個 GitState，返回專案目錄內的 unified diff 與變更檔案列表
+//
+// 參數:
+//   - dir: Git 工作目錄 (路徑會相對於此目錄輸出)。
+//   - before: 執行前的狀態。
+//   - after: 執行後的狀態。
+func DiffGitStates(dir string, before, after *GitState) (*GitDiff, error) {
+	// 使用 -z 輸出，避免含非 ASCII 字元 (如中文檔名) 的路徑被加上引號跳脫
+	nameStatus, err := runGit(dir, nil, "diff", "--no-renames", "--relative", "--name-status", "-z", before.Tree, after.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GitDiff{}
+	fields := strings.Split(strings.TrimRight(nameStatus, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		status, path := fields[i], fields[i+1]
+		switch status {
+		case "A":
+			result.CreatedFiles = append(result.CreatedFiles, path)
+		case "D":
+			result.DeletedFiles = append(result.DeletedFiles, path)
+		default:
+			result.ModifiedFiles = append(result.ModifiedFiles, path)
+		}
+	}
+
+	patch, err := runGit(dir, nil, "diff", "--no-renames", "--relative", before.Tree, after.Tree)
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) > MaxDiffBytes {
+		patch = patch[:MaxDiffBytes] + DiffTruncatedMarker
+	}
+	result.Patch = patch
+	return result, nil
+}
+
+// copyFile 將 src 的內容複製到 dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// snapshotIdentity 是建立快照 commit 時使用的作者資訊，避免依賴使用者的 git 設定
+var snapshotIdentity = []string{
+	"GIT_AUTHOR_NAME=Agent Workspace Manager",
+	"GIT_AUTHOR_EMAIL=agent-workspace-manager@localhost",
+	"GIT_COMMITTER_NAME=Agent Workspace Manager",
+	"GIT_COMMITTER_EMAIL=agent-workspace-manager@localhost",
+}
+
+// PinGitState 將 GitState 的 tree 包裝成 commit 並以 ref 保存
+//
+// 參數:
+//   - dir: Git 工作目錄。
+//   - ref: 要建立的 ref 名稱 (例如 refs/agent-workspace/executions/1)。
+//   - state: 要保存的狀態。
+//
+// 說明:
+//   未被任何 ref 參照的 tree 物件可能被 git gc 清除，保存為 ref 後才能在日後還原。
+//   快照 commit 不會出現在任何分支上，也不會改變 HEAD。
+func PinGitState(dir, ref string, state *GitState) error {
+	args := []string{"commit-tree", state.Tree, "-m", "agent-workspace-manager snapshot"}
+	if state.Head != "" {
+		args = append(args, "-p", state.Head)
+	}
+	commit, err := runGit(dir, snapshotIdentity, args...)
+	if err != nil {
+		return err
+	}
+	_, err = runGit(dir, nil, "update-ref", ref, commit)
+	return err
+}
+
+// RestoreGitSnapshot 將指定檔案還原為快照 ref 中的內容
+//
+// 參數:
+//   - dir: Git 工作目錄。
+//   - ref: PinGitState 建立的 ref。
+//   - restorePaths: 需要從快照還原的檔案 (執行中被修改或刪除的檔案，相對於 dir)。
+//   - removePaths: 需要刪除的檔案 (執行中新建立的檔案，相對於 dir)。
+//
+// 說明:
+//   只還原工作目錄 (git restore --worktree)，不修改 index 或 HEAD。
+//   任一路徑經由符號連結跳出 dir 時 (見 SafeJoin) 不做任何變更並返回錯誤。
+func RestoreGitSnapshot(dir, ref string, restorePaths, removePaths []string) error {
+	for _, p := range append(append([]string{}, restorePaths...), removePaths...) {
+		if _, err := SafeJoin(dir, p); err != nil {
+			return err
+		}
+	}
+	if len(restorePaths) > 0 {
+		// 以字面路徑解讀檔名，避免含 : 或萬用字元的檔名被當成 pathspec 語法
+		args := append([]string{"--literal-pathspecs", "restore", "--source=" + ref, "--worktree", "--"}, restorePaths...)
+		if _, err := runGit(dir, nil, args...); err != nil {
+			return err
+		}
+	}
+	return removeFiles(dir, removePaths)
+}
+
+// removeFiles 刪除 dir 內的指定檔案 (檔案不存在時忽略)
+func removeFiles(dir string, paths []string) error {
+	for _, p := range paths {
+		target, err := SafeJoin(dir, p)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
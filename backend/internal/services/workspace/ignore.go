@@ -0,0 +1,85 @@
+package workspace
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultIgnorePatterns 是掃描非 Git 專案時預設略過的路徑 (相依套件、快取等不屬於 Agent 工作成果的檔案)
+var DefaultIgnorePatterns = []string{
+	"node_modules/",
+	"__pycache__/",
+	".venv/",
+	".DS_Store",
+}
+
+// IgnoreList 是掃描 Manifest 時略過的路徑規則
+//
+// 說明:
+//   規則語法為 .gitignore 的子集:
+//   - 不含 / 的規則比對任一層的名稱，例如 *.log、node_modules。
+//   - 含 / 的規則比對相對於專案目錄的完整路徑，例如 build/*.o、/dist (開頭的 / 可省略)。
+//   - 以 / 結尾的規則只比對目錄，目錄被略過時其下所有檔案都會略過。
+//   - 規則使用 path.Match 的萬用字元語法；不合法的規則會被忽略。
+type IgnoreList struct {
+	patterns []ignorePattern
+}
+
+// ignorePattern 是解析後的單一規則
+type ignorePattern struct {
+	pattern  string
+	dirOnly  bool
+	anchored bool
+}
+
+// NewIgnoreList 建立 IgnoreList
+//
+// 參數:
+//   - patterns: 規則列表 (空白行與 # 開頭的註解會被忽略)。
+func NewIgnoreList(patterns ...[]string) *IgnoreList {
+	list := &IgnoreList{}
+	for _, group := range patterns {
+		for _, raw := range group {
+			p := strings.TrimSpace(raw)
+			if p == "" || strings.HasPrefix(p, "#") {
+				continue
+			}
+			var parsed ignorePattern
+			if strings.HasSuffix(p, "/") {
+				parsed.dirOnly = true
+				p = strings.TrimRight(p, "/")
+			}
+			if strings.Contains(p, "/") {
+				parsed.anchored = true
+				p = strings.TrimPrefix(p, "/")
+			}
+			if _, err := path.Match(p, ""); err != nil || p == "" {
+				continue
+			}
+			parsed.pattern = p
+			list.patterns = append(list.patterns, parsed)
+		}
+	}
+	return list
+}
+
+// Match 判斷相對路徑 (以 / 分隔) 是否應略過
+func (l *IgnoreList) Match(rel string, isDir bool) bool {
+	if l == nil {
+		return false
+	}
+	name := path.Base(rel)
+	for _, p := range l.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		target := name
+		if p.anchored {
+			target = rel
+		}
+		if matched, _ := path.Match(p.pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
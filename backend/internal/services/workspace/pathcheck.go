@@ -0,0 +1,100 @@
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckPathSafety 檢查 Agent 觸及的檔案路徑是否仍位於專案目錄內
+//
+// 參數:
+//   - root: 專案目錄 (Agent 的工作目錄)。
+//   - path: Agent 回報或比對得到的檔案路徑 (相對於 root，或絕對路徑)。
+//
+// 返回:
+//   - string: 違規原因；路徑安全時返回空字串。
+//
+// 說明:
+//   除了絕對路徑與 .. 造成的跳脫，也會逐層檢查路徑上的符號連結，
+//   經由指向專案外的符號連結存取檔案同樣視為跳脫 (檔案已刪除時只檢查仍存在的上層目錄)。
+func CheckPathSafety(root, path string) string {
+	if path == "" {
+		return ""
+	}
+	root = filepath.Clean(root)
+
+	rel := filepath.FromSlash(path)
+	if filepath.IsAbs(rel) {
+		r, err := filepath.Rel(root, filepath.Clean(rel))
+		if err != nil || !isWithin(r) {
+			return "absolute path outside the project directory"
+		}
+		rel = r
+	}
+	rel = filepath.Clean(rel)
+	if !isWithin(rel) {
+		return "path escapes the project directory"
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		realRoot = root
+	}
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			// 路徑其餘部分已不存在 (例如被刪除的檔案)，無法再經過符號連結
+			return ""
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := resolveSymlink(current)
+		if err != nil {
+			return fmt.Sprintf("symlink %s cannot be resolved", relativeTo(root, current))
+		}
+		if r, err := filepath.Rel(realRoot, target); err != nil || !isWithin(r) {
+			return fmt.Sprintf("symlink %s points outside the project directory (%s)", relativeTo(root, current), target)
+		}
+	}
+	return ""
+}
+
+// resolveSymlink 取得符號連結最終指向的絕對路徑 (目標不存在時以連結內容推算)
+func resolveSymlink(path string) (string, error) {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		return target, nil
+	}
+	link, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(link) {
+		dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			dir = filepath.Dir(path)
+		}
+		link = filepath.Join(dir, link)
+	}
+	return filepath.Clean(link), nil
+}
+
+// isWithin 判斷清理過的相對路徑是否沒有跳出基準目錄
+func isWithin(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// relativeTo 返回 path 相對於 root 的路徑 (以 / 分隔)
+func relativeTo(root, path string) string {
+	if rel, err := filepath.Rel(root, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
@@ -0,0 +1,156 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrMergeFailed 表示執行分支無法合併回主分支 (衝突或主工作目錄有未提交的變更)
+var ErrMergeFailed = errors.New("failed to merge execution branch")
+
+// worktreeRoot 是建立執行專用 worktree 的根目錄
+var worktreeRoot string
+
+// SetWorktreeRoot 設定建立執行專用 worktree 的根目錄
+//
+// 參數:
+//   - dir: 根目錄，不存在時會自動建立。
+func SetWorktreeRoot(dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return err
+	}
+	worktreeRoot = absDir
+	return nil
+}
+
+// WorktreeRoot 返回 worktree 根目錄 (未設定時使用系統暫存目錄)
+func WorktreeRoot() string {
+	if worktreeRoot == "" {
+		return filepath.Join(os.TempDir(), "agent-workspace-worktrees")
+	}
+	return worktreeRoot
+}
+
+// Worktree 描述為單次執行建立的 git worktree
+type Worktree struct {
+	// Path 是 worktree 的根目錄
+	Path string
+	// WorkDir 是專案目錄在 worktree 中對應的位置 (專案目錄為儲存庫子目錄時不等於 Path)
+	WorkDir string
+	// Branch 是 worktree 專用的分支
+	Branch string
+	// BaseBranch 是建立時主工作目錄所在的分支 (核准後合併的目標)
+	BaseBranch string
+}
+
+// CreateWorktree 從主工作目錄目前的 HEAD 建立新的分支與 worktree
+//
+// 參數:
+//   - dir: 專案目錄 (主工作目錄或其子目錄)。
+//   - path: 要建立的 worktree 路徑。
+//   - branch: 要建立的分支名稱。
+//
+// 說明:
+//   主工作目錄必須位於某個分支上 (非 detached HEAD)，且至少有一個 commit。
+//   尚未提交的變更不會帶入 worktree。
+func CreateWorktree(dir, path, branch string) (*Worktree, error) {
+	baseBranch, err := runGit(dir, nil, "symbolic-ref", "--short", "-q", "HEAD")
+	if err != nil || baseBranch == "" {
+		return nil, fmt.Errorf("project checkout is not on a branch")
+	}
+	if _, err := runGit(dir, nil, "rev-parse", "--verify", "-q", "HEAD"); err != nil {
+		return nil, fmt.Errorf("project repository has no commits")
+	}
+	prefix, err := runGit(dir, nil, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if _, err := runGit(dir, nil, "worktree", "add", "-q", "-b", branch, path, "HEAD"); err != nil {
+		return nil, err
+	}
+	return &Worktree{
+		Path:       path,
+		WorkDir:    filepath.Join(path, filepath.FromSlash(prefix)),
+		Branch:     branch,
+		BaseBranch: baseBranch,
+	}, nil
+}
+
+// CommitWorktree 將 worktree 中的所有變更提交到其分支
+//
+// 參數:
+//   - path: worktree 路徑。
+//   - message: commit 訊息。
+//
+// 返回:
+//   - string: 提交後分支的 HEAD (沒有變更時不建立 commit，直接返回目前的 HEAD)。
+func CommitWorktree(path, message string) (string, error) {
+	if _, err := runGit(path, nil, "add", "-A", "--", ":/"); err != nil {
+		return "", err
+	}
+	if _, err := runGit(path, nil, "diff", "--cached", "--quiet"); err != nil {
+		if _, err := runGit(path, snapshotIdentity, "commit", "-q", "--no-verify", "-m", message); err != nil {
+			return "", err
+		}
+	}
+	return runGit(path, nil, "rev-parse", "HEAD")
+}
+
+// MergeWorktreeBranch 將執行分支合併回主工作目錄的分支
+//
+// 參數:
+//   - dir: 專案目錄 (主工作目錄或其子目錄)。
+//   - baseBranch: 預期主工作目錄所在的分支。
+//   - branch: 要合併的執行分支。
+//   - message: 無法 fast-forward 時 merge commit 的訊息。
+//
+// 說明:
+//   優先使用 fast-forward；分支已分岔時建立 merge commit。
+//   發生衝突時會中止合併並返回 ErrMergeFailed，主工作目錄保持原狀。
+func MergeWorktreeBranch(dir, baseBranch, branch, message string) error {
+	current, err := runGit(dir, nil, "symbolic-ref", "--short", "-q", "HEAD")
+	if err != nil || current != baseBranch {
+		return fmt.Errorf("%w: project checkout is on %q, expected %q", ErrMergeFailed, current, baseBranch)
+	}
+	if _, err := runGit(dir, nil, "merge", "-q", "--ff-only", branch); err == nil {
+		return nil
+	}
+	if _, err := runGit(dir, snapshotIdentity, "merge", "-q", "--no-ff", "--no-verify", "-m", message, branch); err != nil {
+		runGit(dir, nil, "merge", "--abort")
+		return fmt.Errorf("%w: %v", ErrMergeFailed, err)
+	}
+	return nil
+}
+
+// RemoveWorktree 刪除 worktree 與其分支
+//
+// 參數:
+//   - dir: 專案目錄 (主工作目錄或其子目錄)。
+//   - path: worktree 路徑。
+//   - branch: worktree 專用的分支。
+func RemoveWorktree(dir, path, branch string) error {
+	if _, err := os.Stat(path); err == nil {
+		if _, err := runGit(dir, nil, "worktree", "remove", "--force", path); err != nil {
+			return err
+		}
+	} else {
+		// worktree 目錄已被手動刪除，清理殘留的 worktree 記錄
+		runGit(dir, nil, "worktree", "prune")
+	}
+	if _, err := runGit(dir, nil, "rev-parse", "--verify", "-q", "refs/heads/"+branch); err == nil {
+		if _, err := runGit(dir, nil, "branch", "-D", branch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
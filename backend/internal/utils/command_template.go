@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"agent-workspace-manager/internal/models"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 定義 AI CLI 指令模版支援的佔位符
+const (
+	PlaceholderPrompt      = "prompt"       // 完整的提示詞內容
+	PlaceholderPromptFile  = "prompt_file"  // 寫有提示詞內容的暫存檔路徑
+	PlaceholderProjectDir  = "project_dir"  // Agent 執行的工作目錄
+	PlaceholderExecutionID = "execution_id" // 執行記錄 ID
+	PlaceholderModel       = "model"        // 專案設定的模型名稱
+)
+
+// knownPlaceholders 是所有合法的佔位符
+var knownPlaceholders = map[string]bool{
+	PlaceholderPrompt:      true,
+	PlaceholderPromptFile:  true,
+	PlaceholderProjectDir:  true,
+	PlaceholderExecutionID: true,
+	PlaceholderModel:       true,
+}
+
+// placeholderPattern 比對 {name} 形式的佔位符 (名稱只含小寫英文與底線)
+// 單引號內與以 \ 跳脫的字元不會被比對，JSON 等含大括號的參數可用單引號包住 (見 splitArgs)。
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// ErrEmptyCommandTemplate 表示指令模版為空
+var ErrEmptyCommandTemplate = errors.New("command template is empty")
+
+// CommandTemplate 是解析後的 AI CLI 指令模版
+type CommandTemplate struct {
+	// tokens 是切分後的參數 (第一個為執行檔)，佔位符尚未替換
+	tokens []argToken
+	// used 記錄模版中出現的佔位符
+	used map[string]bool
+}
+
+// ParseCommandTemplate 解析 AI CLI 指令模版
+//
+// 參數:
+//   - template: 指令模版，例如 `claude -p {prompt} --model "{model}"`。
+//
+// 返回:
+//   - *CommandTemplate: 解析後的模版。
+//   - error: 模版為空、引號未閉合、結尾為跳脫字元、使用未知佔位符或執行檔包含佔位符時返回錯誤。
+//
+// 語法:
+//   - 以空白分隔參數；單引號內的內容原樣保留，雙引號內可使用 \" 與 \\ 跳脫，引號外可使用 \ 跳脫任意字元。
+//   - 佔位符 (例如 {prompt}) 可單獨成為參數，也可以嵌在參數中 (例如 --prompt-file={prompt_file})，
+//     在雙引號內同樣會被替換。
+//   - 單引號內的內容與以 \ 跳脫的字元不會被視為佔位符，
+//     例如 '{"a":{b}}' 與 --format=\{json} 會原樣傳入 {"a":{b}} 與 --format={json}。
+func ParseCommandTemplate(template string) (*CommandTemplate, error) {
+	tokens, err := splitArgs(template)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, ErrEmptyCommandTemplate
+	}
+
+	used := make(map[string]bool)
+	for i, token := range tokens {
+		for _, name := range token.placeholders() {
+			if !knownPlaceholders[name] {
+				return nil, fmt.Errorf("unknown placeholder {%s}", name)
+			}
+			if i == 0 {
+				return nil, fmt.Errorf("executable must not contain placeholder {%s}", name)
+			}
+			used[name] = true
+		}
+	}
+	return &CommandTemplate{tokens: tokens, used: used}, nil
+}
+
+// Uses 判斷模版是否使用指定的佔位符
+func (t *CommandTemplate) Uses(name string) bool {
+	return t.used[name]
+}
+
+// ValidatePromptDelivery 檢查模版與提示詞傳遞方式是否相容
+//
+// 參數:
+//   - mode: 提示詞傳遞方式 (models.PromptDeliveryArgv/Stdin/File，空字串視為 argv)。
+//
+// 說明:
+//   stdin 與 file 模式是為了避免提示詞出現在命令列，因此不允許使用 {prompt}；
+//   file 模式必須以 {prompt_file} 指定暫存檔路徑的位置。
+func (t *CommandTemplate) ValidatePromptDelivery(mode string) error {
+	switch mode {
+	case "", models.PromptDeliveryArgv:
+		return nil
+	case models.PromptDeliveryStdin, models.PromptDeliveryFile:
+		if t.Uses(PlaceholderPrompt) {
+			return fmt.Errorf("{prompt} cannot be used with %s prompt delivery", mode)
+		}
+		if mode == models.PromptDeliveryFile && !t.Uses(PlaceholderPromptFile) {
+			return errors.New("file prompt delivery requires a {prompt_file} placeholder")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown prompt delivery mode %q", mode)
+	}
+}
+
+// Render 以實際值替換佔位符，返回執行檔與參數
+//
+// 說明:
+//   指令直接以 exec 執行而不經過 Shell，替換後的值 (例如提示詞) 永遠只屬於單一參數，
+//   其中的空白、引號或 Shell 特殊字元都不會被解讀。
+func (t *CommandTemplate) Render(values map[string]string) (string, []string) {
+	args := make([]string, 0, len(t.tokens)-1)
+	for _, token := range t.tokens[1:] {
+		args = append(args, token.render(values))
+	}
+	return t.tokens[0].render(nil), args
+}
+
+// argPart 是參數中的一段文字
+type argPart struct {
+	text string
+	// literal 為 true 時 (單引號內或以 \ 跳脫) 不替換佔位符
+	literal bool
+}
+
+// argToken 是切分後的單一參數，由可替換與原樣保留的片段組成
+type argToken []argPart
+
+// placeholders 返回參數中可替換片段內的佔位符名稱
+func (t argToken) placeholders() []string {
+	var names []string
+	for _, part := range t {
+		if part.literal {
+			continue
+		}
+		for _, match := range placeholderPattern.FindAllStringSubmatch(part.text, -1) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// render 以 values 替換可替換片段內的佔位符，並組合成參數
+func (t argToken) render(values map[string]string) string {
+	var b strings.Builder
+	for _, part := range t {
+		if part.literal {
+			b.WriteString(part.text)
+			continue
+		}
+		b.WriteString(placeholderPattern.ReplaceAllStringFunc(part.text, func(match string) string {
+			return values[match[1:len(match)-1]]
+		}))
+	}
+	return b.String()
+}
+
+// splitArgs 依類 Shell 的規則切分參數 (不支援變數展開、萬用字元等 Shell 功能)
+//
+// 說明:
+//   如同 Shell 中單引號內不展開變數，單引號內的內容與以 \ 跳脫的字元會標記為原樣保留的片段，
+//   不會被當成佔位符。
+func splitArgs(s string) ([]argToken, error) {
+	var tokens []argToken
+	var token argToken
+	var current strings.Builder
+	inToken := false
+	// flush 將目前累積的可替換文字加入參數
+	flush := func() {
+		if current.Len() > 0 {
+			token = append(token, argPart{text: current.String()})
+			current.Reset()
+		}
+	}
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\'':
+			inToken = true
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			flush()
+			token = append(token, argPart{text: string(runes[i+1 : end]), literal: true})
+			i = end
+		case r == '"':
+			inToken = true
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+					i++
+				}
+				current.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unterminated double quote")
+			}
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, errors.New("trailing backslash")
+			}
+			inToken = true
+			i++
+			flush()
+			token = append(token, argPart{text: string(runes[i]), literal: true})
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inToken {
+				flush()
+				tokens = append(tokens, token)
+				token = nil
+				inToken = false
+			}
+		default:
+			inToken = true
+			current.WriteRune(r)
+		}
+	}
+	if inToken {
+		flush()
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// indexRune 從 start 開始尋找 r 的位置，找不到時返回 -1
+func indexRune(runes []rune, start int, r rune) int {
+	for i := start; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// PlaceholderSessionID 是續接參數模版中 AI CLI 工作階段 ID 的佔位符 (只能用於 ResumeTemplate)
+const PlaceholderSessionID = "session_id"
+
+// ResumeTemplate 是解析後的續接參數模版，用於讓 AI CLI 續接先前的對話 (例如 `--resume {session_id}`)
+type ResumeTemplate struct {
+	tokens []argToken
+}
+
+// ParseResumeTemplate 解析續接參數模版
+//
+// 參數:
+//   - template: 續接參數模版，語法與指令模版相同但不含執行檔，例如 `--resume {session_id}`。
+//
+// 返回:
+//   - *ResumeTemplate: 解析後的模版。
+//   - error: 引號未閉合、未使用 {session_id} 或使用其他佔位符時返回錯誤。
+func ParseResumeTemplate(template string) (*ResumeTemplate, error) {
+	tokens, err := splitArgs(template)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, token := range tokens {
+		for _, name := range token.placeholders() {
+			if name != PlaceholderSessionID {
+				return nil, fmt.Errorf("unknown placeholder {%s} in resume arguments", name)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("resume arguments require a {%s} placeholder", PlaceholderSessionID)
+	}
+	return &ResumeTemplate{tokens: tokens}, nil
+}
+
+// Render 以 AI CLI 工作階段 ID 替換佔位符，返回續接參數
+func (t *ResumeTemplate) Render(sessionID string) []string {
+	args := make([]string, 0, len(t.tokens))
+	values := map[string]string{PlaceholderSessionID: sessionID}
+	for _, token := range t.tokens {
+		args = append(args, token.render(values))
+	}
+	return args
+}
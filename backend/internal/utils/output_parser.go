@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"agent-workspace-manager/internal/models"
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -11,47 +14,254 @@ import (
 type ParsedOutput struct {
 	Status        string   `json:"status"`
 	Summary       string   `json:"summary"`
-	ModifiedFiles []string `json:"modified_files"`
-	CreatedFiles  []string `json:"created_files"`
-	DeletedFiles  []string `json:"deleted_files"`
+	ModifiedFiles []string `json:"modified_files,omitempty"`
+	CreatedFiles  []string `json:"created_files,omitempty"`
+	DeletedFiles  []string `json:"deleted_files,omitempty"`
+	MemoryUpdates []string `json:"memory_updates,omitempty"`
+	// SessionID 是 AI CLI 回報的工作階段 ID (用於續接對話，CLI 未提供時為空)
+	SessionID string `json:"session_id,omitempty"`
 }
 
-// ParseOutput 解析 AI Agent 的 JSON 輸出
-// 支援從混合文字中提取 JSON 區塊
-func ParseOutput(output string) (*ParsedOutput, error) {
-	// 1. 嘗試直接解析 (如果整個輸出就是 JSON)
+// OutputStatusFailed 是 Agent 回報任務失敗時的 status 值
+const OutputStatusFailed = "failed"
+
+// Failed 判斷 Agent 是否回報任務失敗
+func (o *ParsedOutput) Failed() bool {
+	return o.Status == OutputStatusFailed
+}
+
+// HasFileChanges 判斷 Agent 是否回報了任何檔案變更
+func (o *ParsedOutput) HasFileChanges() bool {
+	return len(o.ModifiedFiles) > 0 || len(o.CreatedFiles) > 0 || len(o.DeletedFiles) > 0
+}
+
+// OutputParser 解析 AI CLI 的輸出
+// 不同的 AI CLI 輸出格式不同，專案或 AgentProfile 可選擇適合的實作。
+type OutputParser interface {
+	// Name 返回解析器名稱 (記錄於執行記錄)
+	Name() string
+	// Parse 解析完整的輸出，找不到結果時返回錯誤
+	Parse(output string) (*ParsedOutput, error)
+}
+
+// outputParsers 是所有可選擇的解析器，鍵為解析器名稱
+var outputParsers = map[string]OutputParser{
+	models.OutputParserJSON:       jsonContractParser{},
+	models.OutputParserFencedJSON: fencedJSONParser{},
+	models.OutputParserJSONLines:  jsonLinesParser{},
+	models.OutputParserText:       plainTextParser{},
+}
+
+// GetOutputParser 依名稱取得解析器
+//
+// 參數:
+//   - name: 解析器名稱 (models.OutputParserJSON 等，空字串視為 json)。
+//
+// 返回:
+//   - OutputParser: 對應的解析器。
+//   - error: 名稱未知時返回錯誤。
+func GetOutputParser(name string) (OutputParser, error) {
+	if name == "" {
+		name = models.OutputParserJSON
+	}
+	parser, ok := outputParsers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown output parser %q", name)
+	}
+	return parser, nil
+}
+
+// contractKeys 是 JSON 結果中至少需出現一個的欄位，用於區分結果與日誌中的其他 JSON
+var contractKeys = []string{"status", "summary", "modified_files", "created_files", "deleted_files"}
+
+// decodeContract 將 JSON 物件解析為 ParsedOutput
+// 物件不含任何 contractKeys 欄位時返回 false。
+func decodeContract(data []byte) (*ParsedOutput, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, false
+	}
+	found := false
+	for _, key := range contractKeys {
+		if _, ok := fields[key]; ok {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, false
+	}
 	var result ParsedOutput
-	if err := json.Unmarshal([]byte(output), &result); err == nil {
-		return &result, nil
-	}
-
-	// 2. 使用正規表達式尋找 JSON 區塊
-	// 尋找以 { 開頭，以 } 結尾的區塊，並嘗試解析
-	// (?s) 開啟 dot-matches-newline 模式
-	re := regexp.MustCompile(`(?s)\{.*\}`)
-	matches := re.FindAllString(output, -1)
-
-	if len(matches) == 0 {
-		return nil, fmt.Errorf("no JSON block found in output")
-	}
-
-	// 取最後一個匹配的區塊 (假設 AI 可能會輸出多個 JSON，最後一個通常是最終結果)
-	lastMatch := matches[len(matches)-1]
-	
-	// 嘗試解析提取出的 JSON
-	if err := json.Unmarshal([]byte(lastMatch), &result); err != nil {
-		// 如果直接解析失敗，可能是因為包含了非 JSON 的前後綴 (雖然 regex 已經盡量匹配)
-		// 這裡可以做更進一步的清理，例如尋找最外層的 {}
-		start := strings.Index(lastMatch, "{")
-		end := strings.LastIndex(lastMatch, "}")
-		if start != -1 && end != -1 && end > start {
-			jsonPart := lastMatch[start : end+1]
-			if err := json.Unmarshal([]byte(jsonPart), &result); err == nil {
-				return &result, nil
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, false
+	}
+	return &result, true
+}
+
+// lastContractObject 在文字中尋找最後一個符合 JSON 結果格式的物件
+//
+// 說明:
+//   從每個 { 開始以 json.Decoder 讀取一個完整的 JSON 值，成功時跳過整個物件 (不會把巢狀物件當成候選)，
+//   失敗時從下一個 { 繼續；日誌中不成對或不是 JSON 的大括號因此不會影響結果。
+func lastContractObject(text string) (*ParsedOutput, bool) {
+	var last *ParsedOutput
+	for i := 0; i < len(text); {
+		start := strings.IndexByte(text[i:], '{')
+		if start < 0 {
+			break
+		}
+		start += i
+
+		var raw json.RawMessage
+		decoder := json.NewDecoder(strings.NewReader(text[start:]))
+		if err := decoder.Decode(&raw); err != nil {
+			i = start + 1
+			continue
+		}
+		if result, ok := decodeContract(raw); ok {
+			last = result
+		}
+		i = start + int(decoder.InputOffset())
+	}
+	return last, last != nil
+}
+
+// jsonContractParser 解析系統提示詞要求的 JSON 結果 (可夾雜在其他輸出之間)
+// 有多個結果時取最後一個。
+type jsonContractParser struct{}
+
+// Name 實作 OutputParser 介面
+func (jsonContractParser) Name() string { return models.OutputParserJSON }
+
+// Parse 實作 OutputParser 介面
+func (jsonContractParser) Parse(output string) (*ParsedOutput, error) {
+	if result, ok := lastContractObject(output); ok {
+		return result, nil
+	}
+	return nil, errors.New("no JSON result found in output")
+}
+
+// fencedBlockPattern 比對 Markdown 的 ```json 程式碼區塊
+var fencedBlockPattern = regexp.MustCompile("(?s)```json[ \t]*\r?\n(.*?)```")
+
+// fencedJSONParser 解析 Markdown ```json 程式碼區塊中的 JSON 結果
+// 適用於以 Markdown 回覆的 AI CLI；有多個區塊時取最後一個符合格式的區塊。
+type fencedJSONParser struct{}
+
+// Name 實作 OutputParser 介面
+func (fencedJSONParser) Name() string { return models.OutputParserFencedJSON }
+
+// Parse 實作 OutputParser 介面
+func (fencedJSONParser) Parse(output string) (*ParsedOutput, error) {
+	blocks := fencedBlockPattern.FindAllStringSubmatch(output, -1)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if result, ok := decodeContract([]byte(blocks[i][1])); ok {
+			return result, nil
+		}
+	}
+	return nil, errors.New("no ```json block with a result found in output")
+}
+
+// jsonLinesParser 解析每行一個 JSON 事件的串流輸出 (例如 --output-format stream-json)
+//
+// 說明:
+//   - type 為 result 的事件 (AI CLI 的最終結果) 優先：其 result 文字中若含 JSON 結果則使用該結果，
+//     否則以整段文字作為摘要 (過長時保留結尾)；is_error 為 true 時狀態為 failed。
+//   - 沒有 result 事件時，使用最後一個本身即為 JSON 結果的事件。
+//   - 事件中的 session_id (最後一個非空值) 作為 AI CLI 的工作階段 ID。
+//   - 不是 JSON 物件的行會被略過。
+type jsonLinesParser struct{}
+
+// Name 實作 OutputParser 介面
+func (jsonLinesParser) Name() string { return models.OutputParserJSONLines }
+
+// Parse 實作 OutputParser 介面
+func (jsonLinesParser) Parse(output string) (*ParsedOutput, error) {
+	var resultEvent, contract *ParsedOutput
+	var sessionID string
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), len(output)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var event struct {
+			Type      string `json:"type"`
+			Subtype   string `json:"subtype"`
+			Result    string `json:"result"`
+			IsError   bool   `json:"is_error"`
+			SessionID string `json:"session_id"`
+		}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			continue
+		}
+		if event.SessionID != "" {
+			sessionID = event.SessionID
+		}
+		if event.Type == "result" {
+			parsed, ok := lastContractObject(event.Result)
+			if !ok {
+				parsed = &ParsedOutput{Status: "success", Summary: textSummary(event.Result)}
+			}
+			if event.IsError || (event.Subtype != "" && event.Subtype != "success") {
+				parsed.Status = OutputStatusFailed
 			}
+			resultEvent = parsed
+			continue
+		}
+		if parsed, ok := decodeContract([]byte(line)); ok {
+			contract = parsed
+		}
+	}
+
+	result := resultEvent
+	if result == nil {
+		result = contract
+	}
+	if result == nil {
+		return nil, errors.New("no result event found in JSON lines output")
+	}
+	if result.SessionID == "" {
+		result.SessionID = sessionID
+	}
+	return result, nil
+}
+
+// PlainTextSummaryLines 是純文字解析器作為摘要的最後幾行 (不含空白行)
+const PlainTextSummaryLines = 20
+
+// plainTextParser 以輸出的最後幾行作為摘要 (不超過 MaxSummaryLength)，永遠不會失敗
+// 適用於沒有結構化輸出的 AI CLI；檔案變更列表由 Git 或檔案比對取得。
+type plainTextParser struct{}
+
+// Name 實作 OutputParser 介面
+func (plainTextParser) Name() string { return models.OutputParserText }
+
+// Parse 實作 OutputParser 介面
+func (plainTextParser) Parse(output string) (*ParsedOutput, error) {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimRight(line, " \t\r"); line != "" {
+			lines = append(lines, line)
 		}
-		return nil, fmt.Errorf("failed to parse extracted JSON: %v", err)
 	}
+	if len(lines) > PlainTextSummaryLines {
+		lines = lines[len(lines)-PlainTextSummaryLines:]
+	}
+	return &ParsedOutput{Status: "success", Summary: textSummary(strings.Join(lines, "\n"))}, nil
+}
 
-	return &result, nil
+// textSummary 將純文字轉為符合 OutputSchema 的摘要
+// 超過 MaxSummaryLength 時保留結尾，沒有內容時返回 (no output)。
+func textSummary(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "(no output)"
+	}
+	if runes := []rune(text); len(runes) > MaxSummaryLength {
+		text = string(runes[len(runes)-MaxSummaryLength:])
+	}
+	return text
 }
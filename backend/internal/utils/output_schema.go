@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxSummaryLength 是 JSON 結果中 summary 的長度上限 (字元數)
+const MaxSummaryLength = 500
+
+// MaxMemoryNoteLength 是單筆專案記憶 (memory_updates 的項目或手動新增的筆記) 的長度上限 (字元數)
+const MaxMemoryNoteLength = 500
+
+// relativePathFormat 是自訂的 JSON Schema format：相對於工作目錄且不跳出工作目錄的路徑
+const relativePathFormat = "relative-path"
+
+// filesSchema 是檔案列表欄位的 Schema
+var filesSchema = map[string]any{
+	"type":  "array",
+	"items": map[string]any{"type": "string", "minLength": 1, "format": relativePathFormat},
+}
+
+// OutputSchema 是 Agent JSON 結果的 JSON Schema (由 ParsedOutput 推導)
+// 輸出修復回合會將此 Schema 提供給 Agent。
+var OutputSchema = map[string]any{
+	"$schema":  "https://json-schema.org/draft/2020-12/schema",
+	"type":     "object",
+	"required": []any{"status", "summary"},
+	"properties": map[string]any{
+		"status":         map[string]any{"type": "string", "enum": []any{"success", "failed"}},
+		"summary":        map[string]any{"type": "string", "minLength": 1, "maxLength": MaxSummaryLength},
+		"modified_files": filesSchema,
+		"created_files":  filesSchema,
+		"deleted_files":  filesSchema,
+		"memory_updates": map[string]any{
+			"type":  "array",
+			"items": map[string]any{"type": "string", "minLength": 1, "maxLength": MaxMemoryNoteLength},
+		},
+		"session_id": map[string]any{"type": "string", "minLength": 1},
+	},
+}
+
+// OutputSchemaJSON 返回格式化後的 OutputSchema
+func OutputSchemaJSON() string {
+	data, _ := json.MarshalIndent(OutputSchema, "", "  ")
+	return string(data)
+}
+
+// OutputValidationError 表示解析結果不符合 OutputSchema
+type OutputValidationError struct {
+	// Problems 是所有不符合的欄位與原因
+	Problems []string
+}
+
+// Error 實作 error 介面
+func (e *OutputValidationError) Error() string {
+	return "output does not match schema: " + strings.Join(e.Problems, "; ")
+}
+
+// ValidateOutput 以 OutputSchema 驗證解析結果
+//
+// 返回:
+//   - error: 不符合時返回 *OutputValidationError，列出所有問題。
+func ValidateOutput(output *ParsedOutput) error {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if problems := validateSchema(OutputSchema, value, ""); len(problems) > 0 {
+		return &OutputValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateSchema 以 JSON Schema 驗證值，返回所有問題
+//
+// 說明:
+//   只支援 OutputSchema 使用到的關鍵字：type、enum、required、properties、items、minLength、maxLength 與 format。
+func validateSchema(schema map[string]any, value any, path string) []string {
+	name := path
+	if name == "" {
+		name = "output"
+	}
+	var problems []string
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: must be an object", name)}
+		}
+		if required, ok := schema["required"].([]any); ok {
+			for _, key := range required {
+				if _, ok := object[key.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: is required", key))
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		keys := make([]string, 0, len(properties))
+		for key := range properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if v, ok := object[key]; ok {
+				problems = append(problems, validateSchema(properties[key].(map[string]any), v, key)...)
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: must be an array", name)}
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range array {
+				problems = append(problems, validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: must be a string", name)}
+		}
+		if enum, ok := schema["enum"].([]any); ok && !containsValue(enum, s) {
+			problems = append(problems, fmt.Sprintf("%s: must be one of %s", name, joinValues(enum)))
+		}
+		length := utf8.RuneCountInString(s)
+		if min, ok := schema["minLength"].(int); ok && length < min {
+			problems = append(problems, fmt.Sprintf("%s: must not be empty", name))
+		}
+		if max, ok := schema["maxLength"].(int); ok && length > max {
+			problems = append(problems, fmt.Sprintf("%s: must be at most %d characters (got %d)", name, max, length))
+		}
+		if schema["format"] == relativePathFormat && s != "" && !isRelativePath(s) {
+			problems = append(problems, fmt.Sprintf("%s: %q must be a relative path inside the working directory", name, s))
+		}
+	}
+	return problems
+}
+
+// isRelativePath 判斷路徑是否為不跳出工作目錄的相對路徑 (不是絕對路徑且不含 .. 區段)
+func isRelativePath(p string) bool {
+	normalized := strings.ReplaceAll(p, "\\", "/")
+	if strings.HasPrefix(normalized, "/") || (len(normalized) >= 2 && normalized[1] == ':') {
+		return false
+	}
+	for _, segment := range strings.Split(normalized, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// containsValue 判斷 enum 是否包含字串 s
+func containsValue(enum []any, s string) bool {
+	for _, v := range enum {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// joinValues 將 enum 的值以逗號連接
+func joinValues(enum []any) string {
+	values := make([]string, len(enum))
+	for i, v := range enum {
+		values[i] = fmt.Sprint(v)
+	}
+	return strings.Join(values, ", ")
+}
@@ -3,7 +3,10 @@ package utils
 import (
 	"agent-workspace-manager/internal/models"
 	"fmt"
+	"io"
 	"strings"
+	"text/template"
+	"unicode/utf8"
 )
 
 // SystemInstructions 定義了給 AI 模型的系統提示詞，包含格式要求與安全規範
@@ -31,29 +34,199 @@ const SystemInstructions = `
 
 `
 
-// BuildPrompt 建構完整的 Prompt 內容 (不包含 CLI 指令部分)
-// userPrompt: 使用者輸入的提示詞
-// history: 最近的執行記錄
-// project: 專案資訊
-func BuildPrompt(userPrompt string, history []models.Execution, project models.Project) string {
-	// 建構歷史記錄字串
-	var historyBuilder strings.Builder
-	if len(history) > 0 {
-		var no int = 1
-		historyBuilder.WriteString("過去執行歷史 (Context)】\n")
-		// 反轉順序，讓舊的在前，新的在後，符合閱讀邏輯 (假設傳入的是倒序)
-		for i := len(history) - 1; i >= 0; i-- {
-			exec := history[i]
-			historyBuilder.WriteString(fmt.Sprintf("- No. %d \n\t- 時間: %s\n\t- 指令: %s\n\t- 結果: %s\n\t- 摘要: %s\n",no,exec.StartTime,exec.Command, exec.Status, exec.Summary))
-			no++
+// DefaultPromptTemplate 是預設的提示詞模版 (Go text/template 語法)，輸出與過去固定的提示詞格式相同
+// 可使用的資料見 PromptData；History、專案記憶或 Session 為空時不輸出對應區塊，錯誤訊息與變更檔案只在專案設定包含時輸出。
+const DefaultPromptTemplate = SystemInstructions + `
+{{if .History}}過去執行歷史 (Context)】
+{{range $i, $e := .History}}- No. {{add $i 1}} 
+	- 時間: {{$e.StartTime}}
+	- 指令: {{$e.Command}}
+	- 結果: {{$e.Status}}
+	- 摘要: {{$e.Summary}}
+{{if $e.Error}}	- 錯誤: {{$e.Error}}
+{{end}}{{if $e.Files}}	- 變更檔案: {{join $e.Files ", "}}
+{{end}}{{end}}{{end}}{{if .Project.Memory}}
+【專案記憶】
+{{trim .Project.Memory}}
+{{end}}{{if .Project.MemoryAutoUpdate}}
+若本次任務中得到之後的任務也需要知道的專案知識 (慣例、決策、注意事項)，請在 JSON 結果中加入 "memory_updates": ["一句話描述"]；只記錄新的、長期有效的資訊。
+{{end}}
+【專案資訊】
+- 專案名稱: {{.Project.Name}}
+- 工作目錄: {{.Project.DirectoryPath}}
+{{if .Session}}
+【對話延續】
+此任務延續同一個工作階段，以下是先前的對話 (由舊到新)，請在其基礎上繼續：
+{{range $i, $t := .Session}}- 第 {{add $i 1}} 輪
+	- 指令: {{$t.Command}}
+	- 結果: {{$t.Status}}
+	- 摘要: {{$t.Summary}}
+{{if $t.Details}}	- 輸出:
+{{$t.Details}}
+{{end}}{{end}}{{end}}
+【任務內容】
+{{.UserInput}}`
+
+// PromptProject 是提示詞模版中可使用的專案資訊
+// 不包含環境變數的值與 AgentProfile (可能含有機密)，避免模版將機密寫入提示詞或命令列參數。
+type PromptProject struct {
+	ID               uint
+	Name             string
+	Description      string
+	DirectoryPath    string
+	ModelName        string
+	Memory           string
+	MemoryAutoUpdate bool
+	// EnvVarNames 是專案環境變數的名稱 (不含值)
+	EnvVarNames []string
+}
+
+// NewPromptProject 從專案建立提示詞模版中可使用的專案資訊
+func NewPromptProject(project models.Project) PromptProject {
+	return PromptProject{
+		ID:               project.ID,
+		Name:             project.Name,
+		Description:      project.Description,
+		DirectoryPath:    project.DirectoryPath,
+		ModelName:        project.ModelName,
+		Memory:           project.Memory,
+		MemoryAutoUpdate: project.MemoryAutoUpdate,
+		EnvVarNames:      project.EnvVarNames,
+	}
+}
+
+// PromptData 是渲染提示詞模版時可使用的資料
+type PromptData struct {
+	// Project 是執行的專案 (不含機密的欄位，見 PromptProject)
+	Project PromptProject
+	// History 是作為 Context 的過去執行記錄 (由舊到新)
+	History []HistoryItem
+	// Session 是同一個對話工作階段中先前的執行記錄 (由舊到新，Details 只保留結尾；不屬於工作階段時為空)
+	Session []models.Execution
+	// Execution 是此次執行記錄 (預覽時 ID 為 0)
+	Execution models.Execution
+	// UserInput 是使用者輸入的指令或提示詞
+	UserInput string
+}
+
+// HistoryItem 是提示詞中的一筆過去執行記錄 (可直接存取 models.Execution 的欄位，例如 .Summary)
+type HistoryItem struct {
+	models.Execution
+	// Error 是失敗執行的錯誤訊息 (專案設定包含失敗的執行時才有值)
+	Error string
+	// Files 是變更的檔案，格式為 "M 路徑"、"A 路徑"、"D 路徑" (專案設定包含變更檔案時才有值)
+	Files []string
+}
+
+// NewHistoryItem 建立提示詞中的過去執行記錄
+//
+// 參數:
+//   - execution: 過去的執行記錄。
+//   - includeFiles: 是否包含變更檔案列表。
+func NewHistoryItem(execution models.Execution, includeFiles bool) HistoryItem {
+	item := HistoryItem{Execution: execution}
+	if execution.IsFailure() {
+		item.Error = execution.ErrorMessage
+	}
+	if includeFiles {
+		for _, f := range execution.ModifiedFiles {
+			item.Files = append(item.Files, "M "+f)
+		}
+		for _, f := range execution.CreatedFiles {
+			item.Files = append(item.Files, "A "+f)
+		}
+		for _, f := range execution.DeletedFiles {
+			item.Files = append(item.Files, "D "+f)
+		}
+	}
+	return item
+}
+
+// Size 返回此記錄在提示詞中的大約字元數 (用於字元數預算)
+func (h HistoryItem) Size() int {
+	size := utf8.RuneCountInString(h.Command) + utf8.RuneCountInString(h.Summary) + utf8.RuneCountInString(h.Error)
+	for _, f := range h.Files {
+		size += utf8.RuneCountInString(f)
+	}
+	return size
+}
+
+// promptFuncs 是提示詞模版可使用的函式
+var promptFuncs = template.FuncMap{
+	"add":   func(a, b int) int { return a + b },
+	"join":  strings.Join,
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// ParsePromptTemplate 解析提示詞模版
+//
+// 返回:
+//   - error: 語法錯誤，或以範例資料渲染時存取不存在的欄位等錯誤。
+func ParsePromptTemplate(content string) (*template.Template, error) {
+	tmpl, err := template.New("prompt").Funcs(promptFuncs).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	// 以範例資料試渲染一次，提早發現欄位名稱錯誤 (例如 {{.Projct.Name}})
+	sample := PromptData{
+		Project:   PromptProject{Name: "example"},
+		History:   []HistoryItem{{Execution: models.Execution{Command: "example", Status: models.StatusCompleted}, Files: []string{"M example.txt"}}},
+		Session:   []models.Execution{{Command: "example", Status: models.StatusCompleted, Details: "example"}},
+		Execution: models.Execution{Command: "example"},
+		UserInput: "example",
+	}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// RenderPrompt 以提示詞模版建構完整的 Prompt 內容 (不包含 CLI 指令部分)
+//
+// 參數:
+//   - content: 提示詞模版 (空字串時使用 DefaultPromptTemplate)。
+//   - data: 模版資料。
+func RenderPrompt(content string, data PromptData) (string, error) {
+	if content == "" {
+		content = DefaultPromptTemplate
+	}
+	tmpl, err := ParsePromptTemplate(content)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// RepairOutputTailBytes 是修復提示詞中附上的上一次輸出長度上限 (保留結尾)
+const RepairOutputTailBytes = 4000
+
+// BuildRepairPrompt 建構輸出修復回合的 Prompt 內容
+// previousOutput: 上一次無法通過驗證的輸出
+// cause: 解析或驗證失敗的原因
+func BuildRepairPrompt(previousOutput string, cause error) string {
+	tail := previousOutput
+	if len(tail) > RepairOutputTailBytes {
+		start := len(tail) - RepairOutputTailBytes
+		// 避免從 UTF-8 字元中間截斷
+		for start < len(tail) && !utf8.RuneStart(tail[start]) {
+			start++
 		}
-	} 
-
-	// 組合系統指令、專案資訊、歷史記錄與使用者提示詞
-	projectInfo := fmt.Sprintf("【專案資訊】\n- 專案名稱: %s\n- 工作目錄: %s\n", project.Name, project.DirectoryPath)
-	
-	fullPrompt := fmt.Sprintf("%s\n%s\n%s\n【任務內容】\n%s", SystemInstructions, historyBuilder.String(), projectInfo, userPrompt)
-	
-	
-	return fullPrompt
+		tail = tail[start:]
+	}
+
+	return fmt.Sprintf(`你上一次的輸出無法通過驗證，原因如下:
+%s
+
+【上一次輸出的結尾】
+%s
+
+請不要再修改任何檔案，也不要輸出其他內容，只輸出一個符合以下 JSON Schema 的 JSON 物件 (檔案路徑必須是相對於工作目錄的相對路徑):
+%s
+`, cause, tail, OutputSchemaJSON())
 }
@@ -4,13 +4,23 @@ import (
 	"agent-workspace-manager/internal/api"
 	"agent-workspace-manager/internal/config"
 	"agent-workspace-manager/internal/database"
+	"agent-workspace-manager/internal/models"
+	"agent-workspace-manager/internal/services/executor"
 	"agent-workspace-manager/internal/services/scheduler"
+	"agent-workspace-manager/internal/services/secrets"
 	"agent-workspace-manager/internal/services/telegram"
+	"agent-workspace-manager/internal/services/workspace"
+	"agent-workspace-manager/internal/utils"
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -23,12 +33,17 @@ func setupRouter() *gin.Engine {
 	os.Setenv("DATABASE_URL", ":memory:")
 	cfg := config.LoadConfig()
 	database.Connect(cfg.DatabaseURL)
+	// Every :memory: connection is a separate database; use a single connection
+	// so background workers and the API see the same data.
+	sqlDB, _ := database.DB.DB()
+	sqlDB.SetMaxOpenConns(1)
+	secrets.Init("test-secret-key", "")
 	
 	// Init Services (Mock or Real)
 	// For integration test, we might want to mock Telegram/Executor if possible, 
 	// but here we test the API flow.
 	// We can skip Telegram init or let it fail gracefully (it logs and skips).
-	telegram.InitBot(cfg)
+	telegram.InitBot(cfg, slog.Default())
 	scheduler.InitScheduler()
 
 	r := gin.Default()
@@ -48,7 +63,7 @@ func TestProjectFlow(t *testing.T) {
 		"name":           "integration_test_project",
 		"description":    "Test Project",
 		"ai_cli_command": mockScript + " {prompt}", // Need {prompt} placeholder
-		"directory_path": ".",
+		"directory_path": t.TempDir(),
 	}
 	body, _ := json.Marshal(projectPayload)
 	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
@@ -106,3 +121,2337 @@ func TestProjectFlow(t *testing.T) {
 	assert.Equal(t, "Scheduled Test", executions[0]["command"])
 	assert.Equal(t, "completed", executions[0]["status"])
 }
+
+func TestExecutionQueue(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for i, dir := range dirs {
+		body, _ := json.Marshal(map[string]string{
+			"name":           fmt.Sprintf("queue_project_%d", i+1),
+			"ai_cli_command": cwd + "/mock_queue_ai_cli.sh {execution_id} {prompt}",
+			"directory_path": dir,
+		})
+		req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	run := func(projectID int, command string) (int, int) {
+		body, _ := json.Marshal(map[string]string{"command": command})
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/projects/%d/run", projectID), bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return int(resp["execution_id"].(float64)), int(resp["queue_position"].(float64))
+	}
+
+	// 1. A busy project queues new commands and reports their position
+	first, position := run(1, "First")
+	assert.Equal(t, 1, position)
+	time.Sleep(200 * time.Millisecond)
+
+	second, position := run(1, "Second")
+	assert.Equal(t, 1, position)
+	third, position := run(1, "Third")
+	assert.Equal(t, 2, position)
+	other, _ := run(2, "Other project")
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/executions/%d", third), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var execution map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &execution)
+	assert.Equal(t, "queued", execution["status"])
+	assert.Equal(t, float64(2), execution["queue_position"])
+
+	time.Sleep(3 * time.Second)
+
+	// 2. One worker per project: runs never overlap and follow FIFO order
+	log, _ := os.ReadFile(filepath.Join(dirs[0], "queue.log"))
+	expected := ""
+	for _, id := range []int{first, second, third} {
+		expected += fmt.Sprintf("start %d\nend %d\n", id, id)
+	}
+	assert.Equal(t, expected, string(log))
+	for _, id := range []int{first, second, third, other} {
+		var finished models.Execution
+		database.DB.First(&finished, id)
+		assert.Equal(t, models.StatusCompleted, finished.Status, id)
+	}
+
+	// Projects have independent workers: the other project did not wait for the queue
+	var otherExecution, thirdExecution models.Execution
+	database.DB.First(&otherExecution, other)
+	database.DB.First(&thirdExecution, third)
+	assert.True(t, otherExecution.EndTime.Before(thirdExecution.StartTime))
+
+	// 3. Restart recovery fails interrupted runs and resumes the queue
+	interrupted := models.Execution{ProjectID: 2, Command: "Interrupted", Status: models.StatusRunning}
+	database.DB.Create(&interrupted)
+	queued := models.Execution{ProjectID: 2, Command: "Queued before restart", Status: models.StatusQueued}
+	database.DB.Create(&queued)
+
+	var recovered []uint
+	executor.InitQueue(func(execution *models.Execution) {
+		recovered = append(recovered, execution.ID)
+	})
+	var reloaded models.Execution
+	database.DB.First(&reloaded, interrupted.ID)
+	assert.Equal(t, models.StatusFailed, reloaded.Status)
+	assert.Equal(t, "Execution interrupted by server restart", reloaded.ErrorMessage)
+	var resumed models.Execution
+	database.DB.First(&resumed, queued.ID)
+	assert.Equal(t, models.StatusQueued, resumed.Status)
+
+	executor.ResumeQueue()
+	time.Sleep(1500 * time.Millisecond)
+
+	resumed = models.Execution{}
+	database.DB.First(&resumed, queued.ID)
+	assert.Equal(t, models.StatusCompleted, resumed.Status)
+	assert.Equal(t, []uint{interrupted.ID, queued.ID}, recovered)
+}
+
+func TestCancelExecution(t *testing.T) {
+	r := setupRouter()
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_slow_ai_cli.sh"
+
+	projectPayload := map[string]string{
+		"name":           "cancel_test_project",
+		"description":    "Cancel Test Project",
+		"ai_cli_command": mockScript + " {prompt}",
+		"directory_path": t.TempDir(),
+	}
+	body, _ := json.Marshal(projectPayload)
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// 1. Queue two commands; the second one waits behind the first
+	var executionIDs []int
+	for _, command := range []string{"First", "Second"} {
+		body, _ = json.Marshal(map[string]string{"command": command})
+		req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		executionIDs = append(executionIDs, int(resp["execution_id"].(float64)))
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	// 2. Cancel the queued command, then the running one
+	for i := len(executionIDs) - 1; i >= 0; i-- {
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/cancel", executionIDs[i]), nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	time.Sleep(time.Second)
+
+	// 3. Both executions are cancelled and the partial output is kept
+	for i, executionID := range executionIDs {
+		req, _ = http.NewRequest("GET", fmt.Sprintf("/api/executions/%d", executionID), nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var execution map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &execution)
+		assert.Equal(t, "cancelled", execution["status"])
+		if i == 0 {
+			assert.Contains(t, execution["details"], "working...")
+		}
+	}
+
+	// 4. Cancelling a finished execution is rejected
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/cancel", executionIDs[0]), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestRecurringSchedule(t *testing.T) {
+	r := setupRouter()
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_ai_cli.sh"
+
+	projectPayload := map[string]string{
+		"name":           "recurring_test_project",
+		"description":    "Recurring Test Project",
+		"ai_cli_command": mockScript + " {prompt}",
+		"directory_path": t.TempDir(),
+	}
+	body, _ := json.Marshal(projectPayload)
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// 1. Invalid expressions are rejected
+	body, _ = json.Marshal(map[string]string{"command": "Recurring Test", "cron_expression": "not a cron"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/schedules", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 2. Create a schedule firing every second
+	body, _ = json.Marshal(map[string]string{
+		"command":         "Recurring Test",
+		"cron_expression": "* * * * * *",
+		"timezone":        "Asia/Taipei",
+	})
+	req, _ = http.NewRequest("POST", "/api/projects/1/schedules", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var schedule map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &schedule)
+	scheduleID := int(schedule["ID"].(float64))
+
+	time.Sleep(2500 * time.Millisecond)
+
+	// 3. Pause it and check the fired executions link back to the schedule
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/projects/1/schedules/%d/pause", scheduleID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &schedule)
+	assert.Equal(t, "paused", schedule["status"])
+	assert.NotNil(t, schedule["last_run_time"])
+
+	time.Sleep(500 * time.Millisecond)
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/projects/1/schedules/%d/executions", scheduleID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var executions []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &executions)
+	assert.NotEmpty(t, executions)
+	for _, execution := range executions {
+		assert.Equal(t, float64(scheduleID), execution["schedule_id"])
+	}
+
+	// 4. Resuming restores the pending status
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/projects/1/schedules/%d/resume", scheduleID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &schedule)
+	assert.Equal(t, "pending", schedule["status"])
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/projects/1/schedules/%d/pause", scheduleID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMultipleSchedules(t *testing.T) {
+	r := setupRouter()
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_ai_cli.sh"
+
+	projectPayload := map[string]string{
+		"name":           "multi_schedule_project",
+		"description":    "Multiple Schedules Project",
+		"ai_cli_command": mockScript + " {prompt}",
+		"directory_path": t.TempDir(),
+	}
+	body, _ := json.Marshal(projectPayload)
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// 1. Two pending schedules on the same project are allowed
+	var scheduleIDs []int
+	for _, command := range []string{"Deleted Schedule", "Updated Schedule"} {
+		body, _ = json.Marshal(map[string]interface{}{
+			"command":        command,
+			"scheduled_time": time.Now().Add(time.Second),
+		})
+		req, _ = http.NewRequest("POST", "/api/projects/1/schedules", bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var schedule map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &schedule)
+		scheduleIDs = append(scheduleIDs, int(schedule["ID"].(float64)))
+	}
+
+	// 2. Delete the first and push the second one further out
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/projects/1/schedules/%d", scheduleIDs[0]), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"command":        "Rescheduled",
+		"scheduled_time": time.Now().Add(2 * time.Second),
+	})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/projects/1/schedules/%d", scheduleIDs[1]), bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 3. Neither the deleted nor the old timer fires
+	time.Sleep(1500 * time.Millisecond)
+	req, _ = http.NewRequest("GET", "/api/projects/1/executions", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var executions []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &executions)
+	assert.Empty(t, executions)
+
+	// 4. Only the rescheduled command runs
+	time.Sleep(1500 * time.Millisecond)
+	req, _ = http.NewRequest("GET", "/api/projects/1/executions", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	json.Unmarshal(w.Body.Bytes(), &executions)
+	if assert.Len(t, executions, 1) {
+		assert.Equal(t, "Rescheduled", executions[0]["command"])
+	}
+}
+
+func TestScheduleOutcome(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	for _, p := range []map[string]string{
+		{"name": "outcome_ok_project", "ai_cli_command": cwd + "/mock_ai_cli.sh {prompt}"},
+		{"name": "outcome_fail_project", "ai_cli_command": cwd + "/mock_failing_ai_cli.sh {prompt}"},
+	} {
+		p["directory_path"] = t.TempDir()
+		body, _ := json.Marshal(p)
+		req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	// 1. One successful schedule, one failing schedule with a single retry
+	for projectID, payload := range map[int]map[string]interface{}{
+		1: {"command": "Succeeds", "scheduled_time": time.Now().Add(time.Second)},
+		2: {"command": "Fails", "scheduled_time": time.Now().Add(time.Second), "max_retries": 1, "retry_delay_seconds": 1},
+	} {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/projects/%d/schedules", projectID), bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	time.Sleep(3500 * time.Millisecond)
+
+	// 2. Schedule statuses follow the execution outcome
+	getSchedule := func(projectID int) map[string]interface{} {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/api/projects/%d/schedules", projectID), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var schedules []map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &schedules)
+		if assert.Len(t, schedules, 1) {
+			return schedules[0]
+		}
+		return nil
+	}
+
+	ok := getSchedule(1)
+	assert.Equal(t, "completed", ok["status"])
+	assert.NotNil(t, ok["execution_id"])
+
+	failed := getSchedule(2)
+	assert.Equal(t, "failed", failed["status"])
+	assert.Equal(t, float64(1), failed["retry_count"])
+
+	// 3. The failing schedule ran twice (original + retry)
+	req, _ := http.NewRequest("GET", "/api/projects/2/executions", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var executions []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &executions)
+	assert.Len(t, executions, 2)
+}
+
+func TestMisfirePolicyOnRestart(t *testing.T) {
+	r := setupRouter()
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_ai_cli.sh"
+
+	projectPayload := map[string]string{
+		"name":           "misfire_test_project",
+		"ai_cli_command": mockScript + " {prompt}",
+		"directory_path": t.TempDir(),
+	}
+	body, _ := json.Marshal(projectPayload)
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// 1. Simulate schedules that became due while the server was down
+	now := time.Now()
+	schedules := []models.Schedule{
+		{ProjectID: 1, Command: "Run Once", ScheduledTime: now.Add(-time.Hour), Status: models.SchedulePending},
+		{ProjectID: 1, Command: "Skip", ScheduledTime: now.Add(-time.Hour), Status: models.SchedulePending, MisfirePolicy: models.MisfireSkip},
+		{ProjectID: 1, Command: "Grace Expired", ScheduledTime: now.Add(-time.Hour), Status: models.SchedulePending, MisfirePolicy: models.MisfireGrace, MisfireGraceSeconds: 60},
+		{ProjectID: 1, Command: "Grace Ok", ScheduledTime: now.Add(-time.Minute), Status: models.SchedulePending, MisfirePolicy: models.MisfireGrace, MisfireGraceSeconds: 3600},
+	}
+	for i := range schedules {
+		database.DB.Create(&schedules[i])
+	}
+
+	// 2. Restart the scheduler
+	scheduler.InitScheduler()
+	time.Sleep(time.Second)
+
+	expected := map[string]string{
+		"Run Once":      models.ScheduleCompleted,
+		"Skip":          models.ScheduleMissed,
+		"Grace Expired": models.ScheduleMissed,
+		"Grace Ok":      models.ScheduleCompleted,
+	}
+	for _, s := range schedules {
+		var reloaded models.Schedule
+		database.DB.First(&reloaded, s.ID)
+		assert.Equal(t, expected[s.Command], reloaded.Status, s.Command)
+	}
+}
+
+func TestMisfireCatchUpSurvivesQueueRecovery(t *testing.T) {
+	r := setupRouter()
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_delayed_ai_cli.sh"
+
+	projectPayload := map[string]string{
+		"name":           "catch_up_restart_project",
+		"ai_cli_command": mockScript + " {prompt}",
+		"directory_path": t.TempDir(),
+	}
+	body, _ := json.Marshal(projectPayload)
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// 1. Simulate a run killed by the restart and a schedule that became due while down
+	interrupted := models.Execution{ProjectID: 1, Command: "Interrupted", Status: models.StatusRunning}
+	database.DB.Create(&interrupted)
+	schedule := models.Schedule{ProjectID: 1, Command: "Catch Up", ScheduledTime: time.Now().Add(-time.Hour), Status: models.SchedulePending}
+	database.DB.Create(&schedule)
+
+	// 2. Start up in the same order as the server
+	var recovered []uint
+	executor.InitQueue(func(execution *models.Execution) {
+		recovered = append(recovered, execution.ID)
+		scheduler.HandleExecutionResult(execution)
+	})
+	scheduler.InitScheduler()
+	executor.ResumeQueue()
+	time.Sleep(2 * time.Second)
+
+	// 3. Only the interrupted run is reported as failed; the catch-up run completes
+	assert.Equal(t, []uint{interrupted.ID}, recovered)
+	var reloaded models.Execution
+	database.DB.First(&reloaded, interrupted.ID)
+	assert.Equal(t, models.StatusFailed, reloaded.Status)
+	assert.Equal(t, "Execution interrupted by server restart", reloaded.ErrorMessage)
+
+	var catchUp models.Execution
+	assert.NoError(t, database.DB.Where("schedule_id = ?", schedule.ID).First(&catchUp).Error)
+	assert.Equal(t, models.StatusCompleted, catchUp.Status)
+	assert.Empty(t, catchUp.ErrorMessage)
+
+	var reloadedSchedule models.Schedule
+	database.DB.First(&reloadedSchedule, schedule.ID)
+	assert.Equal(t, models.ScheduleCompleted, reloadedSchedule.Status)
+}
+
+func TestGitExecutionDiff(t *testing.T) {
+	r := setupRouter()
+
+	// 1. Prepare a git repository as the project directory
+	repoDir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+	os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("hello\n"), 0644)
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_writing_ai_cli.sh"
+
+	projectPayload := map[string]string{
+		"name":           "git_diff_project",
+		"ai_cli_command": mockScript + " {prompt}",
+		"directory_path": repoDir,
+	}
+	body, _ := json.Marshal(projectPayload)
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	body, _ = json.Marshal(map[string]string{"command": "Edit files"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(time.Second)
+
+	// 2. The changed-file list comes from git, not from the agent's report
+	req, _ = http.NewRequest("GET", "/api/executions/1", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var execution map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &execution)
+	assert.Equal(t, "completed", execution["status"])
+	assert.Equal(t, "git", execution["change_source"])
+	assert.Equal(t, []interface{}{"README.md"}, execution["modified_files"])
+	assert.Equal(t, []interface{}{"generated.txt"}, execution["created_files"])
+
+	// 3. The diff endpoint returns the unified diff
+	req, _ = http.NewRequest("GET", "/api/executions/1/diff", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var diff map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &diff)
+	assert.Contains(t, diff["diff"], "+changed")
+	assert.Contains(t, diff["diff"], "+new file")
+}
+
+func TestRevertExecution(t *testing.T) {
+	r := setupRouter()
+	if err := workspace.InitBackupStore(t.TempDir()); err != nil {
+		t.Fatalf("init backup store: %v", err)
+	}
+
+	// 1. A git project and a plain directory project, both edited by the agent
+	gitDir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = gitDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+	plainDir := t.TempDir()
+	for _, dir := range []string{gitDir, plainDir} {
+		os.WriteFile(filepath.Join(dir, "README.md"), []byte("hello\n"), 0644)
+	}
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_writing_ai_cli.sh"
+
+	for i, dir := range []string{gitDir, plainDir} {
+		body, _ := json.Marshal(map[string]string{
+			"name":           fmt.Sprintf("revert_project_%d", i+1),
+			"ai_cli_command": mockScript + " {prompt}",
+			"directory_path": dir,
+		})
+		req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		body, _ = json.Marshal(map[string]string{"command": "Edit files"})
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/api/projects/%d/run", i+1), bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	time.Sleep(time.Second)
+
+	// 2. Revert both executions; each revert is recorded as its own execution
+	for i, dir := range []string{gitDir, plainDir} {
+		executionID := i + 1
+		content, _ := os.ReadFile(filepath.Join(dir, "README.md"))
+		assert.Equal(t, "hello\nchanged\n", string(content))
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/revert", executionID), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		revertID := uint(resp["execution_id"].(float64))
+
+		time.Sleep(500 * time.Millisecond)
+
+		var revert models.Execution
+		database.DB.First(&revert, revertID)
+		assert.Equal(t, models.StatusCompleted, revert.Status)
+		assert.Equal(t, uint(executionID), *revert.RevertOfID)
+
+		content, _ = os.ReadFile(filepath.Join(dir, "README.md"))
+		assert.Equal(t, "hello\n", string(content))
+		_, err := os.Stat(filepath.Join(dir, "generated.txt"))
+		assert.True(t, os.IsNotExist(err))
+
+		// 3. The same execution cannot be reverted twice
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/revert", executionID), nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	}
+
+	var gitExecution, plainExecution models.Execution
+	database.DB.First(&gitExecution, 1)
+	assert.Equal(t, models.SnapshotGit, gitExecution.SnapshotKind)
+	database.DB.First(&plainExecution, 2)
+	assert.Equal(t, models.SnapshotBackup, plainExecution.SnapshotKind)
+}
+
+func TestRevertRefusesSymlinkEscape(t *testing.T) {
+	if err := workspace.InitBackupStore(t.TempDir()); err != nil {
+		t.Fatalf("init backup store: %v", err)
+	}
+
+	// 1. Back up a project, then let the "agent" swap a directory and a file for symlinks leading outside
+	projectDir := t.TempDir()
+	outsideDir := t.TempDir()
+	os.MkdirAll(filepath.Join(projectDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(projectDir, "sub", "data.txt"), []byte("inside\n"), 0644)
+	os.WriteFile(filepath.Join(projectDir, "README.md"), []byte("hello\n"), 0644)
+	os.WriteFile(filepath.Join(outsideDir, "data.txt"), []byte("outside\n"), 0644)
+	os.WriteFile(filepath.Join(outsideDir, "README.md"), []byte("outside\n"), 0644)
+
+	before, err := workspace.ScanManifest(projectDir, nil, true, nil)
+	if err != nil {
+		t.Fatalf("scan manifest: %v", err)
+	}
+
+	os.RemoveAll(filepath.Join(projectDir, "sub"))
+	os.Symlink(outsideDir, filepath.Join(projectDir, "sub"))
+	os.Remove(filepath.Join(projectDir, "README.md"))
+	os.Symlink(filepath.Join(outsideDir, "README.md"), filepath.Join(projectDir, "README.md"))
+
+	_, err = workspace.SafeJoin(projectDir, "sub/data.txt")
+	assert.ErrorContains(t, err, "symlink sub points outside the project directory")
+	_, err = workspace.SafeJoin(projectDir, "README.md")
+	assert.NoError(t, err)
+
+	// 2. Restoring through the directory symlink is refused and the outside file is untouched
+	_, _, err = workspace.RestoreBackupSnapshot(projectDir, &workspace.BackupSnapshot{
+		Files: workspace.Manifest{"sub/data.txt": before["sub/data.txt"]},
+	})
+	assert.ErrorContains(t, err, "sub/data.txt is unsafe")
+	content, _ := os.ReadFile(filepath.Join(outsideDir, "data.txt"))
+	assert.Equal(t, "outside\n", string(content))
+
+	_, _, err = workspace.RestoreBackupSnapshot(projectDir, &workspace.BackupSnapshot{Created: []string{"sub/data.txt"}})
+	assert.Error(t, err)
+	err = workspace.RestoreGitSnapshot(projectDir, "HEAD", nil, []string{"sub/data.txt"})
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(outsideDir, "data.txt"))
+	assert.NoError(t, err)
+
+	// 3. A file replaced by a symlink is restored as a regular file instead of writing through the link
+	restored, _, err := workspace.RestoreBackupSnapshot(projectDir, &workspace.BackupSnapshot{
+		Files: workspace.Manifest{"README.md": before["README.md"]},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"README.md"}, restored)
+	info, _ := os.Lstat(filepath.Join(projectDir, "README.md"))
+	assert.True(t, info.Mode().IsRegular())
+	content, _ = os.ReadFile(filepath.Join(projectDir, "README.md"))
+	assert.Equal(t, "hello\n", string(content))
+	content, _ = os.ReadFile(filepath.Join(outsideDir, "README.md"))
+	assert.Equal(t, "outside\n", string(content))
+}
+
+func TestWorktreeExecutionReview(t *testing.T) {
+	r := setupRouter()
+	if err := workspace.SetWorktreeRoot(t.TempDir()); err != nil {
+		t.Fatalf("set worktree root: %v", err)
+	}
+
+	// 1. A git repository with a committed README on branch main
+	repoDir := t.TempDir()
+	os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("hello\n"), 0644)
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "README.md"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_writing_ai_cli.sh"
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":           "worktree_project",
+		"ai_cli_command": mockScript + " {prompt}",
+		"directory_path": repoDir,
+		"use_worktree":   true,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Worktree mode is rejected for directories that are not git repositories
+	body, _ = json.Marshal(map[string]interface{}{
+		"name":           "plain_project",
+		"directory_path": t.TempDir(),
+		"use_worktree":   true,
+	})
+	req, _ = http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	run := func() models.Execution {
+		body, _ := json.Marshal(map[string]string{"command": "Edit files"})
+		req, _ := http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+
+		time.Sleep(time.Second)
+
+		var execution models.Execution
+		database.DB.First(&execution, uint(resp["execution_id"].(float64)))
+		return execution
+	}
+
+	// 2. The agent runs in its own worktree; the live checkout is untouched
+	first := run()
+	assert.Equal(t, models.StatusCompleted, first.Status)
+	assert.Equal(t, models.ReviewPending, first.ReviewStatus)
+	assert.Equal(t, "main", first.BaseBranch)
+	assert.Equal(t, []string{"README.md"}, first.ModifiedFiles)
+	content, _ := os.ReadFile(filepath.Join(repoDir, "README.md"))
+	assert.Equal(t, "hello\n", string(content))
+	content, _ = os.ReadFile(filepath.Join(first.WorktreePath, "README.md"))
+	assert.Equal(t, "hello\nchanged\n", string(content))
+
+	// Worktree executions are rejected instead of reverted
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/revert", first.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// 3. Approving merges the branch into main and removes the worktree
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/approve", first.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	content, _ = os.ReadFile(filepath.Join(repoDir, "README.md"))
+	assert.Equal(t, "hello\nchanged\n", string(content))
+	_, err := os.Stat(filepath.Join(repoDir, "generated.txt"))
+	assert.NoError(t, err)
+	_, err = os.Stat(first.WorktreePath)
+	assert.True(t, os.IsNotExist(err))
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/approve", first.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// 4. Rejecting deletes the worktree and branch without touching main
+	second := run()
+	assert.Equal(t, models.ReviewPending, second.ReviewStatus)
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/reject", second.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	content, _ = os.ReadFile(filepath.Join(repoDir, "README.md"))
+	assert.Equal(t, "hello\nchanged\n", string(content))
+	_, err = os.Stat(second.WorktreePath)
+	assert.True(t, os.IsNotExist(err))
+	out, _ := exec.Command("git", "-C", repoDir, "branch", "--list", second.WorktreeBranch).Output()
+	assert.Empty(t, string(out))
+
+	// 5. A run that fails before the agent starts leaves no worktree or branch behind
+	body, _ = json.Marshal(map[string]string{"ai_cli_command": "/nonexistent/agent {prompt}"})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	third := run()
+	assert.Equal(t, models.StatusFailed, third.Status)
+	assert.Empty(t, third.ReviewStatus)
+	assert.NotEmpty(t, third.WorktreePath)
+	_, err = os.Stat(third.WorktreePath)
+	assert.True(t, os.IsNotExist(err))
+	out, _ = exec.Command("git", "-C", repoDir, "branch", "--list", third.WorktreeBranch).Output()
+	assert.Empty(t, string(out))
+
+	// 6. A failed run keeps its worktree for review, but can only be rejected
+	cwd, _ := os.Getwd()
+	body, _ = json.Marshal(map[string]string{"ai_cli_command": cwd + "/mock_failing_ai_cli.sh {prompt}"})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	fourth := run()
+	assert.Equal(t, models.StatusFailed, fourth.Status)
+	assert.Equal(t, models.ReviewPending, fourth.ReviewStatus)
+	_, err = executor.ApproveExecution(fourth.ID)
+	assert.Equal(t, executor.ErrNotApprovable, err)
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/approve", fourth.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var approved int64
+	database.DB.Model(&models.Execution{}).Where("id = ? AND review_status = ?", fourth.ID, models.ReviewPending).Count(&approved)
+	assert.Equal(t, int64(1), approved)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/reject", fourth.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err = os.Stat(fourth.WorktreePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestApprovalGate(t *testing.T) {
+	r := setupRouter()
+	if err := workspace.SetWorktreeRoot(t.TempDir()); err != nil {
+		t.Fatalf("set worktree root: %v", err)
+	}
+
+	repoDir := t.TempDir()
+	os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("hello\n"), 0644)
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "README.md"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_writing_ai_cli.sh"
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":             "approval_project",
+		"ai_cli_command":   mockScript + " {prompt}",
+		"directory_path":   repoDir,
+		"use_worktree":     true,
+		"require_approval": true,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	run := func() models.Execution {
+		body, _ := json.Marshal(map[string]string{"command": "Edit files"})
+		req, _ := http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+
+		time.Sleep(time.Second)
+
+		var execution models.Execution
+		database.DB.First(&execution, uint(resp["execution_id"].(float64)))
+		return execution
+	}
+
+	// 1. A finished run waits for approval without touching the project; approving merges it
+	first := run()
+	assert.Equal(t, models.StatusAwaitingApproval, first.Status)
+	assert.Equal(t, models.ReviewPending, first.ReviewStatus)
+	content, _ := os.ReadFile(filepath.Join(repoDir, "README.md"))
+	assert.Equal(t, "hello\n", string(content))
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/approve", first.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	database.DB.First(&first, first.ID)
+	assert.Equal(t, models.StatusCompleted, first.Status)
+	assert.Equal(t, models.ReviewApproved, first.ReviewStatus)
+	content, _ = os.ReadFile(filepath.Join(repoDir, "README.md"))
+	assert.Equal(t, "hello\nchanged\n", string(content))
+
+	// 2. Rejecting a run discards its worktree; the project never sees the changes
+	second := run()
+	assert.Equal(t, models.StatusAwaitingApproval, second.Status)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/reject", second.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	database.DB.First(&second, second.ID)
+	assert.Equal(t, models.StatusRejected, second.Status)
+	assert.Equal(t, models.ReviewRejected, second.ReviewStatus)
+	content, _ = os.ReadFile(filepath.Join(repoDir, "README.md"))
+	assert.Equal(t, "hello\nchanged\n", string(content))
+	_, err := os.Stat(second.WorktreePath)
+	assert.True(t, os.IsNotExist(err))
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/approve", second.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// 3. Without a worktree the changes land in the project directly; rejecting reverts them
+	body, _ = json.Marshal(map[string]bool{"use_worktree": false})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	third := run()
+	assert.Equal(t, models.StatusAwaitingApproval, third.Status)
+	assert.Empty(t, third.WorktreeBranch)
+	content, _ = os.ReadFile(filepath.Join(repoDir, "README.md"))
+	assert.Equal(t, "hello\nchanged\nchanged\n", string(content))
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/reject", third.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	time.Sleep(500 * time.Millisecond)
+
+	database.DB.First(&third, third.ID)
+	assert.Equal(t, models.StatusRejected, third.Status)
+	assert.Equal(t, models.ReviewRejected, third.ReviewStatus)
+	assert.NotNil(t, third.RevertedByID)
+	content, _ = os.ReadFile(filepath.Join(repoDir, "README.md"))
+	assert.Equal(t, "hello\nchanged\n", string(content))
+
+	// 4. Approving keeps the changes already applied to the project
+	fourth := run()
+	assert.Equal(t, models.StatusAwaitingApproval, fourth.Status)
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/executions/%d/approve", fourth.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	database.DB.First(&fourth, fourth.ID)
+	assert.Equal(t, models.StatusCompleted, fourth.Status)
+	assert.Equal(t, models.ReviewApproved, fourth.ReviewStatus)
+	content, _ = os.ReadFile(filepath.Join(repoDir, "README.md"))
+	assert.Equal(t, "hello\nchanged\nchanged\n", string(content))
+}
+
+func TestCommandTemplate(t *testing.T) {
+	r := setupRouter()
+
+	mockScript, _ := os.Getwd()
+	mockScript = mockScript + "/mock_args_ai_cli.sh"
+	projectDir := t.TempDir()
+
+	// 1. Template errors are reported when the project is saved
+	for _, template := range []string{
+		mockScript + ` "unterminated`,
+		mockScript + " {unknown}",
+		"{prompt} --flag",
+	} {
+		body, _ := json.Marshal(map[string]string{
+			"name":           "template_project",
+			"ai_cli_command": template,
+			"directory_path": projectDir,
+		})
+		req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, template)
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"name":           "template_project",
+		"ai_cli_command": mockScript + ` --title "hello world" --dir {project_dir} --id={execution_id} --model "{model}" '{"a":{b}}' --format=\{json} '{model}' --prompt-file={prompt_file}`,
+		"model":          "test-model",
+		"directory_path": projectDir,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	body, _ = json.Marshal(map[string]string{"ai_cli_command": mockScript + " 'unterminated"})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 2. Placeholders are substituted and quoted arguments stay intact; single quotes and \{ keep braces literal
+	body, _ = json.Marshal(map[string]string{"command": "Say $HOME; rm -rf /"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(time.Second)
+
+	var execution models.Execution
+	database.DB.First(&execution, 1)
+	assert.Equal(t, models.StatusCompleted, execution.Status)
+
+	args, _ := os.ReadFile(filepath.Join(projectDir, "args.txt"))
+	lines := strings.Split(strings.TrimSpace(string(args)), "\n")
+	assert.Equal(t, []string{"--title", "hello world", "--dir", projectDir, "--id=1", "--model", "test-model", `{"a":{b}}`, "--format={json}", "{model}"}, lines[:10])
+	assert.Len(t, lines, 11)
+	assert.True(t, strings.HasPrefix(lines[10], "--prompt-file="))
+
+	prompt, _ := os.ReadFile(filepath.Join(projectDir, "prompt.txt"))
+	assert.Contains(t, string(prompt), "Say $HOME; rm -rf /")
+	_, err := os.Stat(strings.TrimPrefix(lines[10], "--prompt-file="))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPromptDelivery(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	projectDir := t.TempDir()
+
+	// 1. Delivery modes that would still put the prompt on the command line are rejected
+	for _, p := range []map[string]string{
+		{"ai_cli_command": cwd + "/mock_stdin_ai_cli.sh {prompt}", "prompt_delivery": "stdin"},
+		{"ai_cli_command": cwd + "/mock_args_ai_cli.sh", "prompt_delivery": "file"},
+		{"ai_cli_command": cwd + "/mock_args_ai_cli.sh", "prompt_delivery": "pipe"},
+	} {
+		p["name"] = "delivery_project"
+		p["directory_path"] = projectDir
+		body, _ := json.Marshal(p)
+		req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, p["prompt_delivery"])
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"name":            "delivery_project",
+		"ai_cli_command":  cwd + "/mock_stdin_ai_cli.sh --quiet",
+		"prompt_delivery": "stdin",
+		"directory_path":  projectDir,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// 2. The prompt arrives on stdin and never appears in argv
+	body, _ = json.Marshal(map[string]string{"command": "Stdin task"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(time.Second)
+
+	var execution models.Execution
+	database.DB.First(&execution, 1)
+	assert.Equal(t, models.StatusCompleted, execution.Status)
+	stdin, _ := os.ReadFile(filepath.Join(projectDir, "stdin.txt"))
+	assert.Contains(t, string(stdin), "Stdin task")
+	args, _ := os.ReadFile(filepath.Join(projectDir, "args.txt"))
+	assert.Equal(t, "--quiet\n", string(args))
+	assert.Equal(t, "stdin", execution.DebugInfo.PromptDelivery)
+	assert.Equal(t, []string{cwd + "/mock_stdin_ai_cli.sh", "--quiet"}, execution.DebugInfo.Command)
+	assert.Equal(t, len(stdin), execution.DebugInfo.PromptBytes)
+
+	// 3. File delivery records the temp file path and removes it after the run
+	body, _ = json.Marshal(map[string]string{
+		"ai_cli_command":  cwd + "/mock_args_ai_cli.sh --prompt-file={prompt_file}",
+		"prompt_delivery": "file",
+	})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body, _ = json.Marshal(map[string]string{"command": "File task"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(time.Second)
+
+	var fileExecution models.Execution
+	database.DB.First(&fileExecution, 2)
+	assert.Equal(t, models.StatusCompleted, fileExecution.Status)
+	assert.Equal(t, "file", fileExecution.DebugInfo.PromptDelivery)
+	assert.NotEmpty(t, fileExecution.DebugInfo.PromptFile)
+	_, err := os.Stat(fileExecution.DebugInfo.PromptFile)
+	assert.True(t, os.IsNotExist(err))
+	prompt, _ := os.ReadFile(filepath.Join(projectDir, "prompt.txt"))
+	assert.Contains(t, string(prompt), "File task")
+}
+
+func TestAgentProfiles(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	projectDir := t.TempDir()
+
+	// 1. Built-in presets are seeded
+	req, _ := http.NewRequest("GET", "/api/agent-profiles", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var presets []models.AgentProfile
+	json.Unmarshal(w.Body.Bytes(), &presets)
+	names := make([]string, 0, len(presets))
+	for _, p := range presets {
+		names = append(names, p.Name)
+		assert.True(t, p.BuiltIn)
+	}
+	assert.ElementsMatch(t, []string{"claude-cli", "gemini-default", "aider-strict"}, names)
+
+	// 2. Invalid templates and environment variable names are rejected
+	for _, p := range []map[string]interface{}{
+		{"name": "broken", "command_template": "agent {unknown}"},
+		{"name": "broken", "command_template": "agent", "prompt_delivery": "file"},
+		{"name": "broken", "command_template": "agent", "env_vars": map[string]string{"BAD-NAME": "x"}},
+		{"name": "bad name", "command_template": "agent"},
+		{"name": "claude-cli", "command_template": "agent"},
+	} {
+		body, _ := json.Marshal(p)
+		req, _ = http.NewRequest("POST", "/api/agent-profiles", bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.NotEqual(t, http.StatusCreated, w.Code, p)
+	}
+
+	// 3. A project using a profile runs its command template with its environment
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":             "env-agent",
+		"command_template": cwd + "/mock_env_ai_cli.sh --model {model}",
+		"prompt_delivery":  "stdin",
+		"env_vars":         map[string]string{"AGENT_MODE": "first"},
+		"timeout_seconds":  30,
+	})
+	req, _ = http.NewRequest("POST", "/api/agent-profiles", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var profile models.AgentProfile
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	assert.NotZero(t, profile.ID)
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"name":             "profile_project",
+		"agent_profile_id": 999,
+		"directory_path":   projectDir,
+	})
+	req, _ = http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"name":             "profile_project",
+		"agent_profile_id": profile.ID,
+		"model":            "test-model",
+		"directory_path":   projectDir,
+	})
+	req, _ = http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	body, _ = json.Marshal(map[string]string{"command": "Profile task"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(time.Second)
+
+	var execution models.Execution
+	database.DB.First(&execution, 1)
+	assert.Equal(t, models.StatusCompleted, execution.Status)
+	assert.Equal(t, "env-agent", execution.DebugInfo.AgentProfile)
+	env, _ := os.ReadFile(filepath.Join(projectDir, "env.txt"))
+	assert.Equal(t, "first\n", string(env))
+	args, _ := os.ReadFile(filepath.Join(projectDir, "args.txt"))
+	assert.Equal(t, "--model\ntest-model\n", string(args))
+
+	// 4. Editing the profile applies to the next run of every project using it
+	body, _ = json.Marshal(map[string]interface{}{"env_vars": map[string]string{"AGENT_MODE": "second"}})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/agent-profiles/%d", profile.ID), bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body, _ = json.Marshal(map[string]string{"command": "Second task"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(time.Second)
+
+	var second models.Execution
+	database.DB.First(&second, 2)
+	assert.Equal(t, models.StatusCompleted, second.Status)
+	env, _ = os.ReadFile(filepath.Join(projectDir, "env.txt"))
+	assert.Equal(t, "second\n", string(env))
+
+	// 5. Profiles that are built in or still referenced cannot be deleted
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/agent-profiles/%d", presets[0].ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/agent-profiles/%d", profile.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Detaching the project frees the profile
+	body, _ = json.Marshal(map[string]interface{}{"agent_profile_id": 0, "ai_cli_command": cwd + "/mock_ai_cli.sh"})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/agent-profiles/%d", profile.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestProjectSecrets(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	projectDir := t.TempDir()
+	// Host variables outside the whitelist must not reach the agent
+	t.Setenv("HOST_SECRET", "host-only-value")
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":           "secret_project",
+		"ai_cli_command": cwd + "/mock_secret_ai_cli.sh",
+		"env_vars":       map[string]string{"API_TOKEN": "tok-1234567890"},
+		"directory_path": projectDir,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "tok-1234567890")
+
+	// 1. Values are encrypted at rest and only names are returned by the API
+	var stored string
+	database.DB.Raw("SELECT env_vars FROM projects WHERE id = 1").Scan(&stored)
+	assert.True(t, strings.HasPrefix(stored, "enc:v1:"))
+	assert.NotContains(t, stored, "tok-1234567890")
+
+	req, _ = http.NewRequest("GET", "/api/projects/1", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.NotContains(t, w.Body.String(), "tok-1234567890")
+	var project models.Project
+	json.Unmarshal(w.Body.Bytes(), &project)
+	assert.Equal(t, []string{"API_TOKEN"}, project.EnvVarNames)
+
+	// 2. The agent receives the secret, but it is redacted from the stored output
+	body, _ = json.Marshal(map[string]string{"command": "Use the token"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(time.Second)
+
+	var execution models.Execution
+	database.DB.First(&execution, 1)
+	assert.Equal(t, models.StatusCompleted, execution.Status)
+	token, _ := os.ReadFile(filepath.Join(projectDir, "token.txt"))
+	assert.Equal(t, "tok-1234567890\n", string(token))
+	host, _ := os.ReadFile(filepath.Join(projectDir, "host.txt"))
+	assert.Equal(t, "unset\n", string(host))
+	assert.NotContains(t, execution.Details, "tok-1234567890")
+	assert.Contains(t, execution.Details, "Authenticating with [REDACTED]")
+	assert.Equal(t, "Used token [REDACTED]", execution.Summary)
+
+	// 3. Updates merge with the existing variables; null removes one
+	body, _ = json.Marshal(map[string]interface{}{"env_vars": map[string]interface{}{"EXTRA": "value", "1BAD": "x"}})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body, _ = json.Marshal(map[string]interface{}{"env_vars": map[string]interface{}{"EXTRA": "value"}})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var updated models.Project
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	assert.Equal(t, []string{"API_TOKEN", "EXTRA"}, updated.EnvVarNames)
+
+	body, _ = json.Marshal(map[string]interface{}{"env_vars": map[string]interface{}{"API_TOKEN": nil}})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var reloaded models.Project
+	database.DB.First(&reloaded, 1)
+	assert.Equal(t, map[string]string{"EXTRA": "value"}, reloaded.EnvVars)
+}
+
+func TestSandbox(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	projectDir := t.TempDir()
+	configDir := t.TempDir()
+
+	// 1. Invalid sandbox settings are rejected
+	for _, p := range []map[string]interface{}{
+		{"sandbox_mode": "chroot"},
+		{"sandbox_mode": "bwrap", "sandbox_writable_paths": []string{"relative/path"}},
+		{"sandbox_mode": "bwrap", "sandbox_writable_paths": []string{filepath.Join(configDir, "missing")}},
+	} {
+		p["name"] = "sandbox_project"
+		p["ai_cli_command"] = cwd + "/mock_sandbox_ai_cli.sh"
+		p["directory_path"] = projectDir
+		body, _ := json.Marshal(p)
+		req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, p)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":                    "sandbox_project",
+		"ai_cli_command":          cwd + "/mock_sandbox_ai_cli.sh",
+		"directory_path":          projectDir,
+		"sandbox_mode":            "bwrap",
+		"sandbox_writable_paths":  []string{configDir},
+		"sandbox_disable_network": true,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// 2. Without bubblewrap the execution fails instead of running unconfined
+	t.Setenv("PATH", t.TempDir())
+	body, _ = json.Marshal(map[string]string{"command": "Unconfined task"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(500 * time.Millisecond)
+
+	var unavailable models.Execution
+	database.DB.First(&unavailable, 1)
+	assert.Equal(t, models.StatusFailed, unavailable.Status)
+	assert.Contains(t, unavailable.ErrorMessage, "bubblewrap")
+
+	// 3. The agent runs inside bwrap with only the project and extra paths writable
+	t.Setenv("PATH", cwd+"/fakebin:/usr/bin:/bin")
+	body, _ = json.Marshal(map[string]string{"command": "Sandboxed task"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(time.Second)
+
+	var execution models.Execution
+	database.DB.First(&execution, 2)
+	assert.Equal(t, models.StatusCompleted, execution.Status)
+	assert.Equal(t, "bwrap", execution.DebugInfo.Sandbox)
+	bwrapArgs, _ := os.ReadFile(filepath.Join(projectDir, "bwrap_args.txt"))
+	assert.Contains(t, string(bwrapArgs), "--ro-bind\n/\n/\n")
+	assert.Contains(t, string(bwrapArgs), "--bind\n"+projectDir+"\n"+projectDir+"\n")
+	assert.Contains(t, string(bwrapArgs), "--bind\n"+configDir+"\n"+configDir+"\n")
+	assert.Contains(t, string(bwrapArgs), "--unshare-net\n")
+
+	// 4. Blocked operations reported by the agent are recorded on the execution
+	assert.Len(t, execution.SandboxViolations, 1)
+	assert.Contains(t, execution.SandboxViolations[0], "Read-only file system")
+
+	// 5. An empty sandbox_mode turns the sandbox off
+	body, _ = json.Marshal(map[string]string{"sandbox_mode": ""})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var project models.Project
+	database.DB.First(&project, 1)
+	assert.Equal(t, models.SandboxNone, project.SandboxMode)
+}
+
+func TestResourceLimits(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	projectDir := t.TempDir()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":            "limits_project",
+		"ai_cli_command":  cwd + "/mock_slow_ai_cli.sh",
+		"directory_path":  projectDir,
+		"timeout_seconds": -1,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"name":            "limits_project",
+		"ai_cli_command":  cwd + "/mock_slow_ai_cli.sh",
+		"directory_path":  projectDir,
+		"timeout_seconds": 1,
+	})
+	req, _ = http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	run := func(command string) {
+		body, _ := json.Marshal(map[string]string{"command": command})
+		req, _ := http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+	update := func(settings map[string]interface{}) {
+		body, _ := json.Marshal(settings)
+		req, _ := http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// 1. The per-project wall clock limit ends the run with a distinct status
+	run("Slow task")
+	time.Sleep(2500 * time.Millisecond)
+
+	var timedOut models.Execution
+	database.DB.First(&timedOut, 1)
+	assert.Equal(t, models.StatusTimedOut, timedOut.Status)
+	assert.Equal(t, "Execution timed out after 1s", timedOut.ErrorMessage)
+	assert.Contains(t, timedOut.Details, "working...")
+	assert.Equal(t, 1, timedOut.DebugInfo.Limits.TimeoutSeconds)
+
+	// 2. Output beyond the limit is truncated in the middle, keeping the JSON result
+	update(map[string]interface{}{
+		"ai_cli_command":   cwd + "/mock_limits_ai_cli.sh verbose",
+		"timeout_seconds":  0,
+		"max_output_bytes": 2000,
+	})
+	run("Verbose task")
+	time.Sleep(time.Second)
+
+	var verbose models.Execution
+	database.DB.First(&verbose, 2)
+	assert.Equal(t, models.StatusCompleted, verbose.Status)
+	assert.Equal(t, "Finished within limits", verbose.Summary)
+	assert.True(t, strings.HasPrefix(verbose.Details, "line 1: "))
+	assert.Contains(t, verbose.Details, "[output truncated: ")
+	assert.Less(t, len(verbose.Details), 2200)
+
+	// 3. Exceeding the CPU time limit is reported as resource_exceeded
+	update(map[string]interface{}{
+		"ai_cli_command":    cwd + "/mock_limits_ai_cli.sh cpu",
+		"cpu_limit_seconds": 1,
+	})
+	run("Busy task")
+
+	// CPU time accrues slower than wall time on a loaded machine
+	var cpu models.Execution
+	for i := 0; i < 50; i++ {
+		time.Sleep(200 * time.Millisecond)
+		cpu = models.Execution{}
+		database.DB.First(&cpu, 3)
+		if cpu.IsFinished() {
+			break
+		}
+	}
+	assert.Equal(t, models.StatusResourceExceeded, cpu.Status)
+	assert.Equal(t, "CPU time limit exceeded (1s)", cpu.ErrorMessage)
+
+	// 4. The memory limit is applied before the agent starts
+	update(map[string]interface{}{
+		"ai_cli_command":    cwd + "/mock_limits_ai_cli.sh memory",
+		"cpu_limit_seconds": 0,
+		"memory_limit_mb":   256,
+	})
+	run("Hungry task")
+	time.Sleep(time.Second)
+
+	var memory models.Execution
+	database.DB.First(&memory, 4)
+	assert.Equal(t, models.StatusResourceExceeded, memory.Status)
+	assert.True(t, strings.HasPrefix(memory.ErrorMessage, "Memory limit probably exceeded (256 MB, signal: aborted"), memory.ErrorMessage)
+	limit, _ := os.ReadFile(filepath.Join(projectDir, "ulimit.txt"))
+	assert.Equal(t, "262144\n", string(limit))
+
+	// 5. Memory errors in the output alone are an ordinary failure
+	update(map[string]interface{}{
+		"ai_cli_command": cwd + "/mock_limits_ai_cli.sh oom_log",
+	})
+	run("Log search")
+	time.Sleep(time.Second)
+
+	var logged models.Execution
+	database.DB.First(&logged, 5)
+	assert.Equal(t, models.StatusFailed, logged.Status)
+	assert.Equal(t, "exit status 1", logged.ErrorMessage)
+
+	// 6. So are CPU limit messages in the output without SIGXCPU
+	update(map[string]interface{}{
+		"ai_cli_command":    cwd + "/mock_limits_ai_cli.sh cpu_log",
+		"memory_limit_mb":   0,
+		"cpu_limit_seconds": 1,
+	})
+	run("Build log search")
+	time.Sleep(time.Second)
+
+	var cpuLogged models.Execution
+	database.DB.First(&cpuLogged, 6)
+	assert.Equal(t, models.StatusFailed, cpuLogged.Status)
+	assert.Equal(t, "exit status 1", cpuLogged.ErrorMessage)
+}
+
+func TestPathSafetyVerification(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	outsideDir := t.TempDir()
+	repoDir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"name":           "escape_project",
+		"ai_cli_command": cwd + "/mock_escape_ai_cli.sh " + outsideDir,
+		"directory_path": repoDir,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	body, _ = json.Marshal(map[string]string{"command": "Escape"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	time.Sleep(time.Second)
+
+	var execution models.Execution
+	database.DB.First(&execution, 1)
+	// Paths escaping the project also fail output validation
+	assert.Equal(t, models.StatusParseFailed, execution.Status)
+	assert.Contains(t, execution.ErrorMessage, `"/etc/passwd" must be a relative path`)
+	// git only sees the files inside the project, but the agent's own report is still checked
+	assert.ElementsMatch(t, []string{"outside_link", "safe.txt"}, execution.CreatedFiles)
+
+	violations := strings.Join(execution.PolicyViolations, "\n")
+	assert.Len(t, execution.PolicyViolations, 4)
+	assert.Contains(t, violations, "../secret.txt: path escapes the project directory")
+	assert.Contains(t, violations, "/etc/passwd: absolute path outside the project directory")
+	assert.Contains(t, violations, "outside_link/escaped.txt: symlink outside_link points outside the project directory")
+	assert.Contains(t, violations, "outside_link: symlink outside_link points outside the project directory")
+	assert.NotContains(t, violations, "safe.txt")
+
+	warning := telegram.FormatWarnings(&execution)
+	assert.True(t, strings.HasPrefix(warning, "🚨🚨 POLICY VIOLATION 🚨🚨"))
+	assert.Contains(t, warning, "touched 4 path(s) outside the project directory")
+}
+
+func TestFilesystemChangeDetection(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	for i, mode := range []string{"silent", "report"} {
+		dir := t.TempDir()
+		for _, name := range []string{"keep.txt", "edit.txt", "remove.txt"} {
+			os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		}
+
+		body, _ := json.Marshal(map[string]interface{}{
+			"name":            "fs_project_" + mode,
+			"ai_cli_command":  cwd + "/mock_fs_ai_cli.sh " + mode,
+			"directory_path":  dir,
+			"ignore_patterns": []string{"*.log"},
+		})
+		req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		body, _ = json.Marshal(map[string]string{"command": "Change files"})
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/api/projects/%d/run", i+1), bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	// The agent reported nothing: the lists come from comparing the manifests,
+	// skipping node_modules/ (default) and *.log (project setting)
+	var silent models.Execution
+	database.DB.First(&silent, 1)
+	assert.Equal(t, models.StatusParseFailed, silent.Status)
+	assert.Equal(t, models.ChangeSourceFilesystem, silent.ChangeSource)
+	assert.Equal(t, []string{"edit.txt"}, silent.ModifiedFiles)
+	assert.Equal(t, []string{"new.txt", "sub/nested.txt"}, silent.CreatedFiles)
+	assert.Equal(t, []string{"remove.txt"}, silent.DeletedFiles)
+
+	// The agent's own report takes precedence when it provides one
+	var reported models.Execution
+	database.DB.First(&reported, 2)
+	assert.Equal(t, models.StatusCompleted, reported.Status)
+	assert.Equal(t, models.ChangeSourceAgent, reported.ChangeSource)
+	assert.Equal(t, []string{"new.txt"}, reported.CreatedFiles)
+	assert.Empty(t, reported.ModifiedFiles)
+}
+
+func TestOutputParsers(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	formats := []string{"json", "fenced_json", "jsonl", "text"}
+	for i, format := range formats {
+		mode := strings.TrimSuffix(format, "_json")
+		body, _ := json.Marshal(map[string]string{
+			"name":           "parser_" + format,
+			"ai_cli_command": cwd + "/mock_formats_ai_cli.sh " + mode,
+			"output_parser":  format,
+			"directory_path": t.TempDir(),
+		})
+		req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		body, _ = json.Marshal(map[string]string{"command": "Report"})
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/api/projects/%d/run", i+1), bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	// A result event with is_error fails the execution
+	body, _ := json.Marshal(map[string]string{
+		"name":           "parser_jsonl_error",
+		"ai_cli_command": cwd + "/mock_formats_ai_cli.sh jsonl_error",
+		"output_parser":  "jsonl",
+		"directory_path": t.TempDir(),
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	body, _ = json.Marshal(map[string]string{"command": "Report"})
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/projects/%d/run", len(formats)+1), bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// Unknown parsers are rejected
+	body, _ = json.Marshal(map[string]string{"output_parser": "xml"})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	time.Sleep(2 * time.Second)
+
+	executions := make([]models.Execution, len(formats))
+	for i := range formats {
+		database.DB.First(&executions[i], i+1)
+		assert.Equal(t, models.StatusCompleted, executions[i].Status, formats[i])
+		assert.Equal(t, formats[i], executions[i].OutputParser)
+	}
+
+	// Braces in logs don't confuse the JSON parser
+	assert.Equal(t, "JSON among logs", executions[0].Summary)
+	assert.Equal(t, []string{"a.txt"}, executions[0].ModifiedFiles)
+
+	assert.Equal(t, "Fenced result", executions[1].Summary)
+	assert.Equal(t, []string{"b.txt"}, executions[1].CreatedFiles)
+
+	assert.Equal(t, "Stream finished", executions[2].Summary)
+
+	var failed models.Execution
+	database.DB.First(&failed, len(formats)+1)
+	assert.Equal(t, models.StatusFailed, failed.Status)
+	assert.Equal(t, "Tool call failed", failed.Summary)
+	assert.Equal(t, "Agent reported failure: Tool call failed", failed.ErrorMessage)
+
+	// Plain text keeps only the last lines as summary
+	lines := strings.Split(executions[3].Summary, "\n")
+	assert.Len(t, lines, utils.PlainTextSummaryLines)
+	assert.Equal(t, "line 11", lines[0])
+	assert.Equal(t, "line 30", lines[len(lines)-1])
+}
+
+func TestOutputRepair(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	projects := []struct {
+		mode   string
+		repair bool
+	}{
+		{"fixable", false},
+		{"fixable", true},
+		{"stubborn", true},
+	}
+	for i, p := range projects {
+		body, _ := json.Marshal(map[string]interface{}{
+			"name":           fmt.Sprintf("repair_project_%d", i+1),
+			"ai_cli_command": cwd + "/mock_repair_ai_cli.sh " + p.mode,
+			"repair_output":  p.repair,
+			"directory_path": t.TempDir(),
+		})
+		req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		body, _ = json.Marshal(map[string]string{"command": "Do it"})
+		req, _ = http.NewRequest("POST", fmt.Sprintf("/api/projects/%d/run", i+1), bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	// Without repair the schema violations just fail parsing
+	var plain models.Execution
+	database.DB.First(&plain, 1)
+	assert.Equal(t, models.StatusParseFailed, plain.Status)
+	assert.Contains(t, plain.ErrorMessage, "status: must be one of success, failed")
+	assert.Contains(t, plain.ErrorMessage, `modified_files[0]: "/tmp/a.txt" must be a relative path`)
+	assert.Empty(t, plain.OutputAttempts)
+
+	// The repair round re-emits a valid result; both attempts are kept
+	var repaired models.Execution
+	database.DB.First(&repaired, 2)
+	assert.Equal(t, models.StatusCompleted, repaired.Status)
+	assert.Equal(t, "Repaired result", repaired.Summary)
+	assert.Equal(t, []string{"a.txt"}, repaired.ModifiedFiles)
+	assert.Contains(t, repaired.Details, "Invalid result")
+	if assert.Len(t, repaired.OutputAttempts, 2) {
+		assert.False(t, repaired.OutputAttempts[0].Repair)
+		assert.Contains(t, repaired.OutputAttempts[0].Error, "status: must be one of success, failed")
+		assert.True(t, repaired.OutputAttempts[1].Repair)
+		assert.Contains(t, repaired.OutputAttempts[1].Output, "Repaired result")
+		assert.Empty(t, repaired.OutputAttempts[1].Error)
+	}
+
+	// A repair round that still fails leaves the execution parse_failed
+	var stubborn models.Execution
+	database.DB.First(&stubborn, 3)
+	assert.Equal(t, models.StatusParseFailed, stubborn.Status)
+	assert.Contains(t, stubborn.ErrorMessage, "repair attempt")
+	if assert.Len(t, stubborn.OutputAttempts, 2) {
+		assert.Contains(t, stubborn.OutputAttempts[1].Error, "status: must be one of success, failed")
+	}
+}
+
+func TestPromptTemplates(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	projectDir := t.TempDir()
+	body, _ := json.Marshal(map[string]string{
+		"name":            "tpl_project",
+		"ai_cli_command":  cwd + "/mock_stdin_ai_cli.sh",
+		"prompt_delivery": "stdin",
+		"directory_path":  projectDir,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// The built-in default template reproduces the classic prompt
+	req, _ = http.NewRequest("GET", "/api/prompt-templates", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var templates []models.PromptTemplate
+	json.Unmarshal(w.Body.Bytes(), &templates)
+	if assert.Len(t, templates, 1) {
+		assert.Equal(t, "default", templates[0].Name)
+		assert.True(t, templates[0].BuiltIn)
+	}
+
+	preview := func(payload map[string]string) (int, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", "/api/projects/1/prompt/preview", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, resp := preview(map[string]string{"command": "Hello"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "default", resp["prompt_template"])
+	// Same layout as the former BuildPrompt: instructions, (empty) history, project info, task
+	var project models.Project
+	database.DB.First(&project, 1)
+	expected := utils.SystemInstructions + "\n" +
+		"\n" +
+		"【專案資訊】\n- 專案名稱: tpl_project\n- 工作目錄: " + project.DirectoryPath + "\n" +
+		"\n【任務內容】\nHello"
+	assert.Equal(t, expected, resp["prompt"])
+	defaultPrompt := resp["prompt"].(string)
+
+	// Previewing does not run anything
+	var count int64
+	database.DB.Model(&models.Execution{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// The executed prompt matches the preview
+	body, _ = json.Marshal(map[string]string{"command": "Hello"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	time.Sleep(time.Second)
+	stdin, _ := os.ReadFile(filepath.Join(projectDir, "stdin.txt"))
+	assert.Equal(t, defaultPrompt, string(stdin))
+	var execution models.Execution
+	database.DB.First(&execution, 1)
+	assert.Equal(t, "default", execution.DebugInfo.PromptTemplate)
+
+	// Invalid templates are rejected
+	for _, content := range []string{"{{.UserInput", "{{.Projct.Name}}", "{{nosuchfunc .UserInput}}"} {
+		body, _ = json.Marshal(map[string]string{"name": "broken", "content": content})
+		req, _ = http.NewRequest("POST", "/api/prompt-templates", bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, content)
+	}
+
+	// A custom template overrides the default for the project
+	body, _ = json.Marshal(map[string]string{
+		"name":    "short",
+		"content": "{{.Project.Name}}: {{.UserInput}} ({{len .History}} previous{{range .History}}, last: {{.Summary}}{{end}})",
+	})
+	req, _ = http.NewRequest("POST", "/api/prompt-templates", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var short models.PromptTemplate
+	json.Unmarshal(w.Body.Bytes(), &short)
+
+	body, _ = json.Marshal(map[string]uint{"prompt_template_id": short.ID})
+	req, _ = http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	code, resp = preview(map[string]string{"command": "Hi"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "short", resp["prompt_template"])
+	assert.Equal(t, "tpl_project: Hi (1 previous, last: Read prompt from stdin)", resp["prompt"])
+
+	// Unsaved template content can be previewed too
+	code, resp = preview(map[string]string{"command": "Hi", "template": "{{upper .UserInput}}"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "HI", resp["prompt"])
+
+	// Templates in use and built-in templates cannot be deleted
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/prompt-templates/%d", short.ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/prompt-templates/%d", templates[0].ID), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestPromptTemplateSecrets(t *testing.T) {
+	r := setupRouter()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":           "secret_tpl_project",
+		"ai_cli_command": "echo {prompt}",
+		"directory_path": t.TempDir(),
+		"env_vars":       map[string]string{"API_KEY": "super-secret-value-123"},
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	preview := func(template string) (int, string) {
+		body, _ := json.Marshal(map[string]string{"command": "Hello", "template": template})
+		req, _ := http.NewRequest("POST", "/api/projects/1/prompt/preview", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	// Env var values and the agent profile are not available to templates
+	for _, template := range []string{"{{.Project.EnvVars}}", "{{.Project.AgentProfile}}"} {
+		code, resp := preview(template)
+		assert.Equal(t, http.StatusBadRequest, code, template)
+		assert.NotContains(t, resp, "super-secret-value-123")
+
+		body, _ = json.Marshal(map[string]string{"name": "leaky", "content": template})
+		req, _ = http.NewRequest("POST", "/api/prompt-templates", bytes.NewBuffer(body))
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, template)
+	}
+
+	// Only the names are exposed
+	code, resp := preview("{{.Project.EnvVarNames}}")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, resp, `"prompt":"[API_KEY]"`)
+	code, resp = preview("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, resp, "super-secret-value-123")
+
+	// The executor's prompt builder cannot render them either
+	var project models.Project
+	database.DB.First(&project, 1)
+	assert.Equal(t, "super-secret-value-123", project.EnvVars["API_KEY"])
+	execution := models.Execution{ProjectID: project.ID, Command: "Hello"}
+	_, err := executor.BuildExecutionPrompt(&project, &execution, "{{.Project.EnvVars}}")
+	assert.Error(t, err)
+	prompt, err := executor.BuildExecutionPrompt(&project, &execution, "")
+	assert.NoError(t, err)
+	assert.NotContains(t, prompt.Prompt, "super-secret-value-123")
+}
+
+func TestPromptHistory(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	body, _ := json.Marshal(map[string]string{
+		"name":            "history_project",
+		"ai_cli_command":  cwd + "/mock_stdin_ai_cli.sh",
+		"prompt_delivery": "stdin",
+		"directory_path":  t.TempDir(),
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	for _, e := range []models.Execution{
+		{ProjectID: 1, Command: "first task", Status: models.StatusCompleted, Summary: "did first", CreatedFiles: []string{"a.txt"}},
+		{ProjectID: 1, Command: "second task", Status: models.StatusFailed, ErrorMessage: "boom"},
+		{ProjectID: 1, Command: "third task", Status: models.StatusCompleted, Summary: "did third", ModifiedFiles: []string{"b.txt"}},
+		{ProjectID: 1, Command: "fourth task", Status: models.StatusCompleted, Summary: "did fourth"},
+	} {
+		database.DB.Create(&e)
+	}
+
+	update := func(payload map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("PUT", "/api/projects/1", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	preview := func() ([]uint, string) {
+		body, _ := json.Marshal(map[string]string{"command": "next"})
+		req, _ := http.NewRequest("POST", "/api/projects/1/prompt/preview", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp struct {
+			Prompt     string `json:"prompt"`
+			HistoryIDs []uint `json:"history_ids"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.HistoryIDs, resp.Prompt
+	}
+
+	// By default only the last completed runs are used, without errors or files
+	ids, prompt := preview()
+	assert.Equal(t, []uint{1, 3, 4}, ids)
+	assert.NotContains(t, prompt, "boom")
+	assert.NotContains(t, prompt, "變更檔案")
+
+	// Failed runs with their error and changed files can be included
+	update(map[string]interface{}{"history_include_failed": true, "history_include_files": true})
+	ids, prompt = preview()
+	assert.Equal(t, []uint{1, 2, 3, 4}, ids)
+	assert.Contains(t, prompt, "\t- 錯誤: boom\n")
+	assert.Contains(t, prompt, "\t- 變更檔案: A a.txt\n")
+	assert.Contains(t, prompt, "\t- 變更檔案: M b.txt\n")
+
+	// The count limits the number of recent runs
+	update(map[string]interface{}{"history_count": 2})
+	ids, _ = preview()
+	assert.Equal(t, []uint{3, 4}, ids)
+
+	// The character budget drops older runs first
+	update(map[string]interface{}{"history_count": -1, "history_max_chars": 25})
+	ids, _ = preview()
+	assert.Equal(t, []uint{4}, ids)
+
+	// Pinned runs are always included and count against the budget
+	req, _ = http.NewRequest("POST", "/api/executions/1/pin", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	ids, _ = preview()
+	assert.Equal(t, []uint{1}, ids)
+
+	update(map[string]interface{}{"history_count": 0, "history_max_chars": 0})
+	ids, _ = preview()
+	assert.Equal(t, []uint{1}, ids)
+
+	req, _ = http.NewRequest("POST", "/api/executions/99/pin", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// The history used by a run is recorded and can be inspected
+	body, _ = json.Marshal(map[string]string{"command": "next"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	time.Sleep(time.Second)
+
+	req, _ = http.NewRequest("GET", "/api/executions/5/history", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var history struct {
+		HistoryIDs []uint             `json:"history_ids"`
+		History    []models.Execution `json:"history"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &history)
+	assert.Equal(t, []uint{1}, history.HistoryIDs)
+	if assert.Len(t, history.History, 1) {
+		assert.Equal(t, "first task", history.History[0].Command)
+		assert.True(t, history.History[0].Pinned)
+	}
+
+	// Unpinning removes the run from later prompts
+	req, _ = http.NewRequest("POST", "/api/executions/1/unpin", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	ids, _ = preview()
+	assert.Empty(t, ids)
+}
+
+func TestProjectMemory(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	projectDir := t.TempDir()
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":               "memory_project",
+		"ai_cli_command":     cwd + "/mock_memory_ai_cli.sh",
+		"prompt_delivery":    "stdin",
+		"directory_path":     projectDir,
+		"memory_auto_update": true,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	send := func(method, url string, payload interface{}) (int, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	// The memory can be replaced and appended to
+	code, _ := send("PUT", "/api/projects/1/memory", map[string]string{"memory": "Build with make"})
+	assert.Equal(t, http.StatusOK, code)
+	code, resp := send("POST", "/api/projects/1/memory/notes", map[string]string{"note": "Never touch\nvendor/"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Build with make\n- Never touch vendor/\n", resp["memory"])
+
+	code, _ = send("POST", "/api/projects/1/memory/notes", map[string]string{"note": "   "})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = send("POST", "/api/projects/99/memory/notes", map[string]string{"note": "x"})
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = send("PUT", "/api/projects/1/memory", map[string]string{"memory": strings.Repeat("x", 20001)})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+
+	// The memory and the memory_updates instructions are part of the prompt
+	code, resp = send("POST", "/api/projects/1/prompt/preview", map[string]string{"command": "Hello"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, resp["prompt"], "【專案記憶】\nBuild with make\n- Never touch vendor/\n")
+	assert.Contains(t, resp["prompt"], `"memory_updates"`)
+
+	// Memory updates returned by the agent are appended
+	code, _ = send("POST", "/api/projects/1/run", map[string]string{"command": "Hello"})
+	assert.Equal(t, http.StatusAccepted, code)
+	time.Sleep(time.Second)
+
+	var execution models.Execution
+	database.DB.First(&execution, 1)
+	assert.Equal(t, models.StatusCompleted, execution.Status)
+	assert.Equal(t, []string{"Use tabs for indentation"}, execution.MemoryUpdates)
+	stdin, _ := os.ReadFile(filepath.Join(projectDir, "stdin.txt"))
+	assert.Contains(t, string(stdin), "- Never touch vendor/\n")
+
+	code, resp = send("GET", "/api/projects/1/memory", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Build with make\n- Never touch vendor/\n- Use tabs for indentation\n", resp["memory"])
+
+	// Without memory_auto_update the updates are only recorded
+	code, resp = send("PUT", "/api/projects/1", map[string]bool{"memory_auto_update": false})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Build with make\n- Never touch vendor/\n- Use tabs for indentation\n", resp["memory"])
+	code, resp = send("POST", "/api/projects/1/prompt/preview", map[string]string{"command": "Hello"})
+	assert.NotContains(t, resp["prompt"], `"memory_updates"`)
+	send("POST", "/api/projects/1/run", map[string]string{"command": "Hello again"})
+	time.Sleep(time.Second)
+
+	database.DB.First(&execution, 2)
+	assert.Equal(t, []string{"Use tabs for indentation"}, execution.MemoryUpdates)
+	_, resp = send("GET", "/api/projects/1/memory", nil)
+	assert.Equal(t, "Build with make\n- Never touch vendor/\n- Use tabs for indentation\n", resp["memory"])
+}
+
+func TestSessions(t *testing.T) {
+	r := setupRouter()
+	defer executor.SetSessionIdleTimeout(0)
+
+	cwd, _ := os.Getwd()
+	projectDir := t.TempDir()
+	body, _ := json.Marshal(map[string]string{
+		"name":            "session_project",
+		"ai_cli_command":  cwd + "/mock_stdin_ai_cli.sh",
+		"prompt_delivery": "stdin",
+		"directory_path":  projectDir,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	body, _ = json.Marshal(map[string]string{"command": "first task"})
+	req, _ = http.NewRequest("POST", "/api/projects/1/run", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	time.Sleep(time.Second)
+
+	// Continuing an execution starts a session with it as the first turn
+	execution, err := executor.ContinueSession(1, 42, "follow up", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotNil(t, execution.SessionID)
+	time.Sleep(time.Second)
+
+	var first, second models.Execution
+	database.DB.First(&first, 1)
+	database.DB.First(&second, 2)
+	assert.Equal(t, models.StatusCompleted, second.Status)
+	if assert.NotNil(t, first.SessionID) && assert.NotNil(t, second.SessionID) {
+		assert.Equal(t, *first.SessionID, *second.SessionID)
+	}
+
+	// The prior turn is part of the prompt as conversation, not as history
+	stdin, _ := os.ReadFile(filepath.Join(projectDir, "stdin.txt"))
+	prompt := string(stdin)
+	assert.Contains(t, prompt, "【對話延續】")
+	assert.Contains(t, prompt, "- 第 1 輪\n\t- 指令: first task\n")
+	assert.Contains(t, prompt, "Read prompt from stdin")
+	assert.NotContains(t, prompt, "過去執行歷史")
+	assert.True(t, strings.HasSuffix(prompt, "【任務內容】\nfollow up"))
+
+	req, _ = http.NewRequest("GET", "/api/sessions/1", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var session models.Session
+	json.Unmarshal(w.Body.Bytes(), &session)
+	assert.Equal(t, int64(42), session.ChatID)
+	assert.False(t, session.Expired)
+	if assert.Len(t, session.Executions, 2) {
+		assert.Equal(t, "first task", session.Executions[0].Command)
+		assert.Equal(t, "follow up", session.Executions[1].Command)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/sessions/99", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Idle sessions expire and cannot be continued
+	executor.SetSessionIdleTimeout(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	_, err = executor.ContinueSession(2, 42, "too late", nil)
+	assert.Equal(t, executor.ErrSessionExpired, err)
+
+	req, _ = http.NewRequest("GET", "/api/sessions/1", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	json.Unmarshal(w.Body.Bytes(), &session)
+	assert.True(t, session.Expired)
+}
+
+func TestSessionResume(t *testing.T) {
+	r := setupRouter()
+
+	cwd, _ := os.Getwd()
+	createProfile := func(payload map[string]interface{}) (int, models.AgentProfile) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", "/api/agent-profiles", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var profile models.AgentProfile
+		json.Unmarshal(w.Body.Bytes(), &profile)
+		return w.Code, profile
+	}
+
+	// Resume arguments need exactly the {session_id} placeholder
+	for _, args := range []string{"--resume", "--resume {model}", "--resume '{session_id}"} {
+		code, _ := createProfile(map[string]interface{}{
+			"name":             "broken-resume",
+			"command_template": cwd + "/mock_resume_ai_cli.sh",
+			"prompt_delivery":  "stdin",
+			"resume_args":      args,
+		})
+		assert.Equal(t, http.StatusBadRequest, code, args)
+	}
+
+	code, profile := createProfile(map[string]interface{}{
+		"name":             "resumable",
+		"command_template": cwd + "/mock_resume_ai_cli.sh",
+		"prompt_delivery":  "stdin",
+		"output_parser":    "jsonl",
+		"resume_args":      "--resume {session_id}",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+
+	projectDir := t.TempDir()
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":             "resume_project",
+		"agent_profile_id": profile.ID,
+		"directory_path":   projectDir,
+	})
+	req, _ := http.NewRequest("POST", "/api/projects", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var project models.Project
+	json.Unmarshal(w.Body.Bytes(), &project)
+
+	body, _ = json.Marshal(map[string]string{"command": "first task"})
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/projects/%d/run", project.ID), bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	time.Sleep(time.Second)
+
+	// The parser extracts the CLI session ID
+	var first models.Execution
+	database.DB.Last(&first)
+	assert.Equal(t, models.StatusCompleted, first.Status)
+	assert.Equal(t, "sess-new", first.CLISessionID)
+	assert.Empty(t, first.ResumedCLISessionID)
+
+	// A follow-up in the same session resumes the CLI session instead of re-pasting the conversation
+	second, err := executor.ContinueSession(first.ID, 0, "follow up", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	time.Sleep(time.Second)
+	database.DB.First(second, second.ID)
+	assert.Equal(t, models.StatusCompleted, second.Status)
+	assert.Equal(t, "sess-new", second.ResumedCLISessionID)
+	assert.Equal(t, "sess-new", second.CLISessionID)
+	assert.Equal(t, []string{"--resume", "sess-new"}, second.DebugInfo.Command[1:])
+	args, _ := os.ReadFile(filepath.Join(projectDir, "args.txt"))
+	assert.Equal(t, "--resume\nsess-new\n", string(args))
+	stdin, _ := os.ReadFile(filepath.Join(projectDir, "stdin.txt"))
+	assert.NotContains(t, string(stdin), "【對話延續】")
+	assert.True(t, strings.HasSuffix(string(stdin), "【任務內容】\nfollow up"))
+
+	// Without resume support the conversation falls back to the prompt
+	body, _ = json.Marshal(map[string]string{"resume_args": ""})
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/agent-profiles/%d", profile.ID), bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	third, err := executor.ContinueSession(second.ID, 0, "one more", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	time.Sleep(time.Second)
+	database.DB.First(third, third.ID)
+	assert.Equal(t, models.StatusCompleted, third.Status)
+	assert.Empty(t, third.ResumedCLISessionID)
+	args, _ = os.ReadFile(filepath.Join(projectDir, "args.txt"))
+	assert.Equal(t, "\n", string(args))
+	stdin, _ = os.ReadFile(filepath.Join(projectDir, "stdin.txt"))
+	assert.Contains(t, string(stdin), "【對話延續】")
+	assert.Contains(t, string(stdin), "- 第 2 輪\n\t- 指令: follow up\n")
+}
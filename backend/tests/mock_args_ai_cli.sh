@@ -0,0 +1,17 @@
+#!/bin/bash
+# Mock AI CLI for testing - records its arguments (one per line) and the prompt file content
+
+printf '%s\n' "$@" > args.txt
+for arg in "$@"; do
+  case "$arg" in
+    --prompt-file=*) cp "${arg#--prompt-file=}" prompt.txt ;;
+  esac
+done
+
+printf '{\n'
+printf '  "status": "success",\n'
+printf '  "summary": "Recorded arguments",\n'
+printf '  "modified_files": [],\n'
+printf '  "created_files": [],\n'
+printf '  "deleted_files": []\n'
+printf '}\n'
@@ -0,0 +1,5 @@
+#!/bin/bash
+# Mock AI CLI for testing - takes a moment before outputting valid JSON
+
+sleep 1
+echo '{"status": "success", "summary": "Delayed execution completed", "modified_files": [], "created_files": [], "deleted_files": []}'
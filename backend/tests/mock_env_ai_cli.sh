@@ -0,0 +1,13 @@
+#!/bin/bash
+# Mock AI CLI for testing - records the AGENT_MODE environment variable and its arguments
+
+printf '%s\n' "$AGENT_MODE" > env.txt
+printf '%s\n' "$@" > args.txt
+
+printf '{\n'
+printf '  "status": "success",\n'
+printf '  "summary": "Recorded environment",\n'
+printf '  "modified_files": [],\n'
+printf '  "created_files": [],\n'
+printf '  "deleted_files": []\n'
+printf '}\n'
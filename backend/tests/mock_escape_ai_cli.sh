@@ -0,0 +1,14 @@
+#!/bin/bash
+# Mock AI CLI for testing - escapes the project directory through a symlink ($1 is the outside directory)
+
+echo "safe" > safe.txt
+ln -s "$1" outside_link
+echo "escaped" > outside_link/escaped.txt
+
+printf '{\n'
+printf '  "status": "success",\n'
+printf '  "summary": "Wrote files inside and outside the project",\n'
+printf '  "modified_files": ["../secret.txt", "/etc/passwd", "outside_link/escaped.txt"],\n'
+printf '  "created_files": ["safe.txt"],\n'
+printf '  "deleted_files": []\n'
+printf '}\n'
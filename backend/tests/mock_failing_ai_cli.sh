@@ -0,0 +1,5 @@
+#!/bin/bash
+# Mock AI CLI for testing - always fails
+
+echo "something went wrong" >&2
+exit 1
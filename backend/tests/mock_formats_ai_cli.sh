@@ -0,0 +1,33 @@
+#!/bin/bash
+# Mock AI CLI for testing - prints its result in the format given by $1
+
+case "$1" in
+json)
+    echo 'Loaded config {"level": "debug"} and a stray brace {'
+    printf '{"status": "success", "summary": "JSON among logs", "modified_files": ["a.txt"], "created_files": [], "deleted_files": []}\n'
+    echo 'Done } {not json}'
+    ;;
+fenced)
+    echo 'Here is what I did:'
+    echo '```json'
+    echo '{"status": "success", "summary": "Fenced result", "modified_files": [], "created_files": ["b.txt"], "deleted_files": []}'
+    echo '```'
+    ;;
+jsonl)
+    echo '{"type": "system", "subtype": "init", "session_id": "abc"}'
+    echo '{"type": "assistant", "message": {"content": [{"type": "text", "text": "Working"}]}}'
+    echo 'not an event'
+    # A single event far longer than bufio.Scanner's default 64KB line limit
+    printf '{"type": "user", "tool_result": "%s"}\n' "$(head -c 200000 /dev/zero | tr '\0' x)"
+    echo '{"type": "result", "subtype": "success", "is_error": false, "result": "Stream finished"}'
+    ;;
+jsonl_error)
+    echo '{"type": "system", "subtype": "init", "session_id": "abc"}'
+    echo '{"type": "result", "subtype": "error_during_execution", "is_error": true, "result": "Tool call failed"}'
+    ;;
+text)
+    for i in $(seq 1 30); do
+        echo "line $i"
+    done
+    ;;
+esac
@@ -0,0 +1,23 @@
+#!/bin/bash
+# Mock AI CLI for testing - changes files without necessarily reporting them ($1 is "silent" or "report")
+
+echo "changed" >> edit.txt
+rm remove.txt
+echo "new" > new.txt
+mkdir -p sub node_modules/pkg
+echo "nested" > sub/nested.txt
+echo "dependency" > node_modules/pkg/index.js
+echo "log" > debug.log
+
+if [ "$1" = "silent" ]; then
+    echo "Done, but I forgot to print the JSON report"
+    exit 0
+fi
+
+printf '{\n'
+printf '  "status": "success",\n'
+printf '  "summary": "Reported only the new file",\n'
+printf '  "modified_files": [],\n'
+printf '  "created_files": ["new.txt"],\n'
+printf '  "deleted_files": []\n'
+printf '}\n'
@@ -0,0 +1,39 @@
+#!/bin/bash
+# Mock AI CLI for testing - exercises resource limits depending on the first argument:
+#   verbose: prints a lot of output before the JSON result
+#   cpu:     spins until the CPU time limit kills it
+#   memory:  records its memory limit and aborts like an allocation failure
+#   oom_log: fails with "out of memory" in its output but no allocation failure
+#   cpu_log: fails with "CPU time limit exceeded" in its output but no SIGXCPU
+
+case "$1" in
+  verbose)
+    for i in $(seq 1 200); do
+      echo "line $i: lorem ipsum dolor sit amet"
+    done
+    ;;
+  cpu)
+    while :; do :; done
+    ;;
+  memory)
+    ulimit -d > ulimit.txt
+    echo "terminate called after throwing an instance of 'std::bad_alloc'" >&2
+    kill -ABRT $$
+    ;;
+  oom_log)
+    echo "grep: found 'out of memory' in server.log" >&2
+    exit 1
+    ;;
+  cpu_log)
+    echo "grep: found 'CPU time limit exceeded' in build.log" >&2
+    exit 1
+    ;;
+esac
+
+printf '{\n'
+printf '  "status": "success",\n'
+printf '  "summary": "Finished within limits",\n'
+printf '  "modified_files": [],\n'
+printf '  "created_files": [],\n'
+printf '  "deleted_files": []\n'
+printf '}\n'
@@ -0,0 +1,6 @@
+#!/bin/bash
+# Mock AI CLI for testing project memory - saves the prompt read from stdin and returns a memory update
+
+cat > stdin.txt
+
+printf '{"status": "success", "summary": "Learned something", "memory_updates": ["Use tabs for indentation"]}\n'
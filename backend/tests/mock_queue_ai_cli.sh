@@ -0,0 +1,7 @@
+#!/bin/bash
+# Mock AI CLI for testing - logs when execution $1 starts and ends in queue.log
+
+echo "start $1" >> queue.log
+sleep 0.5
+echo "end $1" >> queue.log
+echo '{"status": "success", "summary": "Queued execution completed", "modified_files": [], "created_files": [], "deleted_files": []}'
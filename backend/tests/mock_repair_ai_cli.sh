@@ -0,0 +1,12 @@
+#!/bin/bash
+# Mock AI CLI for testing - emits an invalid result until asked to repair it
+# $1 is "fixable" (repairs when asked) or "stubborn" (never does); the prompt is the last argument
+
+prompt="${!#}"
+if [ "$1" = "fixable" ] && [[ "$prompt" == *"JSON Schema"* ]]; then
+    printf '{"status": "success", "summary": "Repaired result", "modified_files": ["a.txt"]}\n'
+    exit 0
+fi
+
+echo "Finished the task"
+printf '{"status": "done", "summary": "Invalid result", "modified_files": ["/tmp/a.txt"]}\n'
@@ -0,0 +1,14 @@
+#!/bin/bash
+# Mock AI CLI for testing session resume - streams JSON events with a session ID,
+# keeping the ID given by "--resume <id>" or starting a new session
+
+cat > stdin.txt
+printf '%s\n' "$@" > args.txt
+
+session="sess-new"
+if [ "$1" = "--resume" ]; then
+    session="$2"
+fi
+
+echo "{\"type\": \"system\", \"subtype\": \"init\", \"session_id\": \"$session\"}"
+echo '{"type": "result", "subtype": "success", "is_error": false, "result": "{\"status\": \"success\", \"summary\": \"Resumable run\"}"}'
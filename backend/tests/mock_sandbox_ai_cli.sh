@@ -0,0 +1,12 @@
+#!/bin/bash
+# Mock AI CLI for testing - reports a write that a sandbox would block
+
+echo "touch: cannot touch '/etc/agent-test': Read-only file system" >&2
+
+printf '{\n'
+printf '  "status": "success",\n'
+printf '  "summary": "Tried to write outside the project",\n'
+printf '  "modified_files": [],\n'
+printf '  "created_files": [],\n'
+printf '  "deleted_files": []\n'
+printf '}\n'
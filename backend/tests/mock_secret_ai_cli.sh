@@ -0,0 +1,14 @@
+#!/bin/bash
+# Mock AI CLI for testing - records and prints the API_TOKEN it received
+
+printf '%s\n' "$API_TOKEN" > token.txt
+printf '%s\n' "${HOST_SECRET:-unset}" > host.txt
+echo "Authenticating with $API_TOKEN"
+
+printf '{\n'
+printf '  "status": "success",\n'
+printf '  "summary": "Used token %s",\n' "$API_TOKEN"
+printf '  "modified_files": [],\n'
+printf '  "created_files": [],\n'
+printf '  "deleted_files": []\n'
+printf '}\n'
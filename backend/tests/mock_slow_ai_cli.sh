@@ -0,0 +1,6 @@
+#!/bin/bash
+# Mock AI CLI for testing - prints partial output, then hangs until killed
+
+echo "working..."
+sleep 30
+echo '{"status": "success", "summary": "should never be printed"}'
@@ -0,0 +1,13 @@
+#!/bin/bash
+# Mock AI CLI for testing - saves the prompt read from stdin and its arguments
+
+cat > stdin.txt
+printf '%s\n' "$@" > args.txt
+
+printf '{\n'
+printf '  "status": "success",\n'
+printf '  "summary": "Read prompt from stdin",\n'
+printf '  "modified_files": [],\n'
+printf '  "created_files": [],\n'
+printf '  "deleted_files": []\n'
+printf '}\n'
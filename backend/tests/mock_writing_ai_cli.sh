@@ -0,0 +1,13 @@
+#!/bin/bash
+# Mock AI CLI for testing - edits files in the working directory and misreports them
+
+echo "changed" >> README.md
+echo "new file" > generated.txt
+
+printf '{\n'
+printf '  "status": "success",\n'
+printf '  "summary": "Edited files",\n'
+printf '  "modified_files": ["wrong.txt"],\n'
+printf '  "created_files": [],\n'
+printf '  "deleted_files": []\n'
+printf '}\n'